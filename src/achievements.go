@@ -0,0 +1,562 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Summit sets achievement rules are defined over
+const (
+	achievementSetAll     = "all"
+	achievementSetRidge   = "ridge"
+	achievementSetHighest = "highest"
+	achievementSetMain    = "main"
+	// achievementSetSeason is special: the first dated ascent
+	// of a calendar year among all users
+	achievementSetSeason = "season"
+)
+
+// AchievementRule describes which summits have to be climbed to earn a badge
+type AchievementRule struct {
+	Id          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Set         string `json:"-"`
+	// Size limits the set to that many highest summits, 0 means no limit
+	Size int `json:"-"`
+	// Count is how many summits of the set to climb, 0 means all of them
+	Count int `json:"-"`
+	// WithinYear requires the summits to be climbed within one calendar year
+	WithinYear bool `json:"-"`
+}
+
+var achievementRules = []AchievementRule{
+	{Id: "ridge", Title: "Покоритель хребта", Description: "Все вершины хребта", Set: achievementSetRidge},
+	{Id: "ridge-year", Title: "Хребет за сезон", Description: "Все вершины хребта за один календарный год",
+		Set: achievementSetRidge, WithinYear: true},
+	{Id: "top10", Title: "Десять высочайших", Description: "Десять самых высоких вершин",
+		Set: achievementSetHighest, Size: 10},
+	{Id: "main", Title: "Главные вершины", Description: "Высшие точки всех хребтов", Set: achievementSetMain},
+	{Id: "summits-50", Title: "50 вершин", Description: "50 вершин-тысячников", Set: achievementSetAll, Count: 50},
+	{Id: "summits-100", Title: "100 вершин", Description: "100 вершин-тысячников", Set: achievementSetAll, Count: 100},
+	{Id: "summits-all", Title: "Все тысячники", Description: "Все вершины каталога", Set: achievementSetAll},
+	{Id: "season-first", Title: "Открытие сезона", Description: "Первое восхождение календарного года",
+		Set: achievementSetSeason},
+}
+
+func findAchievementRule(id string) *AchievementRule {
+	for i := range achievementRules {
+		if achievementRules[i].Id == id {
+			return &achievementRules[i]
+		}
+	}
+	return nil
+}
+
+// UserAchievement is a badge awarded to user. Scope tells which ridge or year
+// the badge is for and is empty for catalog-wide rules.
+type UserAchievement struct {
+	Id          string      `json:"id"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Scope       string      `json:"scope,omitempty"`
+	ScopeName   string      `json:"scope_name,omitempty"`
+	SummitId    string      `json:"summit_id"`
+	SummitName  *string     `json:"summit_name"`
+	Date        InexactDate `json:"date"`
+	AwardedAt   time.Time   `json:"awarded_at"`
+}
+
+// SummitAchievement is a badge earned with an ascent of the summit
+type SummitAchievement struct {
+	UserId    int64       `json:"user_id"`
+	UserName  string      `json:"user_name"`
+	Id        string      `json:"id"`
+	Title     string      `json:"title"`
+	Scope     string      `json:"scope,omitempty"`
+	ScopeName string      `json:"scope_name,omitempty"`
+	Date      InexactDate `json:"date"`
+}
+
+// achievementCatalog holds summit sets rules are evaluated against
+type achievementCatalog struct {
+	// ordered by height descending
+	summits []string
+	ridges  map[string][]string
+	main    []string
+}
+
+type achievementAscent struct {
+	id       int64
+	summitId string
+	date     InexactDate
+}
+
+// award is a badge earned with the ascent completing the rule
+type award struct {
+	ruleId, scope string
+	ascent        achievementAscent
+}
+
+func (a award) key() string {
+	return a.ruleId + "/" + a.scope
+}
+
+// dateLess orders ascents by date, undated ones go last
+func (a achievementAscent) dateLess(b achievementAscent) bool {
+	for _, p := range [][2]int64{{a.date.Year, b.date.Year}, {a.date.Month, b.date.Month}, {a.date.Day, b.date.Day}} {
+		if p[0] != p[1] {
+			if p[0] == 0 || p[1] == 0 {
+				return p[1] == 0
+			}
+			return p[0] < p[1]
+		}
+	}
+	return a.id < b.id
+}
+
+func fetchAchievementCatalog(tx *sql.Tx) (*achievementCatalog, error) {
+	rows, err := tx.Query(`SELECT id, ridge_id, height = MAX(height) OVER (PARTITION BY ridge_id)
+		FROM summits ORDER BY height DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	c := &achievementCatalog{ridges: make(map[string][]string)}
+	for rows.Next() {
+		var id, ridgeId string
+		var isMain bool
+		if err := rows.Scan(&id, &ridgeId, &isMain); err != nil {
+			return nil, err
+		}
+		c.summits = append(c.summits, id)
+		c.ridges[ridgeId] = append(c.ridges[ridgeId], id)
+		if isMain {
+			c.main = append(c.main, id)
+		}
+	}
+	return c, rows.Err()
+}
+
+// completeSet returns the ascent by which count summits of the set were climbed,
+// or false if they were not. Only ascents within one year count if year is set.
+func completeSet(set []string, count int, ascents []achievementAscent, year int64) (achievementAscent, bool) {
+	if count == 0 {
+		count = len(set)
+	}
+	if count == 0 || count > len(set) {
+		return achievementAscent{}, false
+	}
+	inSet := make(map[string]bool, len(set))
+	for _, id := range set {
+		inSet[id] = true
+	}
+	// ascents are sorted by date, so the first one of each summit is taken
+	climbed := make(map[string]bool)
+	for _, a := range ascents {
+		if !inSet[a.summitId] || climbed[a.summitId] || (year != 0 && a.date.Year != year) {
+			continue
+		}
+		climbed[a.summitId] = true
+		if len(climbed) == count {
+			return a, true
+		}
+	}
+	return achievementAscent{}, false
+}
+
+// evaluateRule returns awards of the rule for user's ascents sorted by date
+func evaluateRule(rule AchievementRule, catalog *achievementCatalog, ascents []achievementAscent) []award {
+	sets := make(map[string][]string)
+	switch rule.Set {
+	case achievementSetAll:
+		sets[""] = catalog.summits
+	case achievementSetHighest:
+		sets[""] = catalog.summits[:min(rule.Size, len(catalog.summits))]
+	case achievementSetMain:
+		sets[""] = catalog.main
+	case achievementSetRidge:
+		sets = catalog.ridges
+	}
+	years := []int64{0}
+	if rule.WithinYear {
+		years = years[:0]
+		for _, a := range ascents {
+			if a.date.Year != 0 && (len(years) == 0 || years[len(years)-1] != a.date.Year) {
+				years = append(years, a.date.Year)
+			}
+		}
+	}
+
+	awards := make([]award, 0)
+	for scope, set := range sets {
+		// the earliest year wins for badges earned within a year
+		for _, year := range years {
+			if a, ok := completeSet(set, rule.Count, ascents, year); ok {
+				awards = append(awards, award{rule.Id, scope, a})
+				break
+			}
+		}
+	}
+	return awards
+}
+
+func fetchAchievementAscents(tx *sql.Tx, userId int64) ([]achievementAscent, error) {
+	rows, err := tx.Query("SELECT id, summit_id, year, month, day FROM climbs WHERE user_id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ascents := make([]achievementAscent, 0)
+	for rows.Next() {
+		var a achievementAscent
+		var year, month, day sql.NullInt64
+		if err := rows.Scan(&a.id, &a.summitId, &year, &month, &day); err != nil {
+			return nil, err
+		}
+		a.date = InexactDate{year.Int64, month.Int64, day.Int64}
+		ascents = append(ascents, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(ascents, func(i, j int) bool { return ascents[i].dateLess(ascents[j]) })
+	return ascents, nil
+}
+
+// saveAward stores user's badge, keeping award time if it was earned before
+func saveAward(tx *sql.Tx, userId int64, a award, now time.Time) error {
+	_, err := tx.Exec(`INSERT INTO achievements
+		(user_id, achievement_id, scope, summit_id, year, month, day, awarded_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, achievement_id, scope) DO UPDATE SET
+			summit_id=excluded.summit_id,
+			year=excluded.year, month=excluded.month, day=excluded.day`,
+		userId, a.ruleId, a.scope, a.ascent.summitId,
+		nullInt(a.ascent.date.Year), nullInt(a.ascent.date.Month), nullInt(a.ascent.date.Day), now)
+	return err
+}
+
+// saveAwards makes user's badges of given rules match awards,
+// keeping award time of the badges earned before
+func saveAwards(tx *sql.Tx, userId int64, ruleIds []string, awards []award) error {
+	existing := make(map[string]award)
+	for _, ruleId := range ruleIds {
+		rows, err := tx.Query("SELECT scope FROM achievements WHERE user_id = ? AND achievement_id = ?", userId, ruleId)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			a := award{ruleId: ruleId}
+			if err := rows.Scan(&a.scope); err != nil {
+				rows.Close()
+				return err
+			}
+			existing[a.key()] = a
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, a := range awards {
+		delete(existing, a.key())
+		if err := saveAward(tx, userId, a, now); err != nil {
+			return err
+		}
+	}
+	// badges whose conditions do not hold any more are revoked
+	for _, a := range existing {
+		_, err := tx.Exec("DELETE FROM achievements WHERE user_id = ? AND achievement_id = ? AND scope = ?",
+			userId, a.ruleId, a.scope)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nullInt(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: v != 0}
+}
+
+// updateSeasonAchievement awards the first fully dated ascent of the year.
+// It depends on everybody's climbs, so badges of all users are updated.
+func updateSeasonAchievement(tx *sql.Tx, year int64) error {
+	rows, err := tx.Query(`SELECT id, user_id, summit_id, month, day FROM climbs
+		WHERE year = ? AND month IS NOT NULL AND day IS NOT NULL
+		ORDER BY month, day, user_id, id`, year)
+	if err != nil {
+		return err
+	}
+	scope := strconv.FormatInt(year, 10)
+	awards := make(map[int64]award)
+	var first InexactDate
+	for rows.Next() {
+		var userId int64
+		a := achievementAscent{date: InexactDate{Year: year}}
+		if err := rows.Scan(&a.id, &userId, &a.summitId, &a.date.Month, &a.date.Day); err != nil {
+			rows.Close()
+			return err
+		}
+		if first.Year == 0 {
+			first = a.date
+		}
+		if a.date != first {
+			break
+		}
+		// the same user may open the season with several summits
+		if _, ok := awards[userId]; !ok {
+			awards[userId] = award{"season-first", scope, a}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	holders, err := tx.Query("SELECT user_id FROM achievements WHERE achievement_id = 'season-first' AND scope = ?", scope)
+	if err != nil {
+		return err
+	}
+	revoked := make([]int64, 0)
+	for holders.Next() {
+		var userId int64
+		if err := holders.Scan(&userId); err != nil {
+			holders.Close()
+			return err
+		}
+		if _, ok := awards[userId]; !ok {
+			revoked = append(revoked, userId)
+		}
+	}
+	holders.Close()
+	if err := holders.Err(); err != nil {
+		return err
+	}
+	for _, userId := range revoked {
+		_, err := tx.Exec("DELETE FROM achievements WHERE user_id = ? AND achievement_id = 'season-first' AND scope = ?",
+			userId, scope)
+		if err != nil {
+			return err
+		}
+	}
+	now := time.Now().UTC()
+	for userId, a := range awards {
+		if err := saveAward(tx, userId, a, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateSeasonAchievements awards the first fully dated ascents of the years
+func updateSeasonAchievements(tx *sql.Tx, years []int64) error {
+	updated := make(map[int64]bool)
+	for _, year := range years {
+		if year == 0 || updated[year] {
+			continue
+		}
+		updated[year] = true
+		if err := updateSeasonAchievement(tx, year); err != nil {
+			return fmt.Errorf("failed to update season achievements of %d: %v", year, err)
+		}
+	}
+	return nil
+}
+
+func updateUserAchievements(tx *sql.Tx, catalog *achievementCatalog, userId int64) error {
+	ascents, err := fetchAchievementAscents(tx, userId)
+	if err != nil {
+		return fmt.Errorf("failed to fetch climbs: %v", err)
+	}
+	ruleIds := make([]string, 0, len(achievementRules))
+	awards := make([]award, 0)
+	for _, rule := range achievementRules {
+		if rule.Set == achievementSetSeason {
+			continue
+		}
+		ruleIds = append(ruleIds, rule.Id)
+		awards = append(awards, evaluateRule(rule, catalog, ascents)...)
+	}
+	return saveAwards(tx, userId, ruleIds, awards)
+}
+
+// UpdateAchievements reevaluates achievement rules over user's climbs,
+// awarding new badges and revoking those whose conditions do not hold anymore.
+// Season badges are reevaluated for the years only.
+func (s *Storage) UpdateAchievements(userId int64, years ...int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	catalog, err := fetchAchievementCatalog(tx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch summits: %v", err)
+	}
+	if err := updateUserAchievements(tx, catalog, userId); err != nil {
+		return err
+	}
+	if err := updateSeasonAchievements(tx, years); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// climbsChanged reevaluates badges after user's climbs dated by the years
+// are changed. Climbs are already saved at this point, so failure is only logged.
+func (s *Storage) climbsChanged(userId int64, years ...int64) {
+	if err := s.UpdateAchievements(userId, years...); err != nil {
+		slog.Error("Failed to update achievements", "userId", userId, "error", err)
+	}
+}
+
+// UpdateAllAchievements reevaluates achievements of every user who has climbs
+// or badges, needed after catalog changes
+func (s *Storage) UpdateAllAchievements() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	catalog, err := fetchAchievementCatalog(tx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch summits: %v", err)
+	}
+	rows, err := tx.Query("SELECT user_id FROM climbs UNION SELECT user_id FROM achievements")
+	if err != nil {
+		return err
+	}
+	userIds := make([]int64, 0)
+	for rows.Next() {
+		var userId int64
+		if err := rows.Scan(&userId); err != nil {
+			rows.Close()
+			return err
+		}
+		userIds = append(userIds, userId)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, userId := range userIds {
+		if err := updateUserAchievements(tx, catalog, userId); err != nil {
+			return fmt.Errorf("failed to update achievements of user %d: %v", userId, err)
+		}
+	}
+	years, err := fetchSeasonYears(tx)
+	if err != nil {
+		return err
+	}
+	if err := updateSeasonAchievements(tx, years); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// fetchSeasonYears returns years having dated climbs or season badges
+func fetchSeasonYears(tx *sql.Tx) ([]int64, error) {
+	rows, err := tx.Query(`SELECT DISTINCT year FROM climbs WHERE year IS NOT NULL
+		UNION SELECT CAST(scope AS INTEGER) FROM achievements WHERE achievement_id = 'season-first'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	years := make([]int64, 0)
+	for rows.Next() {
+		var year int64
+		if err := rows.Scan(&year); err != nil {
+			return nil, err
+		}
+		years = append(years, year)
+	}
+	return years, rows.Err()
+}
+
+// scopeName returns human readable scope of the badge: ridge name or year
+func scopeName(rule *AchievementRule, scope string, ridgeName sql.NullString) string {
+	if rule.Set == achievementSetRidge {
+		return ridgeName.String
+	}
+	return scope
+}
+
+// FetchUserAchievements returns user's badges, the latest earned first
+func (s *Storage) FetchUserAchievements(userId int64) ([]UserAchievement, error) {
+	rows, err := s.db.Query(`SELECT a.achievement_id, a.scope, r.name, a.summit_id, s.name,
+			a.year, a.month, a.day, a.awarded_at
+		FROM achievements a
+			LEFT JOIN ridges r ON r.id = a.scope
+			LEFT JOIN summits s ON s.id = a.summit_id
+		WHERE a.user_id = ?
+		ORDER BY a.year DESC NULLS LAST, a.month DESC NULLS LAST, a.day DESC NULLS LAST, a.achievement_id, a.scope`,
+		userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	achievements := make([]UserAchievement, 0)
+	for rows.Next() {
+		var a UserAchievement
+		var ridgeName sql.NullString
+		var year, month, day sql.NullInt64
+		err := rows.Scan(&a.Id, &a.Scope, &ridgeName, &a.SummitId, &a.SummitName,
+			&year, &month, &day, &a.AwardedAt)
+		if err != nil {
+			return nil, err
+		}
+		rule := findAchievementRule(a.Id)
+		if rule == nil {
+			// rule was retired, its badges are not shown
+			continue
+		}
+		a.Title, a.Description = rule.Title, rule.Description
+		a.ScopeName = scopeName(rule, a.Scope, ridgeName)
+		a.Date = InexactDate{year.Int64, month.Int64, day.Int64}
+		achievements = append(achievements, a)
+	}
+	return achievements, rows.Err()
+}
+
+// FetchSummitAchievements returns badges earned with ascents of the summit
+func (s *Storage) FetchSummitAchievements(summitId string) ([]SummitAchievement, error) {
+	rows, err := s.db.Query(`SELECT a.user_id, u.name, a.achievement_id, a.scope, r.name,
+			a.year, a.month, a.day
+		FROM achievements a
+			INNER JOIN users u ON u.id = a.user_id
+			LEFT JOIN ridges r ON r.id = a.scope
+		WHERE a.summit_id = ?
+		ORDER BY a.year ASC NULLS LAST, a.month ASC NULLS LAST, a.day ASC NULLS LAST, a.user_id, a.achievement_id`,
+		summitId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	achievements := make([]SummitAchievement, 0)
+	for rows.Next() {
+		var a SummitAchievement
+		var ridgeName sql.NullString
+		var year, month, day sql.NullInt64
+		err := rows.Scan(&a.UserId, &a.UserName, &a.Id, &a.Scope, &ridgeName, &year, &month, &day)
+		if err != nil {
+			return nil, err
+		}
+		rule := findAchievementRule(a.Id)
+		if rule == nil {
+			continue
+		}
+		a.Title = rule.Title
+		a.ScopeName = scopeName(rule, a.Scope, ridgeName)
+		a.Date = InexactDate{year.Int64, month.Int64, day.Int64}
+		achievements = append(achievements, a)
+	}
+	return achievements, rows.Err()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateRule(t *testing.T) {
+	catalog := &achievementCatalog{
+		summits: []string{"a1", "b1", "a2", "b2", "a3"},
+		ridges:  map[string][]string{"a": {"a1", "a2", "a3"}, "b": {"b1", "b2"}},
+		main:    []string{"a1", "b1"},
+	}
+	ascent := func(id int64, summitId string, year, month, day int64) achievementAscent {
+		return achievementAscent{id, summitId, InexactDate{year, month, day}}
+	}
+	// sorted by date as fetchAchievementAscents returns them
+	ascents := []achievementAscent{
+		ascent(1, "b1", 2019, 7, 1),
+		ascent(2, "a1", 2020, 6, 1),
+		ascent(3, "a2", 2020, 6, 2),
+		ascent(4, "b2", 2021, 5, 0),
+		ascent(5, "a3", 2021, 8, 3),
+		ascent(6, "a1", 2021, 8, 4),
+		ascent(7, "a2", 2021, 9, 0),
+		ascent(8, "b1", 0, 0, 0),
+	}
+
+	cases := []struct {
+		name     string
+		rule     AchievementRule
+		expected map[string]int64
+	}{
+		{"all summits of ridge", AchievementRule{Id: "r", Set: achievementSetRidge},
+			map[string]int64{"r/a": 5, "r/b": 4}},
+		{"ridge within a year", AchievementRule{Id: "r", Set: achievementSetRidge, WithinYear: true},
+			map[string]int64{"r/a": 7}},
+		{"highest", AchievementRule{Id: "h", Set: achievementSetHighest, Size: 3},
+			map[string]int64{"h/": 3}},
+		{"highest set larger than catalog", AchievementRule{Id: "h", Set: achievementSetHighest, Size: 10},
+			map[string]int64{"h/": 5}},
+		{"main summits", AchievementRule{Id: "m", Set: achievementSetMain},
+			map[string]int64{"m/": 2}},
+		{"count", AchievementRule{Id: "c", Set: achievementSetAll, Count: 4},
+			map[string]int64{"c/": 4}},
+		{"count larger than catalog", AchievementRule{Id: "c", Set: achievementSetAll, Count: 50},
+			map[string]int64{}},
+		{"count within a year", AchievementRule{Id: "c", Set: achievementSetAll, Count: 3, WithinYear: true},
+			map[string]int64{"c/": 6}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			awards := make(map[string]int64)
+			for _, a := range evaluateRule(tt.rule, catalog, ascents) {
+				awards[a.key()] = a.ascent.id
+			}
+			assert.Equal(t, tt.expected, awards)
+		})
+	}
+}
+
+func TestUpdateAchievements(t *testing.T) {
+	db := MockDatabase(t)
+	storage := NewStorage(db)
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+
+	badges := func(userId int64) map[string]InexactDate {
+		achievements, err := storage.FetchUserAchievements(userId)
+		require.NoError(t, err)
+		result := make(map[string]InexactDate)
+		for _, a := range achievements {
+			result[a.Id+"/"+a.Scope] = a.Date
+		}
+		return result
+	}
+
+	require.NoError(t, storage.UpdateAchievements(5, 1990, 1992))
+	assert.Equal(t, map[string]InexactDate{
+		"ridge/kurkak":      {1990, 3, 7},
+		"ridge-year/kurkak": {1990, 3, 7},
+		// both malidak ascents are undated
+		"ridge/malidak":     {},
+		"season-first/1990": {1990, 3, 7},
+	}, badges(5))
+	// season badges depend on everybody's climbs and are updated for everyone
+	assert.Equal(t, map[string]InexactDate{"season-first/1992": {1992, 4, 18}}, badges(2))
+	// other seasons are not reevaluated
+	assert.Empty(t, badges(6))
+
+	achievements, err := storage.FetchUserAchievements(5)
+	require.NoError(t, err)
+	require.NotEmpty(t, achievements)
+	for _, a := range achievements {
+		if a.Id == "ridge" && a.Scope == "malidak" {
+			assert.Equal(t, "Малидак", a.ScopeName)
+			assert.Equal(t, "Покоритель хребта", a.Title)
+		}
+	}
+
+	// an earlier ascent moves the dates and opens an earlier season
+	ascentId, err := storage.AddAscent("kurkak", 5, InexactDate{1989, 1, 1}, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]InexactDate{
+		"ridge/kurkak":      {1989, 1, 1},
+		"ridge-year/kurkak": {1989, 1, 1},
+		"ridge/malidak":     {},
+		"season-first/1989": {1989, 1, 1},
+		"season-first/1990": {1990, 3, 7},
+	}, badges(5))
+
+	// moving an ascent to another year reevaluates both seasons,
+	// the season opened the same day is shared
+	_, err = storage.UpdateAscent(ascentId, 5, InexactDate{2016, 5, 12}, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]InexactDate{
+		"ridge/kurkak":      {1990, 3, 7},
+		"ridge-year/kurkak": {1990, 3, 7},
+		"ridge/malidak":     {},
+		"season-first/1990": {1990, 3, 7},
+		"season-first/2016": {2016, 5, 12},
+	}, badges(5))
+	assert.Equal(t, map[string]InexactDate{"season-first/2016": {2016, 5, 12}}, badges(6))
+
+	// badges are revoked once their conditions do not hold
+	require.NoError(t, storage.DeleteClimb("kurkak", 5))
+	assert.Equal(t, map[string]InexactDate{"ridge/malidak": {}}, badges(5))
+	assert.Equal(t, map[string]InexactDate{"season-first/1990": {1990, 9, 6}}, badges(1))
+	assert.Equal(t, map[string]InexactDate{"season-first/2016": {2016, 5, 12}}, badges(6))
+
+	summitAchievements, err := storage.FetchSummitAchievements("malinovaja")
+	require.NoError(t, err)
+	assert.Equal(t, []SummitAchievement{
+		{UserId: 5, UserName: "Jonathan Nguyen", Id: "ridge", Title: "Покоритель хребта",
+			Scope: "malidak", ScopeName: "Малидак"},
+	}, summitAchievements)
+
+	// imported climbs are evaluated as well
+	_, err = storage.ImportAscents(5, []AscentImportItem{{SummitId: "kurkak", Date: InexactDate{1989, 1, 1}}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]InexactDate{
+		"ridge/kurkak":      {1989, 1, 1},
+		"ridge-year/kurkak": {1989, 1, 1},
+		"ridge/malidak":     {},
+		"season-first/1989": {1989, 1, 1},
+	}, badges(5))
+}
+
+func TestUpdateAllAchievements(t *testing.T) {
+	db := MockDatabase(t)
+	storage := NewStorage(db)
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+	require.NoError(t, storage.UpdateAllAchievements())
+
+	// user 6 climbed stolby only, which does not complete its ridge
+	achievements, err := storage.FetchUserAchievements(6)
+	require.NoError(t, err)
+	ids := make([]string, len(achievements))
+	for i, a := range achievements {
+		ids[i] = a.Id + "/" + a.Scope
+	}
+	assert.Equal(t, []string{"season-first/2016"}, ids)
+
+	var holders int
+	require.NoError(t, db.QueryRow(
+		"SELECT COUNT(DISTINCT user_id) FROM achievements WHERE achievement_id = 'ridge' AND scope = 'kurkak'").Scan(&holders))
+	assert.Equal(t, 9, holders)
+}
@@ -1,17 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
-	"github.com/tkrajina/gpxgo/gpx"
 )
 
 const (
@@ -34,18 +37,20 @@ var serverError = &ApiError{internalServerErrorMsg, http.StatusInternalServerErr
 var authRequired = &ApiError{authRequiredMsg, http.StatusUnauthorized}
 
 type Api struct {
-	Config  *RuntimeConfig
-	Storage *Storage
-	SM      *scs.SessionManager
-	router  *chi.Mux
+	Config       *RuntimeConfig
+	Storage      *Storage
+	SM           *scs.SessionManager
+	ImageManager ImageManager
+	router       *chi.Mux
 }
 
-func NewApi(config *RuntimeConfig, storage *Storage, sm *scs.SessionManager) *Api {
+func NewApi(config *RuntimeConfig, storage *Storage, sm *scs.SessionManager, imageManager ImageManager) *Api {
 	api := &Api{
-		Config:  config,
-		Storage: storage,
-		SM:      sm,
-		router:  chi.NewRouter(),
+		Config:       config,
+		Storage:      storage,
+		SM:           sm,
+		ImageManager: imageManager,
+		router:       chi.NewRouter(),
 	}
 
 	// Set up routes
@@ -53,14 +58,50 @@ func NewApi(config *RuntimeConfig, storage *Storage, sm *scs.SessionManager) *Ap
 	api.router.Put("/summit/{ridgeId}/{summitId}", api.handleSummitPut)
 	api.router.Delete("/summit/{ridgeId}/{summitId}", api.handleSummitDelete)
 	api.router.Get("/summit/{ridgeId}/{summitId}/climbs", api.handleSummitClimbs)
+	api.router.Get("/summit/{ridgeId}/{summitId}/ascents", api.handleSummitAscents)
+	api.router.Get("/summit/{ridgeId}/{summitId}/nearby", api.handleSummitNearby)
+	api.router.Get("/summit/{ridgeId}/{summitId}/atom", api.handleSummitAtom)
+	api.router.Post("/summit/{ridgeId}/{summitId}/ascents", api.handleSummitAscentPost)
+	api.router.Put("/ascent/{ascentId}", api.handleAscentPut)
+	api.router.Delete("/ascent/{ascentId}", api.handleAscentDelete)
+	api.router.Get("/ascent/{ascentId}/track", api.handleAscentTrack)
+	api.router.Get("/ascent/{ascentId}/partners", api.handleAscentPartners)
+	api.router.Put("/ascent/{ascentId}/partners", api.handleAscentPartnersPut)
+	api.router.Post("/ascent/{ascentId}/photos", api.handleAscentPhotosPost)
+	api.router.Delete("/photo/{photoId}", api.handlePhotoDelete)
 	api.router.Get("/summits", api.handleSummits)
 	api.router.Get("/summits/gpx", api.handleSummitsGPX)
+	api.router.Get("/summits/kml", api.handleSummitsKML)
+	api.router.Get("/summits/near", api.handleSummitsNear)
+	api.router.Get("/summits/geojson", api.handleSummitsGeoJSON)
+	api.router.Get("/plan", api.handlePlan)
+	api.router.Get("/plan/gpx", api.handlePlanGPX)
+	api.router.Get("/catalog/changes", api.handleCatalogChanges)
+	api.router.Get("/search", api.handleSearch)
 	api.router.Get("/top", api.handleTop)
 	api.router.Get("/top/year", api.handleTopYear)
+	api.router.Get("/feed", api.handleFeed)
+	api.router.Get("/feed/atom", api.handleFeedAtom)
 	api.router.Get("/user/me", api.handleUserMe)
+	api.router.Get("/user/me/climbs/export", api.handleClimbsExport)
+	api.router.Get("/user/me/following", api.handleFollowing)
+	api.router.Get("/user/me/followers", api.handleFollowers)
+	api.router.Get("/user/me/timeline", api.handleTimeline)
+	api.router.Get("/user/me/invitations", api.handleInvitations)
+	api.router.Post("/user/me/invitations/{climbId}/accept", api.handleInvitationAccept)
+	api.router.Post("/user/me/invitations/{climbId}/decline", api.handleInvitationDecline)
+	api.router.Post("/user/me/climbs/import", api.handleClimbsImport)
+	api.router.Post("/user/me/climbs/import/gpx", api.handleImportGPX)
+	api.router.Post("/user/me/climbs/import/gpx/confirm", api.handleImportGPXConfirm)
 	api.router.Get("/user/{userId}", api.handleUser)
 	api.router.Get("/user/{userId}/climbs", api.handleUserClimbs)
+	api.router.Get("/user/{userId}/atom", api.handleUserAtom)
+	api.router.Put("/user/{userId}/follow", api.handleUserFollow)
+	api.router.Delete("/user/{userId}/follow", api.handleUserUnfollow)
+	api.router.Get("/user/{userId}/climbs/archived", api.handleUserArchivedClimbs)
 	api.router.Get("/user/{userId}/missing", api.handleUserMissingSummits)
+	api.router.Get("/user/{userId}/missing/{format:gpx|kml}", api.handleUserMissingExport)
+	api.router.Get("/user/{userId}/climbs/{format:gpx|kml}", api.handleUserClimbsExport)
 
 	return api
 }
@@ -91,6 +132,20 @@ func (h *Api) handleSummitGet(w http.ResponseWriter, r *http.Request) {
 		h.writeError(w, pathNotFoundError)
 		return
 	}
+	summit.Achievements, err = h.Storage.FetchSummitAchievements(summit.Id)
+	if err != nil {
+		slog.Error("Failed to fetch summit achievements", "summitId", summit.Id, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	if userId != 0 {
+		summit.FollowedClimbers, err = h.Storage.CountFollowedClimbers(userId, summit.Id)
+		if err != nil {
+			slog.Error("Failed to count followed climbers", "summitId", summit.Id, "error", err)
+			h.writeError(w, serverError)
+			return
+		}
+	}
 
 	h.writeJSON(w, summit)
 }
@@ -116,22 +171,20 @@ func (h *Api) handleSummitPut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	comment := r.PostFormValue("comment")
-	date := r.PostFormValue("date")
-	var ied InexactDate
-	err = ied.Parse(date)
-	if err != nil {
-		h.writeError(w, &ApiError{"Invalid date format", http.StatusBadRequest})
+	form, apiErr := h.parseClimbForm(w, r, summit)
+	if apiErr != nil {
+		h.writeError(w, apiErr)
 		return
 	}
 
-	err = h.Storage.UpdateClimb(summit.Id, userId, ied, comment)
+	_, err = h.Storage.UpdateClimb(summit.Id, userId, form.Date, form.Comment, form.Track)
 	if err != nil {
 		slog.Error("Failed to update climb", "error", err)
 		h.writeError(w, serverError)
 		return
 	}
-	slog.Info("Climb updated", "userId", userId, "summitId", summit.Id, "date", date, "comment", comment)
+	slog.Info("Climb updated", "userId", userId, "summitId", summit.Id, "date", r.PostFormValue("date"),
+		"comment", form.Comment, "track", form.Track != nil)
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -144,26 +197,91 @@ func (h *Api) handleSummitDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	summitId := chi.URLParam(r, "summitId")
-	err := h.Storage.DeleteClimb(summitId, userId)
+	photos, err := h.Storage.FetchSummitPhotoKeys(summitId, userId)
+	if err != nil {
+		slog.Error("Failed to fetch climb photos", "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	err = h.Storage.DeleteClimb(summitId, userId)
 	if err != nil {
 		slog.Error("Failed to delete climb", "error", err)
 		h.writeError(w, serverError)
 		return
 	}
 	slog.Info("Climb deleted", "userId", userId, "summitId", summitId)
+	h.deleteImages(r.Context(), photos)
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *Api) handleSummitClimbs(w http.ResponseWriter, r *http.Request) {
-	ridgeId := chi.URLParam(r, "ridgeId")
-	SummitId := chi.URLParam(r, "summitId")
+func (h *Api) trackRadius() float64 {
+	if h.Config.TrackRadius <= 0 {
+		return DefaultTrackRadius
+	}
+	return h.Config.TrackRadius
+}
 
-	// Check if summit exists to correctly handle this case
-	// (we do not want to return empty list for non-existing summit)
-	summit, err := h.Storage.FetchSummit(SummitId, 0)
+type climbForm struct {
+	Date    InexactDate
+	Comment string
+	Track   *ClimbTrack
+}
+
+// parseClimbForm reads climb date, comment and optional GPX track from
+// request form. The track is checked against summit coordinates, and
+// date of the climb is taken from it when not given explicitly.
+func (h *Api) parseClimbForm(w http.ResponseWriter, r *http.Request, summit *Summit) (*climbForm, *ApiError) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxTrackSize)
+	if err := r.ParseMultipartForm(maxTrackSize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, &ApiError{"Request is too large", http.StatusRequestEntityTooLarge}
+		}
+		return nil, &ApiError{"Invalid request body", http.StatusBadRequest}
+	}
+
+	form := &climbForm{Comment: r.PostFormValue("comment")}
+	if err := form.Date.Parse(r.PostFormValue("date")); err != nil {
+		return nil, &ApiError{"Invalid date format", http.StatusBadRequest}
+	}
+
+	file, _, err := r.FormFile("track")
+	if errors.Is(err, http.ErrMissingFile) || errors.Is(err, http.ErrNotMultipart) {
+		return form, nil
+	}
 	if err != nil {
-		slog.Error("Failed to find summit", "ridgeId", ridgeId, "summitId", SummitId, "error", err)
+		return nil, &ApiError{"Invalid track upload", http.StatusBadRequest}
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, &ApiError{"Invalid track upload", http.StatusBadRequest}
+	}
+	form.Track, err = AnalyzeTrack(data, float64(summit.Coordinates[0]), float64(summit.Coordinates[1]), h.trackRadius())
+	if err != nil {
+		slog.Info("Invalid track uploaded", "summitId", summit.Id, "error", err)
+		return nil, &ApiError{"Invalid GPX track", http.StatusBadRequest}
+	}
+	if form.Date == (InexactDate{}) {
+		form.Date = form.Track.Date
+	}
+	return form, nil
+}
+
+// handleSummitAscents lists all current user's ascents of the summit
+func (h *Api) handleSummitAscents(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+
+	ridgeId := chi.URLParam(r, "ridgeId")
+	summitId := chi.URLParam(r, "summitId")
+	summit, err := h.Storage.FetchSummit(summitId, 0)
+	if err != nil {
+		slog.Error("Failed to fetch summit", "ridgeId", ridgeId, "summitId", summitId, "error", err)
 		h.writeError(w, serverError)
 		return
 	}
@@ -172,202 +290,1632 @@ func (h *Api) handleSummitClimbs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	page := 1
-	pageParam := r.URL.Query()["page"]
-	if len(pageParam) == 1 {
-		if p, err := strconv.Atoi(pageParam[0]); err == nil && p > 0 {
-			page = p
-		}
+	ascents, err := h.Storage.FetchUserAscents(userId, summit.Id)
+	if err != nil {
+		slog.Error("Failed to fetch ascents", "userId", userId, "summitId", summit.Id, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	h.writeJSON(w, ascents)
+}
+
+// handleSummitAscentPost registers one more ascent of the summit
+func (h *Api) handleSummitAscentPost(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
 	}
 
-	climbs, totalClimbs, err := h.Storage.FetchSummitClimbs(summit.Id, page, h.Config.ItemsPerPage)
+	ridgeId := chi.URLParam(r, "ridgeId")
+	summitId := chi.URLParam(r, "summitId")
+	summit, err := h.Storage.FetchSummit(summitId, 0)
 	if err != nil {
-		slog.Error("Failed to fetch climbs for summit", "ridgeId", ridgeId, "summitId", SummitId, "realId", summit.Id, "error", err)
+		slog.Error("Failed to fetch summit", "ridgeId", ridgeId, "summitId", summitId, "error", err)
 		h.writeError(w, serverError)
 		return
 	}
+	if summit == nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
 
-	response := struct {
-		Climbs      []SummitClimb `json:"climbs"`
-		TotalClimbs int           `json:"total_climbs"`
-		Page        int           `json:"page"`
-	}{
-		Climbs:      climbs,
-		TotalClimbs: totalClimbs,
-		Page:        page,
+	form, apiErr := h.parseClimbForm(w, r, summit)
+	if apiErr != nil {
+		h.writeError(w, apiErr)
+		return
 	}
+	ascentId, err := h.Storage.AddAscent(summit.Id, userId, form.Date, form.Comment, form.Track)
+	if err != nil {
+		slog.Error("Failed to add ascent", "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	ascent := Ascent{Id: ascentId, SummitId: summit.Id, Date: form.Date, Comment: form.Comment}
+	if form.Track != nil {
+		ascent.HasTrack, ascent.TrackVerified = true, form.Track.Verified
+	}
+	slog.Info("Ascent added", "userId", userId, "summitId", summit.Id, "ascentId", ascentId)
 
-	h.writeJSON(w, response)
+	h.writeJSON(w, ascent)
 }
 
-func (h *Api) handleSummits(w http.ResponseWriter, r *http.Request) {
+func (h *Api) handleAscentPut(w http.ResponseWriter, r *http.Request) {
 	userId := h.SM.GetInt64(r.Context(), UserIdKey)
-	summits, err := h.Storage.FetchSummits(userId)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+	ascentId, err := strconv.ParseInt(chi.URLParam(r, "ascentId"), 10, 64)
 	if err != nil {
-		slog.Error("Failed to fetch summits from db", "error", err)
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+
+	ascent, err := h.Storage.FetchAscent(ascentId, userId)
+	if err != nil {
+		slog.Error("Failed to fetch ascent", "ascentId", ascentId, "error", err)
 		h.writeError(w, serverError)
 		return
 	}
-	h.writeJSON(w, summits)
+	if ascent == nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	summit, err := h.Storage.FetchSummit(ascent.SummitId, 0)
+	if err != nil || summit == nil {
+		slog.Error("Failed to fetch summit", "summitId", ascent.SummitId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+
+	form, apiErr := h.parseClimbForm(w, r, summit)
+	if apiErr != nil {
+		h.writeError(w, apiErr)
+		return
+	}
+	_, err = h.Storage.UpdateAscent(ascentId, userId, form.Date, form.Comment, form.Track)
+	if err != nil {
+		slog.Error("Failed to update ascent", "ascentId", ascentId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	slog.Info("Ascent updated", "userId", userId, "ascentId", ascentId, "track", form.Track != nil)
+
+	w.WriteHeader(http.StatusOK)
 }
 
-func (h *Api) handleSummitsGPX(w http.ResponseWriter, r *http.Request) {
+func (h *Api) handleAscentDelete(w http.ResponseWriter, r *http.Request) {
 	userId := h.SM.GetInt64(r.Context(), UserIdKey)
-	summits, err := h.Storage.FetchSummits(userId)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+	ascentId, err := strconv.ParseInt(chi.URLParam(r, "ascentId"), 10, 64)
 	if err != nil {
-		slog.Error("Failed to fetch summits from db", "error", err)
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+
+	photos, err := h.Storage.FetchAscentPhotoKeys(ascentId, userId)
+	if err != nil {
+		slog.Error("Failed to fetch climb photos", "ascentId", ascentId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	found, err := h.Storage.DeleteAscent(ascentId, userId)
+	if err != nil {
+		slog.Error("Failed to delete ascent", "ascentId", ascentId, "error", err)
 		h.writeError(w, serverError)
 		return
 	}
+	if !found {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	slog.Info("Ascent deleted", "userId", userId, "ascentId", ascentId)
+	h.deleteImages(r.Context(), photos)
+
+	w.WriteHeader(http.StatusOK)
+}
 
-	// Create GPX structure
-	gpxData := gpx.GPX{
-		Version: "1.1",
-		Creator: "Тысячники Южного Урала",
+// handleAscentPhotosPost attaches photos from multipart "photo" fields
+// to current user's ascent. Photos are checked all together before
+// any of them is stored.
+func (h *Api) handleAscentPhotosPost(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+	ascentId, err := strconv.ParseInt(chi.URLParam(r, "ascentId"), 10, 64)
+	if err != nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	ascent, err := h.Storage.FetchAscent(ascentId, userId)
+	if err != nil {
+		slog.Error("Failed to fetch ascent", "ascentId", ascentId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	if ascent == nil {
+		h.writeError(w, pathNotFoundError)
+		return
 	}
 
-	// Convert summits to GPX waypoints
-	for _, summit := range summits.Summits {
-		description := fmt.Sprintf("хр. %s", summit.RidgeName)
-		waypoint := gpx.GPXPoint{
-			Point: gpx.Point{
-				Latitude:  float64(summit.Lat),
-				Longitude: float64(summit.Lng),
-				Elevation: *gpx.NewNullableFloat64(float64(summit.Height)),
-			},
-			Name:        summit.Id,
-			Description: description,
+	r.Body = http.MaxBytesReader(w, r.Body, maxPhotosUpload)
+	if err := r.ParseMultipartForm(maxPhotosUpload); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.writeError(w, &ApiError{"Request is too large", http.StatusRequestEntityTooLarge})
+			return
 		}
-		gpxData.AppendWaypoint(&waypoint)
+		h.writeError(w, &ApiError{"Invalid request body", http.StatusBadRequest})
+		return
 	}
-
-	// Convert to XML
-	gpxXML, err := gpxData.ToXml(gpx.ToXmlParams{Version: "1.1", Indent: true})
+	files := r.MultipartForm.File["photo"]
+	if len(files) == 0 {
+		h.writeError(w, &ApiError{"No photos uploaded", http.StatusBadRequest})
+		return
+	}
+	existing, err := h.Storage.CountClimbPhotos(ascentId)
 	if err != nil {
-		slog.Error("Failed to generate GPX XML", "error", err)
+		slog.Error("Failed to count climb photos", "ascentId", ascentId, "error", err)
 		h.writeError(w, serverError)
 		return
 	}
+	if existing+len(files) > maxClimbPhotos {
+		h.writeError(w, &ApiError{errTooManyPhotos.Error(), http.StatusBadRequest})
+		return
+	}
 
-	// Set response headers
-	w.Header().Set("Content-Type", "application/gpx+xml")
-	w.Header().Set("Content-Disposition", "attachment; filename=summits.gpx")
-
-	// Write GPX XML to response
-	w.Write(gpxXML)
-}
+	prepared := make([][]Rendition, 0, len(files))
+	for _, fh := range files {
+		file, err := fh.Open()
+		if err != nil {
+			h.writeError(w, &ApiError{"Invalid photo upload", http.StatusBadRequest})
+			return
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			h.writeError(w, &ApiError{"Invalid photo upload", http.StatusBadRequest})
+			return
+		}
+		renditions, err := ProcessImage(data)
+		if err == errImageFormat || err == errImageTooLarge {
+			h.writeError(w, &ApiError{fmt.Sprintf("%s: %v", fh.Filename, err), http.StatusBadRequest})
+			return
+		}
+		if err != nil {
+			slog.Error("Failed to process photo", "ascentId", ascentId, "error", err)
+			h.writeError(w, serverError)
+			return
+		}
+		prepared = append(prepared, renditions)
+	}
 
-func parsePageParam(r *http.Request) (int, error) {
-	page := 1
-	pageParam := r.URL.Query()["page"]
-	errorInvalidPageParam := errors.New("invalid page parameter provided")
-	if pageParam != nil {
-		if len(pageParam) != 1 {
-			return 0, errorInvalidPageParam
+	photos := make([]ClimbPhoto, 0, len(prepared))
+	// the batch is added entirely or not at all
+	rollback := func() {
+		for _, p := range photos {
+			keys, err := h.Storage.DeleteClimbPhoto(p.Id, userId)
+			if err != nil {
+				slog.Error("Failed to remove climb photo", "photoId", p.Id, "error", err)
+				continue
+			}
+			h.deleteImages(r.Context(), keys)
 		}
-		var err error
-		page, err = strconv.Atoi(pageParam[0])
-		if err != nil || page <= 0 {
-			return 0, errorInvalidPageParam
+	}
+	for _, renditions := range prepared {
+		base, err := photoBase(userId, ascentId)
+		if err == nil {
+			err = UploadRenditions(r.Context(), h.ImageManager, base, renditions)
 		}
+		if err != nil {
+			rollback()
+			slog.Error("Failed to upload photo", "ascentId", ascentId, "error", err)
+			h.writeError(w, serverError)
+			return
+		}
+		// the large rendition goes first
+		p, err := h.Storage.AddClimbPhoto(ascentId, userId, base, renditions[0].Width, renditions[0].Height)
+		if err != nil {
+			h.deleteImages(r.Context(), renditionKeys(base))
+			rollback()
+			if err == errTooManyPhotos {
+				h.writeError(w, &ApiError{err.Error(), http.StatusBadRequest})
+				return
+			}
+			slog.Error("Failed to save climb photo", "ascentId", ascentId, "error", err)
+			h.writeError(w, serverError)
+			return
+		}
+		photos = append(photos, *p)
 	}
-	return page, nil
+	slog.Info("Climb photos added", "userId", userId, "ascentId", ascentId, "photos", len(photos))
+
+	h.writeJSON(w, struct {
+		Photos []ClimbPhoto `json:"photos"`
+	}{photos})
 }
 
-func (h *Api) handleTop(w http.ResponseWriter, r *http.Request) {
-	page, err := parsePageParam(r)
-	if err != nil {
-		h.writeError(w, &ApiError{err.Error(), http.StatusBadRequest})
+func (h *Api) handlePhotoDelete(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
 		return
 	}
-	h.serveTop(0, page, h.Config.ItemsPerPage, w)
-}
-
-func (h *Api) handleTopYear(w http.ResponseWriter, r *http.Request) {
-	page, err := parsePageParam(r)
+	photoId, err := strconv.ParseInt(chi.URLParam(r, "photoId"), 10, 64)
 	if err != nil {
-		h.writeError(w, &ApiError{err.Error(), http.StatusBadRequest})
+		h.writeError(w, pathNotFoundError)
 		return
 	}
-	year := time.Now().Year()
-	h.serveTop(year, page, h.Config.ItemsPerPage, w)
-}
-
-func (h *Api) serveTop(year, page, itemsPerPage int, w http.ResponseWriter) {
-	top, err := h.Storage.FetchTop(year, page, itemsPerPage)
+	keys, err := h.Storage.DeleteClimbPhoto(photoId, userId)
 	if err != nil {
-		slog.Error("Failed to fetch top", "error", err)
+		slog.Error("Failed to delete climb photo", "photoId", photoId, "error", err)
 		h.writeError(w, serverError)
 		return
 	}
-	h.writeJSON(w, top)
+	if keys == nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	slog.Info("Climb photo deleted", "userId", userId, "photoId", photoId)
+	h.deleteImages(r.Context(), keys)
+
+	w.WriteHeader(http.StatusOK)
 }
 
-func (h *Api) handleUserMe(w http.ResponseWriter, r *http.Request) {
+// deleteImages removes images which are not referenced anymore.
+// Records are already deleted at this point, so images failed to be
+// deleted are left to CleanupImages.
+func (h *Api) deleteImages(ctx context.Context, keys []string) {
+	// finish cleanup even if the client has gone
+	ctx = context.WithoutCancel(ctx)
+	stale := make([]string, 0)
+	for _, key := range keys {
+		if err := h.ImageManager.Delete(ctx, key); err != nil {
+			slog.Error("Failed to delete image", "key", key, "error", err)
+			stale = append(stale, key)
+		}
+	}
+	if err := h.Storage.AddStaleImages(stale); err != nil {
+		slog.Error("Failed to save stale images", "keys", stale, "error", err)
+	}
+}
+
+// handleAscentPartners lists users tagged on current user's ascent with their answers
+func (h *Api) handleAscentPartners(w http.ResponseWriter, r *http.Request) {
 	userId := h.SM.GetInt64(r.Context(), UserIdKey)
 	if userId == 0 {
 		h.writeError(w, authRequired)
 		return
 	}
-	h.handleUserById(w, r, userId)
-}
-
-func (h *Api) handleUser(w http.ResponseWriter, r *http.Request) {
-	userIdStr := chi.URLParam(r, "userId")
-	userId, err := strconv.ParseInt(userIdStr, 10, 64)
+	ascentId, err := strconv.ParseInt(chi.URLParam(r, "ascentId"), 10, 64)
 	if err != nil {
 		h.writeError(w, pathNotFoundError)
 		return
 	}
-	h.handleUserById(w, r, userId)
-}
-
-func (h *Api) handleUserById(w http.ResponseWriter, _ *http.Request, userId int64) {
-	user, err := h.Storage.GetUserById(userId)
+	ascent, err := h.Storage.FetchAscent(ascentId, userId)
 	if err != nil {
-		slog.Error("Failed to get user by ID", "userId", userId, "error", err)
+		slog.Error("Failed to fetch ascent", "ascentId", ascentId, "error", err)
 		h.writeError(w, serverError)
 		return
 	}
-	if user == nil {
-		slog.Warn("Unknown user id passed", "userId", userId)
+	if ascent == nil {
 		h.writeError(w, pathNotFoundError)
 		return
 	}
-	h.writeJSON(w, user)
+	h.writeClimbPartners(w, ascentId)
 }
 
-func (h *Api) handleUserClimbs(w http.ResponseWriter, r *http.Request) {
-	userIdStr := chi.URLParam(r, "userId")
-	userId, err := strconv.ParseInt(userIdStr, 10, 64)
+// handleAscentPartnersPut replaces partners of current user's ascent,
+// newly tagged users get invitations to confirm the climb
+func (h *Api) handleAscentPartnersPut(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+	ascentId, err := strconv.ParseInt(chi.URLParam(r, "ascentId"), 10, 64)
 	if err != nil {
 		h.writeError(w, pathNotFoundError)
 		return
 	}
+	if err := r.ParseForm(); err != nil {
+		h.writeError(w, &ApiError{"Invalid request body", http.StatusBadRequest})
+		return
+	}
+	partnerIds := make([]int64, 0)
+	for _, p := range r.PostForm["partner"] {
+		partnerId, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			h.writeError(w, &ApiError{"Invalid partner id", http.StatusBadRequest})
+			return
+		}
+		partnerIds = append(partnerIds, partnerId)
+	}
 
-	climbs, err := h.Storage.FetchUserClimbs(userId)
+	found, err := h.Storage.SetClimbPartners(ascentId, userId, partnerIds)
+	if err == errPartnerSelf || err == errUnknownPartner || err == errTooManyPartners {
+		h.writeError(w, &ApiError{err.Error(), http.StatusBadRequest})
+		return
+	}
 	if err != nil {
-		slog.Error("Failed to fetch climbs for user", "userId", userId, "error", err)
+		slog.Error("Failed to set climb partners", "ascentId", ascentId, "error", err)
 		h.writeError(w, serverError)
 		return
 	}
-	h.writeJSON(w, climbs)
-}
-
-func (h *Api) handleUserMissingSummits(w http.ResponseWriter, r *http.Request) {
-	userIdStr := chi.URLParam(r, "userId")
-	userId, err := strconv.ParseInt(userIdStr, 10, 64)
-	if err != nil {
+	if !found {
 		h.writeError(w, pathNotFoundError)
 		return
 	}
-	missingSummits, err := h.Storage.FetchUserMissingSummits(userId)
+	slog.Info("Climb partners set", "userId", userId, "ascentId", ascentId, "partners", partnerIds)
+	h.writeClimbPartners(w, ascentId)
+}
+
+func (h *Api) writeClimbPartners(w http.ResponseWriter, ascentId int64) {
+	partners, err := h.Storage.FetchClimbPartners(ascentId)
 	if err != nil {
-		slog.Error("Failed to fetch missing summits for user", "userId", userId, "error", err)
+		slog.Error("Failed to fetch climb partners", "ascentId", ascentId, "error", err)
 		h.writeError(w, serverError)
 		return
 	}
-	h.writeJSON(w, missingSummits)
+	h.writeJSON(w, struct {
+		Partners []ClimbPartner `json:"partners"`
+	}{partners})
+}
+
+func (h *Api) handleInvitations(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+	invitations, err := h.Storage.FetchInvitations(userId)
+	if err != nil {
+		slog.Error("Failed to fetch invitations", "userId", userId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	h.writeJSON(w, struct {
+		Invitations []Invitation `json:"invitations"`
+	}{invitations})
+}
+
+// handleInvitationAccept registers the climb for the current user
+// and returns the ascent linked to the partner's one
+func (h *Api) handleInvitationAccept(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+	climbId, err := strconv.ParseInt(chi.URLParam(r, "climbId"), 10, 64)
+	if err != nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	ascentId, err := h.Storage.AcceptInvitation(climbId, userId)
+	if err != nil {
+		slog.Error("Failed to accept invitation", "userId", userId, "climbId", climbId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	if ascentId == 0 {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	ascent, err := h.Storage.FetchAscent(ascentId, userId)
+	if err != nil || ascent == nil {
+		slog.Error("Failed to fetch ascent", "ascentId", ascentId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	slog.Info("Invitation accepted", "userId", userId, "climbId", climbId, "ascentId", ascentId)
+
+	h.writeJSON(w, ascent)
+}
+
+func (h *Api) handleInvitationDecline(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+	climbId, err := strconv.ParseInt(chi.URLParam(r, "climbId"), 10, 64)
+	if err != nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	found, err := h.Storage.DeclineInvitation(climbId, userId)
+	if err != nil {
+		slog.Error("Failed to decline invitation", "userId", userId, "climbId", climbId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	if !found {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	slog.Info("Invitation declined", "userId", userId, "climbId", climbId)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleAscentTrack returns GPX track attached to current user's ascent
+func (h *Api) handleAscentTrack(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+	ascentId, err := strconv.ParseInt(chi.URLParam(r, "ascentId"), 10, 64)
+	if err != nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+
+	track, err := h.Storage.FetchClimbTrack(ascentId, userId)
+	if err != nil {
+		slog.Error("Failed to fetch climb track", "ascentId", ascentId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	if track == nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=ascent-%d.gpx", ascentId))
+	w.Write(track)
+}
+
+// handleImportGPX detects ascents in uploaded zip archive of GPX tracks.
+// Nothing is saved until the user confirms the list.
+func (h *Api) handleImportGPX(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	file, _, err := r.FormFile("archive")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.writeError(w, &ApiError{"Request is too large", http.StatusRequestEntityTooLarge})
+			return
+		}
+		h.writeError(w, &ApiError{"Zip archive with tracks is required", http.StatusBadRequest})
+		return
+	}
+	defer file.Close()
+	archive, err := io.ReadAll(file)
+	if err != nil {
+		h.writeError(w, &ApiError{"Failed to read uploaded archive", http.StatusBadRequest})
+		return
+	}
+
+	summits, err := h.Storage.FetchSummits(0, SummitsQuery{})
+	if err != nil {
+		slog.Error("Failed to fetch summits", "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	result, err := DetectArchiveAscents(archive, summits.Summits, h.trackRadius())
+	if err != nil {
+		h.writeError(w, &ApiError{err.Error(), http.StatusBadRequest})
+		return
+	}
+
+	climbs, err := h.Storage.FetchUserClimbs(userId)
+	if err != nil {
+		slog.Error("Failed to fetch climbs for user", "userId", userId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	existing := make(map[string]*ClimbData, len(climbs))
+	for _, c := range climbs {
+		existing[c.Id] = c.ClimbData
+	}
+	for i := range result.Ascents {
+		result.Ascents[i].Existing = existing[result.Ascents[i].SummitId]
+	}
+	slog.Info("Tracks archive analyzed", "userId", userId,
+		"ascents", len(result.Ascents), "errors", len(result.Errors))
+
+	h.writeJSON(w, result)
+}
+
+// handleImportGPXConfirm saves ascents confirmed by user after import
+func (h *Api) handleImportGPXConfirm(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+
+	var request struct {
+		Ascents []AscentImportItem `json:"ascents"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); err != nil {
+		h.writeError(w, &ApiError{"Invalid request body", http.StatusBadRequest})
+		return
+	}
+	results, err := h.Storage.ImportAscents(userId, request.Ascents)
+	if err != nil {
+		slog.Error("Failed to import ascents", "userId", userId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	slog.Info("Ascents imported", "userId", userId, "items", len(results))
+
+	h.writeJSON(w, struct {
+		Results []AscentImportResult `json:"results"`
+	}{results})
+}
+
+// handleClimbsExport returns all current user's ascents as CSV or JSON
+func (h *Api) handleClimbsExport(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		h.writeError(w, &ApiError{"Unsupported export format", http.StatusBadRequest})
+		return
+	}
+
+	records, err := h.Storage.FetchUserClimbRecords(userId)
+	if err != nil {
+		slog.Error("Failed to fetch climbs for export", "userId", userId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	if format == "json" {
+		w.Header().Set("Content-Disposition", "attachment; filename=climbs.json")
+		h.writeJSON(w, records)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=climbs.csv")
+	if err = WriteClimbRecordsCSV(w, records); err != nil {
+		slog.Error("Failed to write climbs csv", "userId", userId, "error", err)
+	}
+}
+
+// handleClimbsImport registers climbs from uploaded CSV or JSON file
+// in the export format and reports the result for every row
+func (h *Api) handleClimbsImport(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxTrackSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.writeError(w, &ApiError{"Request is too large", http.StatusRequestEntityTooLarge})
+			return
+		}
+		h.writeError(w, &ApiError{"File with climbs is required", http.StatusBadRequest})
+		return
+	}
+	defer file.Close()
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(path.Ext(header.Filename)), ".")
+	}
+	var records []ClimbRecord
+	switch format {
+	case "csv":
+		records, err = ReadClimbRecordsCSV(file)
+	case "json":
+		records, err = ReadClimbRecordsJSON(file)
+	default:
+		h.writeError(w, &ApiError{"Unsupported import format", http.StatusBadRequest})
+		return
+	}
+	if err != nil {
+		h.writeError(w, &ApiError{fmt.Sprintf("Failed to read %s file: %v", format, err), http.StatusBadRequest})
+		return
+	}
+
+	results, err := h.Storage.ImportClimbRecords(userId, records)
+	if err != nil {
+		slog.Error("Failed to import climbs", "userId", userId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	slog.Info("Climbs imported", "userId", userId, "format", format, "rows", len(results))
+
+	h.writeJSON(w, struct {
+		Results []AscentImportResult `json:"results"`
+	}{results})
+}
+
+func (h *Api) handleSummitClimbs(w http.ResponseWriter, r *http.Request) {
+	ridgeId := chi.URLParam(r, "ridgeId")
+	SummitId := chi.URLParam(r, "summitId")
+
+	// Check if summit exists to correctly handle this case
+	// (we do not want to return empty list for non-existing summit)
+	summit, err := h.Storage.FetchSummit(SummitId, 0)
+	if err != nil {
+		slog.Error("Failed to find summit", "ridgeId", ridgeId, "summitId", SummitId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	if summit == nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+
+	page := 1
+	pageParam := r.URL.Query()["page"]
+	if len(pageParam) == 1 {
+		if p, err := strconv.Atoi(pageParam[0]); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	climbs, totalClimbs, err := h.Storage.FetchSummitClimbs(summit.Id, page, h.Config.ItemsPerPage)
+	if err != nil {
+		slog.Error("Failed to fetch climbs for summit", "ridgeId", ridgeId, "summitId", SummitId, "realId", summit.Id, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+
+	response := struct {
+		Climbs      []SummitClimb `json:"climbs"`
+		TotalClimbs int           `json:"total_climbs"`
+		Page        int           `json:"page"`
+	}{
+		Climbs:      climbs,
+		TotalClimbs: totalClimbs,
+		Page:        page,
+	}
+
+	h.writeJSON(w, response)
+}
+
+const maxSummitsPerPage = 100
+
+// parseSummitsQuery reads summits table filters, sorting and pagination
+// from request query parameters
+func parseSummitsQuery(r *http.Request, itemsPerPage int) (SummitsQuery, error) {
+	var q SummitsQuery
+	params := r.URL.Query()
+
+	q.RidgeIds = params["ridge"]
+	for _, bound := range []struct {
+		param string
+		value **int
+	}{
+		{"min_height", &q.MinHeight},
+		{"max_height", &q.MaxHeight},
+		{"min_prominence", &q.MinProminence},
+		{"max_prominence", &q.MaxProminence},
+		{"min_visitors", &q.MinVisitors},
+		{"max_visitors", &q.MaxVisitors},
+	} {
+		if !params.Has(bound.param) {
+			continue
+		}
+		v, err := strconv.Atoi(params.Get(bound.param))
+		if err != nil {
+			return q, fmt.Errorf("invalid %s parameter provided", bound.param)
+		}
+		*bound.value = &v
+	}
+	if params.Has("climbed") {
+		climbed, err := strconv.ParseBool(params.Get("climbed"))
+		if err != nil {
+			return q, errors.New("invalid climbed parameter provided")
+		}
+		q.Climbed = &climbed
+	}
+	if params.Has("bbox") {
+		parts := strings.Split(params.Get("bbox"), ",")
+		if len(parts) != 4 {
+			return q, errors.New("invalid bbox parameter provided")
+		}
+		var bbox [4]float64
+		for i, p := range parts {
+			v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil {
+				return q, errors.New("invalid bbox parameter provided")
+			}
+			bbox[i] = v
+		}
+		q.Bbox = &bbox
+	}
+	if params.Has("sort") {
+		q.Sort = params.Get("sort")
+		if _, ok := SummitsSortKeys[strings.TrimPrefix(q.Sort, "-")]; !ok {
+			return q, errors.New("invalid sort parameter provided")
+		}
+	}
+	// summits table is paginated only on demand
+	if params.Has("page") || params.Has("per_page") {
+		page, err := parsePageParam(r)
+		if err != nil {
+			return q, err
+		}
+		q.Page = page
+		q.PerPage = itemsPerPage
+		if params.Has("per_page") {
+			perPage, err := strconv.Atoi(params.Get("per_page"))
+			if err != nil || perPage <= 0 || perPage > maxSummitsPerPage {
+				return q, errors.New("invalid per_page parameter provided")
+			}
+			q.PerPage = perPage
+		}
+	}
+	return q, nil
+}
+
+// parseSummitsExportQuery reads summits table filters for exports,
+// which always hold all the matching summits
+func parseSummitsExportQuery(r *http.Request) (SummitsQuery, error) {
+	params := r.URL.Query()
+	if params.Has("page") || params.Has("per_page") {
+		return SummitsQuery{}, errors.New("pagination parameters are not allowed for exports")
+	}
+	return parseSummitsQuery(r, 0)
+}
+
+func (h *Api) handleSummitNearby(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	summitId := chi.URLParam(r, "summitId")
+	summit, err := h.Storage.FetchSummit(summitId, 0)
+	if err != nil {
+		slog.Error("Failed to find summit", "summitId", summitId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	if summit == nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+
+	limit := DefaultNearbyLimit
+	if r.URL.Query().Has("limit") {
+		limit, err = strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 || limit > maxNearbyLimit {
+			h.writeError(w, &ApiError{"invalid limit parameter provided", http.StatusBadRequest})
+			return
+		}
+	}
+	summits, err := h.Storage.FetchNearestSummits(userId, summit, limit)
+	if err != nil {
+		slog.Error("Failed to fetch nearby summits", "summitId", summit.Id, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	h.writeJSON(w, struct {
+		Summits []NearbySummit `json:"summits"`
+	}{summits})
+}
+
+func (h *Api) handleSummitsNear(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	params := r.URL.Query()
+	lat, err := strconv.ParseFloat(params.Get("lat"), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		h.writeError(w, &ApiError{"invalid lat parameter provided", http.StatusBadRequest})
+		return
+	}
+	lng, err := strconv.ParseFloat(params.Get("lng"), 64)
+	if err != nil || lng < -180 || lng > 180 {
+		h.writeError(w, &ApiError{"invalid lng parameter provided", http.StatusBadRequest})
+		return
+	}
+	radius := DefaultNearbyRadius
+	if params.Has("radius") {
+		radius, err = strconv.ParseFloat(params.Get("radius"), 64)
+		if err != nil || radius <= 0 || radius > maxNearbyRadius {
+			h.writeError(w, &ApiError{"invalid radius parameter provided", http.StatusBadRequest})
+			return
+		}
+	}
+	summits, err := h.Storage.FetchSummitsNear(userId, lat, lng, radius)
+	if err != nil {
+		slog.Error("Failed to fetch summits near point", "lat", lat, "lng", lng, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	h.writeJSON(w, struct {
+		Summits []NearbySummit `json:"summits"`
+	}{summits})
+}
+
+func (h *Api) handleSummits(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	q, err := parseSummitsQuery(r, h.Config.ItemsPerPage)
+	if err != nil {
+		h.writeError(w, &ApiError{err.Error(), http.StatusBadRequest})
+		return
+	}
+	summits, err := h.Storage.FetchSummits(userId, q)
+	if err != nil {
+		slog.Error("Failed to fetch summits from db", "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	h.writeJSON(w, summits)
+}
+
+// handleSummitsKML exports summits table as KML, summits table filters apply
+func (h *Api) handleSummitsKML(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	q, err := parseSummitsQuery(r, h.Config.ItemsPerPage)
+	if err != nil {
+		h.writeError(w, &ApiError{err.Error(), http.StatusBadRequest})
+		return
+	}
+	summits, err := h.Storage.FetchSummits(userId, q)
+	if err != nil {
+		slog.Error("Failed to fetch summits from db", "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	kml, err := SummitsKML(geoExportTitle, summits.Summits, userId != 0)
+	if err != nil {
+		slog.Error("Failed to generate KML", "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+	w.Header().Set("Content-Disposition", "attachment; filename=summits.kml")
+	w.Write(kml)
+}
+
+// handleSummitsGeoJSON exports summits table as GeoJSON, summits table filters apply
+func (h *Api) handleSummitsGeoJSON(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	q, err := parseSummitsQuery(r, h.Config.ItemsPerPage)
+	if err != nil {
+		h.writeError(w, &ApiError{err.Error(), http.StatusBadRequest})
+		return
+	}
+	summits, err := h.Storage.FetchSummits(userId, q)
+	if err != nil {
+		slog.Error("Failed to fetch summits from db", "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	geojson, err := SummitsGeoJSON(summits.Summits, userId != 0)
+	if err != nil {
+		slog.Error("Failed to generate GeoJSON", "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Content-Disposition", "attachment; filename=summits.geojson")
+	w.Write(geojson)
+}
+
+func (h *Api) handleSummitsGPX(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	summits, err := h.Storage.FetchSummits(userId, SummitsQuery{})
+	if err != nil {
+		slog.Error("Failed to fetch summits from db", "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+
+	gpxXML, err := SummitsGPX(summits.Summits)
+	if err != nil {
+		slog.Error("Failed to generate GPX XML", "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+
+	// Set response headers
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Header().Set("Content-Disposition", "attachment; filename=summits.gpx")
+
+	// Write GPX XML to response
+	w.Write(gpxXML)
+}
+
+func (h *Api) handleCatalogChanges(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePageParam(r)
+	if err != nil {
+		h.writeError(w, &ApiError{err.Error(), http.StatusBadRequest})
+		return
+	}
+	revisions, totalRevisions, err := h.Storage.FetchCatalogRevisions(page, h.Config.ItemsPerPage)
+	if err != nil {
+		slog.Error("Failed to fetch catalog revisions", "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+
+	response := struct {
+		Revisions      []CatalogRevision `json:"revisions"`
+		TotalRevisions int               `json:"total_revisions"`
+		Page           int               `json:"page"`
+	}{
+		Revisions:      revisions,
+		TotalRevisions: totalRevisions,
+		Page:           page,
+	}
+
+	h.writeJSON(w, response)
+}
+
+// buildPlan plans a trip over summits given by ids, or over current user's
+// missing summits on given ridges
+func (h *Api) buildPlan(r *http.Request) (*Plan, *ApiError) {
+	params := r.URL.Query()
+	var start [2]float64
+	parts := strings.Split(params.Get("start"), ",")
+	if len(parts) != 2 {
+		return nil, &ApiError{"invalid start parameter provided", http.StatusBadRequest}
+	}
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, &ApiError{"invalid start parameter provided", http.StatusBadRequest}
+		}
+		start[i] = v
+	}
+	dailyDistance := DefaultDailyDistance
+	if params.Has("daily_distance") {
+		v, err := strconv.ParseFloat(params.Get("daily_distance"), 64)
+		if err != nil || v <= 0 {
+			return nil, &ApiError{"invalid daily_distance parameter provided", http.StatusBadRequest}
+		}
+		dailyDistance = v
+	}
+
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	ids := params["summit"]
+	var q SummitsQuery
+	switch {
+	case len(ids) > 0:
+	case params.Has("ridge"):
+		if userId == 0 {
+			return nil, authRequired
+		}
+		climbed := false
+		q = SummitsQuery{RidgeIds: params["ridge"], Climbed: &climbed}
+	default:
+		return nil, &ApiError{"summit or ridge parameter is required", http.StatusBadRequest}
+	}
+	table, err := h.Storage.FetchSummits(userId, q)
+	if err != nil {
+		slog.Error("Failed to fetch summits from db", "error", err)
+		return nil, serverError
+	}
+
+	summits := table.Summits
+	if len(ids) > 0 {
+		byId := make(map[string]SummitsTableItem, len(table.Summits))
+		for _, s := range table.Summits {
+			byId[s.Id] = s
+		}
+		summits = make([]SummitsTableItem, 0, len(ids))
+		seen := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			s, ok := byId[id]
+			if !ok {
+				return nil, &ApiError{fmt.Sprintf("unknown summit %s", id), http.StatusBadRequest}
+			}
+			if !seen[id] {
+				seen[id] = true
+				summits = append(summits, s)
+			}
+		}
+	}
+	if len(summits) > maxPlanSummits {
+		return nil, &ApiError{fmt.Sprintf("too many summits, at most %d can be planned", maxPlanSummits), http.StatusBadRequest}
+	}
+	return PlanTrip(start, summits, dailyDistance), nil
+}
+
+func (h *Api) handlePlan(w http.ResponseWriter, r *http.Request) {
+	plan, apiErr := h.buildPlan(r)
+	if apiErr != nil {
+		h.writeError(w, apiErr)
+		return
+	}
+	h.writeJSON(w, plan)
+}
+
+func (h *Api) handlePlanGPX(w http.ResponseWriter, r *http.Request) {
+	plan, apiErr := h.buildPlan(r)
+	if apiErr != nil {
+		h.writeError(w, apiErr)
+		return
+	}
+	gpxXML, err := PlanGPX(plan)
+	if err != nil {
+		slog.Error("Failed to generate GPX XML", "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Header().Set("Content-Disposition", "attachment; filename=plan.gpx")
+	w.Write(gpxXML)
+}
+
+func (h *Api) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		h.writeError(w, &ApiError{"search query is required", http.StatusBadRequest})
+		return
+	}
+	results, err := h.Storage.SearchSummits(query, h.Config.ItemsPerPage)
+	if err != nil {
+		slog.Error("Failed to search summits", "query", query, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+
+	response := struct {
+		Results []SearchResult `json:"results"`
+	}{
+		Results: results,
+	}
+
+	h.writeJSON(w, response)
+}
+
+func parsePageParam(r *http.Request) (int, error) {
+	page := 1
+	pageParam := r.URL.Query()["page"]
+	errorInvalidPageParam := errors.New("invalid page parameter provided")
+	if pageParam != nil {
+		if len(pageParam) != 1 {
+			return 0, errorInvalidPageParam
+		}
+		var err error
+		page, err = strconv.Atoi(pageParam[0])
+		if err != nil || page <= 0 {
+			return 0, errorInvalidPageParam
+		}
+	}
+	return page, nil
+}
+
+// parseTopQuery reads leaderboard filters and scoring from request query parameters
+func parseTopQuery(r *http.Request) (TopQuery, error) {
+	var q TopQuery
+	params := r.URL.Query()
+	q.RidgeId = params.Get("ridge")
+	if params.Has("year") {
+		year, err := strconv.Atoi(params.Get("year"))
+		if err != nil || year <= 0 {
+			return q, errors.New("invalid year parameter provided")
+		}
+		q.Year = year
+	}
+	for _, bound := range []struct {
+		param string
+		value **time.Time
+	}{
+		{"from", &q.From},
+		{"to", &q.To},
+	} {
+		if !params.Has(bound.param) {
+			continue
+		}
+		t, err := time.Parse(time.DateOnly, params.Get(bound.param))
+		if err != nil {
+			return q, fmt.Errorf("invalid %s parameter provided", bound.param)
+		}
+		*bound.value = &t
+	}
+	if params.Has("season") {
+		q.Season = params.Get("season")
+		if _, ok := TopSeasons[q.Season]; !ok {
+			return q, errors.New("invalid season parameter provided")
+		}
+	}
+	q.Rank = TopRankCompetition
+	if params.Has("rank") {
+		q.Rank = params.Get("rank")
+		if q.Rank != TopRankCompetition && q.Rank != TopRankDense {
+			return q, errors.New("invalid rank parameter provided")
+		}
+	}
+	q.Score = TopScoreClimbs
+	if params.Has("score") {
+		q.Score = params.Get("score")
+		switch q.Score {
+		case TopScoreClimbs, TopScoreHeight, TopScoreProminence:
+		case TopScoreRidgePercent:
+			if q.RidgeId == "" {
+				return q, errors.New("ridge parameter is required for ridge_percent score")
+			}
+		default:
+			return q, errors.New("invalid score parameter provided")
+		}
+	}
+	return q, nil
+}
+
+// parseTopPage reads page parameter of the top, "me" stands for
+// the page with the current user and is resolved to 0
+func (h *Api) parseTopPage(r *http.Request) (int, *ApiError) {
+	if r.URL.Query().Get("page") == "me" {
+		if h.SM.GetInt64(r.Context(), UserIdKey) == 0 {
+			return 0, authRequired
+		}
+		return 0, nil
+	}
+	page, err := parsePageParam(r)
+	if err != nil {
+		return 0, &ApiError{err.Error(), http.StatusBadRequest}
+	}
+	return page, nil
+}
+
+func (h *Api) handleTop(w http.ResponseWriter, r *http.Request) {
+	page, apiErr := h.parseTopPage(r)
+	if apiErr != nil {
+		h.writeError(w, apiErr)
+		return
+	}
+	q, err := parseTopQuery(r)
+	if err != nil {
+		h.writeError(w, &ApiError{err.Error(), http.StatusBadRequest})
+		return
+	}
+	h.serveTop(q, page, w, r)
+}
+
+func (h *Api) handleTopYear(w http.ResponseWriter, r *http.Request) {
+	page, apiErr := h.parseTopPage(r)
+	if apiErr != nil {
+		h.writeError(w, apiErr)
+		return
+	}
+	params := r.URL.Query()
+	if params.Has("year") || params.Has("from") || params.Has("to") {
+		h.writeError(w, &ApiError{"period parameters are not allowed for the current year top", http.StatusBadRequest})
+		return
+	}
+	q, err := parseTopQuery(r)
+	if err != nil {
+		h.writeError(w, &ApiError{err.Error(), http.StatusBadRequest})
+		return
+	}
+	now := time.Now()
+	q.Year = now.Year()
+	// winter counts by its start year, until November the current one is the last
+	if q.Season == "winter" && now.Month() < time.November {
+		q.Year--
+	}
+	h.serveTop(q, page, w, r)
+}
+
+func (h *Api) serveTop(q TopQuery, page int, w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	top, err := h.Storage.FetchTop(q, userId, page, h.Config.ItemsPerPage)
+	if err != nil {
+		slog.Error("Failed to fetch top", "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	h.writeJSON(w, top)
+}
+
+// parseFeedQuery reads feed filters and cursor. Users followed by
+// the current one are selected with following=1.
+func (h *Api) parseFeedQuery(r *http.Request) (FeedQuery, *ApiError) {
+	params := r.URL.Query()
+	q := FeedQuery{
+		RidgeId: params.Get("ridge"),
+		Cursor:  params.Get("cursor"),
+		Limit:   h.Config.ItemsPerPage,
+	}
+	for _, u := range params["user"] {
+		userId, err := strconv.ParseInt(u, 10, 64)
+		if err != nil {
+			return q, &ApiError{"invalid user parameter provided", http.StatusBadRequest}
+		}
+		q.UserIds = append(q.UserIds, userId)
+	}
+	if following := params.Get("following"); following != "" {
+		if following != "1" && following != "true" {
+			return q, &ApiError{"invalid following parameter provided", http.StatusBadRequest}
+		}
+		q.FollowedBy = h.SM.GetInt64(r.Context(), UserIdKey)
+		if q.FollowedBy == 0 {
+			return q, authRequired
+		}
+	}
+	return q, nil
+}
+
+func (h *Api) handleFeed(w http.ResponseWriter, r *http.Request) {
+	q, apiErr := h.parseFeedQuery(r)
+	if apiErr != nil {
+		h.writeError(w, apiErr)
+		return
+	}
+	h.serveFeed(q, w)
+}
+
+// handleTimeline is the feed of climbs by users followed by the current one
+func (h *Api) handleTimeline(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+	q, apiErr := h.parseFeedQuery(r)
+	if apiErr != nil {
+		h.writeError(w, apiErr)
+		return
+	}
+	q.FollowedBy = userId
+	h.serveFeed(q, w)
+}
+
+func (h *Api) serveFeed(q FeedQuery, w http.ResponseWriter) {
+	feed, err := h.Storage.FetchFeed(q)
+	if err == errInvalidCursor {
+		h.writeError(w, &ApiError{"invalid cursor parameter provided", http.StatusBadRequest})
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to fetch feed", "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	h.writeJSON(w, feed)
+}
+
+// feedsDisabled is returned for Atom feeds without BASE_URL configured.
+// Request headers are not trusted for links, cached feeds could be poisoned.
+var feedsDisabled = &ApiError{"Feeds are not configured", http.StatusNotFound}
+
+func (h *Api) writeAtom(w http.ResponseWriter, info AtomFeedInfo, q FeedQuery) {
+	if h.Config.BaseUrl == "" {
+		h.writeError(w, feedsDisabled)
+		return
+	}
+	q.Limit = h.Config.ItemsPerPage
+	feed, err := h.Storage.FetchFeed(q)
+	if err != nil {
+		slog.Error("Failed to fetch feed", "feed", info.Self, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	atom, err := ClimbsAtom(h.Config.BaseUrl, info, feed.Items)
+	if err != nil {
+		slog.Error("Failed to generate Atom feed", "feed", info.Self, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write(atom)
+}
+
+func (h *Api) handleFeedAtom(w http.ResponseWriter, r *http.Request) {
+	info := AtomFeedInfo{Title: geoExportTitle + ": восхождения", Self: "/api/feed/atom", Page: "/"}
+	h.writeAtom(w, info, FeedQuery{})
+}
+
+func (h *Api) handleSummitAtom(w http.ResponseWriter, r *http.Request) {
+	summitId := chi.URLParam(r, "summitId")
+	canonicalId, err := h.Storage.ResolveLegacyId(summitId)
+	if err != nil {
+		slog.Error("Failed to resolve legacy id", "summitId", summitId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	if canonicalId != "" {
+		summitId = canonicalId
+	}
+	summit, err := h.Storage.FetchSummit(summitId, 0)
+	if err != nil {
+		slog.Error("Failed to fetch summit", "summitId", summitId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	if summit == nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+
+	name := fmt.Sprintf("%d", summit.Height)
+	if summit.Name != nil && *summit.Name != "" {
+		name = *summit.Name
+	}
+	page := "/" + summit.Ridge.Id + "/" + summit.Id
+	info := AtomFeedInfo{
+		Title: fmt.Sprintf("%s: восхождения на %s", geoExportTitle, name),
+		Self:  "/api/summit" + page + "/atom",
+		Page:  page,
+	}
+	h.writeAtom(w, info, FeedQuery{SummitId: summit.Id, Dated: true})
+}
+
+func (h *Api) handleUserAtom(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	user, err := h.Storage.GetUserById(userId)
+	if err != nil {
+		slog.Error("Failed to fetch user", "userId", userId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	if user == nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+
+	page := fmt.Sprintf("/user/%d", user.Id)
+	info := AtomFeedInfo{
+		Title: fmt.Sprintf("%s: восхождения, %s", geoExportTitle, user.Name),
+		Self:  "/api" + page + "/atom",
+		Page:  page,
+	}
+	h.writeAtom(w, info, FeedQuery{UserIds: []int64{user.Id}, Dated: true})
+}
+
+func (h *Api) handleUserMe(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+	h.handleUserById(w, r, userId)
+}
+
+func (h *Api) handleUser(w http.ResponseWriter, r *http.Request) {
+	userIdStr := chi.URLParam(r, "userId")
+	userId, err := strconv.ParseInt(userIdStr, 10, 64)
+	if err != nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	h.handleUserById(w, r, userId)
+}
+
+func (h *Api) handleUserById(w http.ResponseWriter, _ *http.Request, userId int64) {
+	user, err := h.Storage.GetUserById(userId)
+	if err != nil {
+		slog.Error("Failed to get user by ID", "userId", userId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	if user == nil {
+		slog.Warn("Unknown user id passed", "userId", userId)
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	achievements, err := h.Storage.FetchUserAchievements(userId)
+	if err != nil {
+		slog.Error("Failed to fetch user achievements", "userId", userId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	h.writeJSON(w, struct {
+		*User
+		Achievements []UserAchievement `json:"achievements"`
+	}{user, achievements})
+}
+
+// userIdParam parses userId path parameter of an existing user
+func (h *Api) userIdParam(r *http.Request) (int64, *ApiError) {
+	userId, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		return 0, pathNotFoundError
+	}
+	user, err := h.Storage.GetUserById(userId)
+	if err != nil {
+		slog.Error("Failed to get user by ID", "userId", userId, "error", err)
+		return 0, serverError
+	}
+	if user == nil {
+		return 0, pathNotFoundError
+	}
+	return userId, nil
+}
+
+func (h *Api) handleUserFollow(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+	followeeId, apiErr := h.userIdParam(r)
+	if apiErr != nil {
+		h.writeError(w, apiErr)
+		return
+	}
+	err := h.Storage.Follow(userId, followeeId)
+	if err == errFollowSelf {
+		h.writeError(w, &ApiError{"You can not follow yourself", http.StatusBadRequest})
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to follow user", "userId", userId, "followeeId", followeeId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	slog.Info("User followed", "userId", userId, "followeeId", followeeId)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Api) handleUserUnfollow(w http.ResponseWriter, r *http.Request) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+	followeeId, apiErr := h.userIdParam(r)
+	if apiErr != nil {
+		h.writeError(w, apiErr)
+		return
+	}
+	if err := h.Storage.Unfollow(userId, followeeId); err != nil {
+		slog.Error("Failed to unfollow user", "userId", userId, "followeeId", followeeId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	slog.Info("User unfollowed", "userId", userId, "followeeId", followeeId)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Api) handleFollowing(w http.ResponseWriter, r *http.Request) {
+	h.serveFollowUsers(w, r, h.Storage.FetchFollowing)
+}
+
+func (h *Api) handleFollowers(w http.ResponseWriter, r *http.Request) {
+	h.serveFollowUsers(w, r, h.Storage.FetchFollowers)
+}
+
+func (h *Api) serveFollowUsers(w http.ResponseWriter, r *http.Request, fetch func(int64) ([]FollowUser, error)) {
+	userId := h.SM.GetInt64(r.Context(), UserIdKey)
+	if userId == 0 {
+		h.writeError(w, authRequired)
+		return
+	}
+	users, err := fetch(userId)
+	if err != nil {
+		slog.Error("Failed to fetch follows", "userId", userId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	h.writeJSON(w, struct {
+		Users []FollowUser `json:"users"`
+	}{users})
+}
+
+func (h *Api) handleUserClimbs(w http.ResponseWriter, r *http.Request) {
+	userIdStr := chi.URLParam(r, "userId")
+	userId, err := strconv.ParseInt(userIdStr, 10, 64)
+	if err != nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+
+	climbs, err := h.Storage.FetchUserClimbs(userId)
+	if err != nil {
+		slog.Error("Failed to fetch climbs for user", "userId", userId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	h.writeJSON(w, climbs)
+}
+
+func (h *Api) handleUserArchivedClimbs(w http.ResponseWriter, r *http.Request) {
+	userIdStr := chi.URLParam(r, "userId")
+	userId, err := strconv.ParseInt(userIdStr, 10, 64)
+	if err != nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+
+	climbs, err := h.Storage.FetchUserArchivedClimbs(userId)
+	if err != nil {
+		slog.Error("Failed to fetch archived climbs for user", "userId", userId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	h.writeJSON(w, climbs)
+}
+
+func (h *Api) handleUserMissingSummits(w http.ResponseWriter, r *http.Request) {
+	userIdStr := chi.URLParam(r, "userId")
+	userId, err := strconv.ParseInt(userIdStr, 10, 64)
+	if err != nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	missingSummits, err := h.Storage.FetchUserMissingSummits(userId)
+	if err != nil {
+		slog.Error("Failed to fetch missing summits for user", "userId", userId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	h.writeJSON(w, missingSummits)
+}
+
+func (h *Api) handleUserMissingExport(w http.ResponseWriter, r *http.Request) {
+	h.writeUserSummitsExport(w, r, false)
+}
+
+func (h *Api) handleUserClimbsExport(w http.ResponseWriter, r *http.Request) {
+	h.writeUserSummitsExport(w, r, true)
+}
+
+// writeUserSummitsExport exports summits climbed or not yet climbed by the user
+// as GPX or KML, summits table filters apply
+func (h *Api) writeUserSummitsExport(w http.ResponseWriter, r *http.Request, climbed bool) {
+	userId, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	user, err := h.Storage.GetUserById(userId)
+	if err != nil {
+		slog.Error("Failed to get user by ID", "userId", userId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	if user == nil {
+		h.writeError(w, pathNotFoundError)
+		return
+	}
+	q, err := parseSummitsExportQuery(r)
+	if err != nil {
+		h.writeError(w, &ApiError{err.Error(), http.StatusBadRequest})
+		return
+	}
+	q.Climbed = &climbed
+	summits, err := h.Storage.FetchSummits(userId, q)
+	if err != nil {
+		slog.Error("Failed to fetch summits from db", "userId", userId, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+
+	name, title := "missing", fmt.Sprintf("%s: непокорённые вершины", user.Name)
+	if climbed {
+		name, title = "climbed", fmt.Sprintf("%s: покорённые вершины", user.Name)
+	}
+	var data []byte
+	contentType := "application/gpx+xml"
+	format := chi.URLParam(r, "format")
+	if format == "kml" {
+		contentType = "application/vnd.google-earth.kml+xml"
+		data, err = SummitsKML(title, summits.Summits, false)
+	} else {
+		data, err = SummitsGPX(summits.Summits)
+	}
+	if err != nil {
+		slog.Error("Failed to generate summits export", "userId", userId, "format", format, "error", err)
+		h.writeError(w, serverError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%d.%s", name, userId, format))
+	w.Write(data)
 }
 
 func (h *Api) writeJSON(w http.ResponseWriter, data interface{}) {
@@ -2,15 +2,20 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"database/sql"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"testing/synctest"
@@ -72,7 +77,7 @@ func GetMockApp(t *testing.T, userId int64, config *RuntimeConfig) *App {
 	sm.Store.(*memstore.MemStore).StopCleanup()
 	sm.Store = NewMockSessionStore(userId)
 	storage := NewStorage(db)
-	err := storage.LoadSummits(config.Datadir)
+	_, err := storage.LoadSummits(config.Datadir)
 	require.NoError(t, err)
 	return NewAppServer(config, storage, sm, NewMockImageManager(t.TempDir()))
 }
@@ -118,6 +123,61 @@ func TestSummitsTableHandler(t *testing.T) {
 	}
 }
 
+func TestSummitsTableQuery(t *testing.T) {
+	app := GetMockApp(t, 5, &RuntimeConfig{Datadir: "testdata/summits", ItemsPerPage: 5})
+
+	cases := []struct {
+		name               string
+		query              string
+		expectedIds        []string
+		expectedTotal      int
+		expectedPage       int
+		expectedTotalPages int
+	}{
+		{"single ridge", "ridge=malidak", []string{"kirel", "malinovaja"}, 2, 0, 0},
+		{"several ridges", "ridge=malidak&ridge=stolby", []string{"1021", "kirel", "malinovaja", "stolby"}, 4, 0, 0},
+		{"min height", "min_height=1100", []string{"kirel", "malinovaja"}, 2, 0, 0},
+		{"max height", "max_height=1021", []string{"1021", "kurkak"}, 2, 0, 0},
+		{"min prominence", "min_prominence=20", []string{"malinovaja"}, 1, 0, 0},
+		{"not visited", "max_visitors=0", []string{"1021"}, 1, 0, 0},
+		{"visitors range", "min_visitors=2&max_visitors=6", []string{"kirel", "malinovaja"}, 2, 0, 0},
+		{"climbed", "climbed=true", []string{"kirel", "kurkak", "malinovaja"}, 3, 0, 0},
+		{"not climbed", "climbed=false", []string{"1021", "stolby"}, 2, 0, 0},
+		{"bounding box", "bbox=54.0,58.2,54.1,58.3", []string{"kirel", "malinovaja"}, 2, 0, 0},
+		{"sort by height desc", "sort=-height", []string{"kirel", "malinovaja", "stolby", "1021", "kurkak"}, 5, 0, 0},
+		{"sort by visitors", "sort=visitors", []string{"1021", "stolby", "malinovaja", "kirel", "kurkak"}, 5, 0, 0},
+		{"sort by name", "sort=name", []string{"kirel", "kurkak", "malinovaja", "stolby", "1021"}, 5, 0, 0},
+		{"sort by latitude desc", "sort=-lat", []string{"stolby", "1021", "kirel", "malinovaja", "kurkak"}, 5, 0, 0},
+		{"first page", "page=1", []string{"1021", "kirel", "kurkak", "malinovaja", "stolby"}, 5, 1, 1},
+		{"custom page size", "sort=-height&page=2&per_page=2", []string{"stolby", "1021"}, 5, 2, 3},
+		{"filtered page", "ridge=malidak&per_page=1", []string{"kirel"}, 2, 1, 2},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/api/summits?"+tt.query, nil)
+			require.NoError(t, err)
+			req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+
+			app.router.ServeHTTP(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+
+			var response SummitsTable
+			err = json.NewDecoder(rr.Body).Decode(&response)
+			require.NoError(t, err, "Failed to decode response")
+
+			ids := make([]string, len(response.Summits))
+			for i, s := range response.Summits {
+				ids[i] = s.Id
+			}
+			assert.Equal(t, tt.expectedIds, ids)
+			assert.Equal(t, tt.expectedTotal, response.Total, "Wrong total")
+			assert.Equal(t, tt.expectedPage, response.Page, "Wrong page")
+			assert.Equal(t, tt.expectedTotalPages, response.TotalPages, "Wrong total pages")
+		})
+	}
+}
+
 func TestHandlersClientErrors(t *testing.T) {
 	cases := []struct {
 		name         string
@@ -127,6 +187,15 @@ func TestHandlersClientErrors(t *testing.T) {
 		{"invalid page 0", "/api/top?page=0", http.StatusBadRequest},
 		{"negative page", "/api/top?page=-1", http.StatusBadRequest},
 		{"multiple pages", "/api/top?page=1&page=2", http.StatusBadRequest},
+		{"invalid catalog changes page", "/api/catalog/changes?page=0", http.StatusBadRequest},
+		{"missing search query", "/api/search", http.StatusBadRequest},
+		{"unknown summits sort key", "/api/summits?sort=foo", http.StatusBadRequest},
+		{"invalid summits height filter", "/api/summits?min_height=abc", http.StatusBadRequest},
+		{"invalid summits climbed filter", "/api/summits?climbed=maybe", http.StatusBadRequest},
+		{"incomplete summits bbox", "/api/summits?bbox=54,58,55", http.StatusBadRequest},
+		{"zero summits per page", "/api/summits?per_page=0", http.StatusBadRequest},
+		{"too many summits per page", "/api/summits?per_page=1000", http.StatusBadRequest},
+		{"invalid summits page", "/api/summits?page=0", http.StatusBadRequest},
 		{"missing summit path", "/api/summit", http.StatusNotFound},
 		{"incomplete summit path", "/api/summit/kyrel", http.StatusNotFound},
 		{"invalid summit path", "/api/summit/malidak/kyrel/1", http.StatusNotFound},
@@ -144,6 +213,7 @@ func TestHandlersClientErrors(t *testing.T) {
 		{"non-existent user", "/api/user/123", http.StatusNotFound},
 		{"invalid user missing endpoint non-numeric", "/api/user/abcd/missing", http.StatusNotFound},
 		{"invalid user missing endpoint empty", "/api/user//missing", http.StatusNotFound},
+		{"invalid user archived climbs endpoint", "/api/user/abcd/climbs/archived", http.StatusNotFound},
 	}
 	app := GetMockApp(t, 5, &RuntimeConfig{Datadir: "testdata/summits"})
 
@@ -183,8 +253,584 @@ func TestTopYear(t *testing.T) {
 
 		assert.JSONEq(t, string(expected), rr.Body.String(), "Response body mismatch")
 	})
+
+	t.Run("winter", func(t *testing.T) {
+		winterTop := func() []int {
+			req, err := http.NewRequest("GET", "/api/top/year?season=winter", nil)
+			require.NoError(t, err)
+			rr := httptest.NewRecorder()
+			app.router.ServeHTTP(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code)
+			var top Top
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&top))
+			users := make([]int, 0)
+			for _, item := range top.Items {
+				users = append(users, item.UserId)
+			}
+			return users
+		}
+		synctest.Test(t, func(t *testing.T) {
+			time.Sleep(time.Hour * 24 * (366 + 365 + 31)) // mocking February 2002
+			// winter 2001 runs from November 2001 to April 2002
+			assert.ElementsMatch(t, []int{10, 11}, winterTop())
+			time.Sleep(time.Hour * 24 * 300) // mocking November 2002
+			assert.ElementsMatch(t, []int{7}, winterTop())
+		})
+	})
+
+	for _, query := range []string{"?year=2001", "?from=2001-01-01", "?to=2001-12-31"} {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/top/year"+query, nil)
+		require.NoError(t, err)
+		app.router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "period is the current year: %s", query)
+	}
+}
+
+func TestTopFilters(t *testing.T) {
+	cases := []struct {
+		name                 string
+		query                string
+		expectedStatus       int
+		expectedUsers        []int
+		expectedScores       []float64
+		expectedTotalSummits int
+	}{
+		{"ridge", "?ridge=malidak", http.StatusOK, []int{9, 5, 10, 11, 7, 8}, nil, 2},
+		{"ridge percent", "?ridge=malidak&score=ridge_percent", http.StatusOK,
+			[]int{9, 5, 10, 11, 7, 8}, []float64{100, 100, 50, 50, 50, 50}, 2},
+		{"year", "?year=2001", http.StatusOK, []int{10, 11, 9}, nil, 5},
+		// climbs dated 2001 only do not fit the range entirely
+		{"date range", "?from=2001-11-01&to=2002-12-31", http.StatusOK, []int{10, 11, 7, 9}, nil, 5},
+		{"winter", "?season=winter", http.StatusOK, []int{10, 7, 5, 2, 9, 11, 3}, nil, 5},
+		// winter 2011 lasts till April 2012
+		{"winter of year", "?season=winter&year=2011", http.StatusOK, []int{3}, nil, 5},
+		{"winter of next year", "?season=winter&year=2012", http.StatusOK, []int{}, nil, 5},
+		{"summer on ridge", "?season=summer&ridge=malidak", http.StatusOK, []int{8}, nil, 2},
+		{"height", "?score=height", http.StatusOK, []int{9, 5, 10, 7, 11, 8, 6, 1, 2, 4, 3},
+			[]float64{3322, 3322, 2170, 2170, 2170, 1162, 1026, 1008, 1008, 1008, 1008}, 5},
+		{"prominence", "?score=prominence&ridge=malidak", http.StatusOK, []int{9, 5, 10, 11, 7, 8},
+			[]float64{60, 60, 15, 15, 15, 15}, 2},
+		{"ridge percent without ridge", "?score=ridge_percent", http.StatusBadRequest, nil, nil, 0},
+		{"invalid score", "?score=speed", http.StatusBadRequest, nil, nil, 0},
+		{"invalid season", "?season=spring", http.StatusBadRequest, nil, nil, 0},
+		{"invalid date", "?from=01.01.2001", http.StatusBadRequest, nil, nil, 0},
+		{"invalid year", "?year=last", http.StatusBadRequest, nil, nil, 0},
+	}
+	app := GetMockApp(t, 0, &RuntimeConfig{Datadir: "testdata/summits", ItemsPerPage: 20})
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/api/top"+tt.query, nil)
+			require.NoError(t, err)
+			app.router.ServeHTTP(rr, req)
+			require.Equal(t, tt.expectedStatus, rr.Code, "handler returned wrong status code")
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var top Top
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&top))
+			users := make([]int, len(top.Items))
+			scores := make([]float64, len(top.Items))
+			for i, item := range top.Items {
+				users[i], scores[i] = item.UserId, item.Score
+			}
+			assert.Equal(t, tt.expectedUsers, users)
+			if tt.expectedScores != nil {
+				assert.Equal(t, tt.expectedScores, scores)
+			} else {
+				assert.Equal(t, make([]float64, len(users)), scores)
+			}
+			assert.Equal(t, tt.expectedTotalSummits, top.TotalSummits)
+		})
+	}
+}
+
+func TestTopRanking(t *testing.T) {
+	cases := []struct {
+		name               string
+		userId             int64
+		itemsPerPage       int
+		query              string
+		expectedStatus     int
+		expectedPage       int
+		expectedTotalPages int
+		expectedPlaces     []int
+		expectedMe         *TopItem
+		expectedMyPage     int
+	}{
+		// 11 climbers fit exactly into a single page
+		{"exact multiple of page size", 0, 11, "", http.StatusOK, 1, 1,
+			[]int{1, 1, 3, 3, 3, 6, 6, 6, 6, 6, 6}, nil, 0},
+		{"dense ranks", 0, 11, "?rank=dense", http.StatusOK, 1, 1,
+			[]int{1, 1, 2, 2, 2, 3, 3, 3, 3, 3, 3}, nil, 0},
+		{"current user on another page", 6, 5, "", http.StatusOK, 1, 3,
+			[]int{1, 1, 3, 3, 3},
+			&TopItem{UserId: 6, UserName: "Curtis Allen", UserImage: "users/6_S.jpg", ClimbsNum: 1, Place: 6}, 3},
+		{"jump to my page", 6, 5, "?page=me", http.StatusOK, 3, 3,
+			[]int{6},
+			&TopItem{UserId: 6, UserName: "Curtis Allen", UserImage: "users/6_S.jpg", ClimbsNum: 1, Place: 6}, 3},
+		{"my page with filters", 5, 5, "?page=me&ridge=malidak", http.StatusOK, 1, 2,
+			[]int{1, 1, 3, 3, 3},
+			&TopItem{UserId: 5, UserName: "Jonathan Nguyen", UserImage: "users/5_S.jpg", ClimbsNum: 2, Place: 1}, 1},
+		// user 1000 has no climbs and is not in the top
+		{"my page of user not in top", 1000, 5, "?page=me", http.StatusOK, 1, 3,
+			[]int{1, 1, 3, 3, 3}, nil, 0},
+		{"my page requires authentication", 0, 5, "?page=me", http.StatusUnauthorized, 0, 0, nil, nil, 0},
+		{"invalid rank", 0, 5, "?rank=olympic", http.StatusBadRequest, 0, 0, nil, nil, 0},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			app := GetMockApp(t, tt.userId, &RuntimeConfig{Datadir: "testdata/summits", ItemsPerPage: tt.itemsPerPage})
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/api/top"+tt.query, nil)
+			require.NoError(t, err)
+			if tt.userId != 0 {
+				req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+			}
+			app.router.ServeHTTP(rr, req)
+			require.Equal(t, tt.expectedStatus, rr.Code, "handler returned wrong status code")
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var top Top
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&top))
+			places := make([]int, len(top.Items))
+			for i, item := range top.Items {
+				places[i] = item.Place
+			}
+			assert.Equal(t, tt.expectedPlaces, places)
+			assert.Equal(t, tt.expectedPage, top.Page)
+			assert.Equal(t, tt.expectedTotalPages, top.TotalPages)
+			assert.Equal(t, tt.expectedMe, top.Me)
+			assert.Equal(t, tt.expectedMyPage, top.MyPage)
+		})
+	}
+}
+
+func TestFeedHandler(t *testing.T) {
+	app := GetMockApp(t, 6, &RuntimeConfig{Datadir: "testdata/summits", ItemsPerPage: 1})
+	for _, summit := range []string{"stolby/1021", "malidak/kirel"} {
+		formData := url.Values{}
+		formData.Set("date", "20.05.2016")
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/summit/"+summit+"/ascents", strings.NewReader(formData.Encode()))
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		app.router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+	}
+
+	cases := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedIds    []string
+	}{
+		{"latest", "/api/feed", http.StatusOK, []string{"kirel"}},
+		{"by ridge", "/api/feed?ridge=stolby", http.StatusOK, []string{"1021"}},
+		{"by user", "/api/feed?user=6&user=7", http.StatusOK, []string{"kirel"}},
+		{"by other user", "/api/feed?user=7", http.StatusOK, []string{}},
+		{"invalid user", "/api/feed?user=me", http.StatusBadRequest, nil},
+		{"invalid cursor", "/api/feed?cursor=garbage", http.StatusBadRequest, nil},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", tt.url, nil)
+			require.NoError(t, err)
+			app.router.ServeHTTP(rr, req)
+			require.Equal(t, tt.expectedStatus, rr.Code, "handler returned wrong status code")
+			if tt.expectedIds == nil {
+				return
+			}
+			var feed Feed
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&feed))
+			ids := make([]string, len(feed.Items))
+			for i, e := range feed.Items {
+				ids[i] = e.SummitId
+			}
+			assert.Equal(t, tt.expectedIds, ids)
+		})
+	}
+
+	t.Run("next page", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/feed", nil)
+		require.NoError(t, err)
+		app.router.ServeHTTP(rr, req)
+		var feed Feed
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&feed))
+		require.NotEmpty(t, feed.NextCursor)
+
+		rr = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "/api/feed?cursor="+feed.NextCursor, nil)
+		require.NoError(t, err)
+		app.router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+		feed = Feed{}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&feed))
+		require.Len(t, feed.Items, 1)
+		assert.Equal(t, "1021", feed.Items[0].SummitId)
+		assert.Equal(t, FeedEventCreated, feed.Items[0].Event)
+		assert.Empty(t, feed.NextCursor)
+	})
+}
+
+func TestAtomHandlers(t *testing.T) {
+	app := GetMockApp(t, 6, &RuntimeConfig{Datadir: "testdata/summits", ItemsPerPage: 5, BaseUrl: "https://example.org"})
+	for _, summit := range []string{"stolby/1021", "malidak/kirel"} {
+		formData := url.Values{}
+		formData.Set("date", "20.05.2016")
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/summit/"+summit+"/ascents", strings.NewReader(formData.Encode()))
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		app.router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+	}
+
+	cases := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedTitle  string
+		expectedLinks  []string
+	}{
+		{"site", "/api/feed/atom", http.StatusOK, "Тысячники Южного Урала: восхождения",
+			[]string{"https://example.org/malidak/kirel", "https://example.org/stolby/1021"}},
+		{"summit", "/api/summit/stolby/1021/atom", http.StatusOK, "Тысячники Южного Урала: восхождения на 1021",
+			[]string{"https://example.org/stolby/1021"}},
+		// climbs registered before timestamps are timed by the climb date
+		{"summit with old climbs", "/api/summit/kurkak/kurkak/atom", http.StatusOK,
+			"Тысячники Южного Урала: восхождения на Куркак", slices.Repeat([]string{"https://example.org/kurkak/kurkak"}, 5)},
+		{"unknown summit", "/api/summit/malidak/everest/atom", http.StatusNotFound, "", nil},
+		{"user", "/api/user/6/atom", http.StatusOK, "Тысячники Южного Урала: восхождения, Curtis Allen",
+			[]string{"https://example.org/malidak/kirel", "https://example.org/stolby/1021", "https://example.org/stolby/stolby"}},
+		{"other user", "/api/user/7/atom", http.StatusOK, "Тысячники Южного Урала: восхождения, Todd Bowen",
+			[]string{"https://example.org/kurkak/kurkak", "https://example.org/malidak/kirel"}},
+		{"unknown user", "/api/user/1000/atom", http.StatusNotFound, "", nil},
+		{"invalid user", "/api/user/me/atom", http.StatusNotFound, "", nil},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", tt.url, nil)
+			require.NoError(t, err)
+			app.router.ServeHTTP(rr, req)
+			require.Equal(t, tt.expectedStatus, rr.Code, "handler returned wrong status code")
+			if tt.expectedLinks == nil {
+				return
+			}
+			assert.Equal(t, "application/atom+xml; charset=utf-8", rr.Header().Get("Content-Type"))
+			var feed atomFeed
+			require.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &feed))
+			assert.Equal(t, tt.expectedTitle, feed.Title)
+			assert.Equal(t, "https://example.org"+tt.url, feed.Links[0].Href)
+			links := make([]string, len(feed.Entries))
+			for i, e := range feed.Entries {
+				links[i] = e.Link.Href
+			}
+			assert.Equal(t, tt.expectedLinks, links)
+		})
+	}
+}
+
+func TestAtomHandlersWithoutBaseUrl(t *testing.T) {
+	app := GetMockApp(t, 6, &RuntimeConfig{Datadir: "testdata/summits", ItemsPerPage: 5})
+	// links are never made of request headers
+	for _, feedUrl := range []string{"/api/feed/atom", "/api/summit/stolby/1021/atom", "/api/user/6/atom"} {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", feedUrl, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		app.router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code, feedUrl)
+	}
+}
+
+func TestFollowHandlers(t *testing.T) {
+	app := GetMockApp(t, 5, &RuntimeConfig{Datadir: "testdata/summits", ItemsPerPage: 5})
+	serve := func(method, url string, auth bool) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(method, url, nil)
+		require.NoError(t, err)
+		if auth {
+			req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+		}
+		app.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve("PUT", "/api/user/7/follow", false).Code)
+	assert.Equal(t, http.StatusBadRequest, serve("PUT", "/api/user/5/follow", true).Code)
+	assert.Equal(t, http.StatusNotFound, serve("PUT", "/api/user/1000/follow", true).Code)
+	assert.Equal(t, http.StatusNotFound, serve("PUT", "/api/user/abc/follow", true).Code)
+	require.Equal(t, http.StatusOK, serve("PUT", "/api/user/7/follow", true).Code)
+	require.Equal(t, http.StatusOK, serve("PUT", "/api/user/7/follow", true).Code)
+	require.NoError(t, app.Api.Storage.Follow(6, 5))
+
+	var resp struct {
+		Users []FollowUser `json:"users"`
+	}
+	rr := serve("GET", "/api/user/me/following", true)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, []int64{7}, followIds(resp.Users))
+	rr = serve("GET", "/api/user/me/followers", true)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Equal(t, []int64{6}, followIds(resp.Users))
+	assert.Equal(t, http.StatusUnauthorized, serve("GET", "/api/user/me/following", false).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("GET", "/api/user/me/followers", false).Code)
+
+	// user 7 climbed kirel, but not stolby
+	var summit Summit
+	rr = serve("GET", "/api/summit/malidak/kirel", true)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&summit))
+	assert.Equal(t, 1, summit.FollowedClimbers)
+	summit = Summit{}
+	rr = serve("GET", "/api/summit/stolby/stolby", true)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&summit))
+	assert.Equal(t, 0, summit.FollowedClimbers)
+
+	_, err := app.Api.Storage.AddAscent("stolby", 7, InexactDate{2024, 6, 1}, "", nil)
+	require.NoError(t, err)
+	_, err = app.Api.Storage.AddAscent("stolby", 6, InexactDate{2024, 6, 2}, "", nil)
+	require.NoError(t, err)
+	for _, url := range []string{"/api/user/me/timeline", "/api/feed?following=1"} {
+		var feed Feed
+		rr = serve("GET", url, true)
+		require.Equal(t, http.StatusOK, rr.Code, url)
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&feed))
+		require.Len(t, feed.Items, 1, url)
+		assert.Equal(t, int64(7), feed.Items[0].UserId, url)
+		assert.Equal(t, http.StatusUnauthorized, serve("GET", url, false).Code, url)
+	}
+	assert.Equal(t, http.StatusBadRequest, serve("GET", "/api/feed?following=yes", true).Code)
+
+	require.Equal(t, http.StatusOK, serve("DELETE", "/api/user/7/follow", true).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("DELETE", "/api/user/7/follow", false).Code)
+	rr = serve("GET", "/api/user/me/following", true)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	assert.Empty(t, resp.Users)
+}
+
+func TestClimbPartnersHandlers(t *testing.T) {
+	app := GetMockApp(t, 5, &RuntimeConfig{Datadir: "testdata/summits", ItemsPerPage: 20})
+	serve := func(method, target string, form url.Values, auth bool) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(method, target, strings.NewReader(form.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if auth {
+			req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+		}
+		app.router.ServeHTTP(rr, req)
+		return rr
+	}
+	storage := app.Api.Storage
+
+	t.Run("tagging", func(t *testing.T) {
+		ascents, err := storage.FetchUserAscents(5, "kirel")
+		require.NoError(t, err)
+		partnersUrl := fmt.Sprintf("/api/ascent/%d/partners", ascents[0].Id)
+
+		assert.Equal(t, http.StatusUnauthorized, serve("PUT", partnersUrl, url.Values{"partner": {"6"}}, false).Code)
+		assert.Equal(t, http.StatusBadRequest, serve("PUT", partnersUrl, url.Values{"partner": {"x"}}, true).Code)
+		assert.Equal(t, http.StatusBadRequest, serve("PUT", partnersUrl, url.Values{"partner": {"5"}}, true).Code)
+		assert.Equal(t, http.StatusBadRequest, serve("PUT", partnersUrl, url.Values{"partner": {"1000"}}, true).Code)
+		// ascent of another user
+		other, err := storage.FetchUserAscents(7, "kirel")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound,
+			serve("PUT", fmt.Sprintf("/api/ascent/%d/partners", other[0].Id), url.Values{"partner": {"6"}}, true).Code)
+		assert.Equal(t, http.StatusNotFound, serve("GET", fmt.Sprintf("/api/ascent/%d/partners", other[0].Id), nil, true).Code)
+
+		var resp struct {
+			Partners []ClimbPartner `json:"partners"`
+		}
+		rr := serve("PUT", partnersUrl, url.Values{"partner": {"6", "7"}}, true)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		require.Len(t, resp.Partners, 2)
+		assert.Equal(t, PartnerPending, resp.Partners[0].Status)
+
+		rr = serve("GET", partnersUrl, nil, true)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		require.Len(t, resp.Partners, 2)
+
+		rr = serve("PUT", partnersUrl, url.Values{}, true)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		assert.Empty(t, resp.Partners)
+	})
+
+	t.Run("invitations", func(t *testing.T) {
+		date := InexactDate{2024, 6, 1}
+		accepted, err := storage.AddAscent("1021", 6, date, "", nil)
+		require.NoError(t, err)
+		_, err = storage.SetClimbPartners(accepted, 6, []int64{5})
+		require.NoError(t, err)
+		declined, err := storage.AddAscent("stolby", 7, date, "", nil)
+		require.NoError(t, err)
+		_, err = storage.SetClimbPartners(declined, 7, []int64{5})
+		require.NoError(t, err)
+
+		var resp struct {
+			Invitations []Invitation `json:"invitations"`
+		}
+		rr := serve("GET", "/api/user/me/invitations", nil, true)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		require.Len(t, resp.Invitations, 2)
+		assert.Equal(t, http.StatusUnauthorized, serve("GET", "/api/user/me/invitations", nil, false).Code)
+
+		acceptUrl := fmt.Sprintf("/api/user/me/invitations/%d/accept", accepted)
+		assert.Equal(t, http.StatusUnauthorized, serve("POST", acceptUrl, nil, false).Code)
+		rr = serve("POST", acceptUrl, nil, true)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var ascent Ascent
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&ascent))
+		assert.Equal(t, "1021", ascent.SummitId)
+		assert.Equal(t, date, ascent.Date)
+		assert.Equal(t, http.StatusNotFound, serve("POST", acceptUrl, nil, true).Code)
+
+		declineUrl := fmt.Sprintf("/api/user/me/invitations/%d/decline", declined)
+		require.Equal(t, http.StatusOK, serve("POST", declineUrl, nil, true).Code)
+		assert.Equal(t, http.StatusNotFound, serve("POST", declineUrl, nil, true).Code)
+		ascents, err := storage.FetchUserAscents(5, "stolby")
+		require.NoError(t, err)
+		assert.Empty(t, ascents)
+
+		rr = serve("GET", "/api/user/me/invitations", nil, true)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		assert.Empty(t, resp.Invitations)
+
+		var climbs struct {
+			Climbs []SummitClimb `json:"climbs"`
+		}
+		rr = serve("GET", "/api/summit/stolby/1021/climbs", nil, false)
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&climbs))
+		require.Len(t, climbs.Climbs, 2)
+		assert.Equal(t, accepted, climbs.Climbs[0].GroupId)
+		assert.Equal(t, accepted, climbs.Climbs[1].GroupId)
+	})
 }
 
+func TestClimbPhotosHandlers(t *testing.T) {
+	app := GetMockApp(t, 5, &RuntimeConfig{Datadir: "testdata/summits", ItemsPerPage: 20})
+	storage := app.Api.Storage
+	imagesDir := app.Api.ImageManager.(*MockImageManager).tempDir
+	upload := func(target string, files map[string][]byte, auth bool) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		for name, data := range files {
+			fw, err := mw.CreateFormFile("photo", name)
+			require.NoError(t, err)
+			_, err = fw.Write(data)
+			require.NoError(t, err)
+		}
+		require.NoError(t, mw.Close())
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", target, body)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		if auth {
+			req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+		}
+		app.router.ServeHTTP(rr, req)
+		return rr
+	}
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest(method, target, nil)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+		app.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	ascentId, err := storage.AddAscent("kirel", 5, InexactDate{2024, 6, 1}, "", nil)
+	require.NoError(t, err)
+	photosUrl := fmt.Sprintf("/api/ascent/%d/photos", ascentId)
+	photo := testJPEG(t, 40, 30)
+
+	assert.Equal(t, http.StatusUnauthorized, upload(photosUrl, map[string][]byte{"a.jpg": photo}, false).Code)
+	assert.Equal(t, http.StatusBadRequest, upload(photosUrl, nil, true).Code)
+	assert.Equal(t, http.StatusBadRequest,
+		upload(photosUrl, map[string][]byte{"a.jpg": photo, "b.gif": []byte("GIF89a")}, true).Code)
+	other, err := storage.FetchUserAscents(7, "kirel")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound,
+		upload(fmt.Sprintf("/api/ascent/%d/photos", other[0].Id), map[string][]byte{"a.jpg": photo}, true).Code)
+	n, err := storage.CountClimbPhotos(ascentId)
+	require.NoError(t, err)
+	assert.Zero(t, n, "nothing is stored if one of photos is invalid")
+
+	rr := upload(photosUrl, map[string][]byte{"a.jpg": photo, "b.jpg": photo}, true)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp struct {
+		Photos []ClimbPhoto `json:"photos"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Len(t, resp.Photos, 2)
+	for _, p := range resp.Photos {
+		assert.True(t, strings.HasPrefix(p.Url, "photos/5/"))
+		assert.Equal(t, 40, p.Width)
+		data, err := os.ReadFile(filepath.Join(imagesDir, p.Url))
+		require.NoError(t, err)
+		assert.False(t, bytes.Contains(data, []byte("GPS-DATA")))
+		assert.FileExists(t, filepath.Join(imagesDir, p.PreviewUrl))
+	}
+
+	var climbs struct {
+		Climbs []SummitClimb `json:"climbs"`
+	}
+	rr = serve("GET", "/api/summit/malidak/kirel/climbs")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&climbs))
+	for _, c := range climbs.Climbs {
+		if c.UserId == 5 {
+			assert.Equal(t, resp.Photos, c.Photos)
+		}
+	}
+
+	t.Run("failed batch is rolled back", func(t *testing.T) {
+		stored, err := filepath.Glob(filepath.Join(imagesDir, "photos/5/*"))
+		require.NoError(t, err)
+		im := app.Api.ImageManager
+		defer func() { app.Api.ImageManager = im }()
+		// the second photo fails to upload
+		app.Api.ImageManager = &failingImageManager{im.(*MockImageManager), 2 * len(imageSizes)}
+
+		rr := upload(photosUrl, map[string][]byte{"c.jpg": photo, "d.jpg": photo}, true)
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+		n, err := storage.CountClimbPhotos(ascentId)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		after, err := filepath.Glob(filepath.Join(imagesDir, "photos/5/*"))
+		require.NoError(t, err)
+		assert.ElementsMatch(t, stored, after)
+	})
+
+	photoUrl := fmt.Sprintf("/api/photo/%d", resp.Photos[0].Id)
+	require.Equal(t, http.StatusOK, serve("DELETE", photoUrl).Code)
+	assert.Equal(t, http.StatusNotFound, serve("DELETE", photoUrl).Code)
+	assert.NoFileExists(t, filepath.Join(imagesDir, resp.Photos[0].Url))
+	assert.NoFileExists(t, filepath.Join(imagesDir, resp.Photos[0].PreviewUrl))
+
+	require.Equal(t, http.StatusOK, serve("DELETE", fmt.Sprintf("/api/ascent/%d", ascentId)).Code)
+	assert.NoFileExists(t, filepath.Join(imagesDir, resp.Photos[1].Url))
+}
 func TestHandlersHappyPath(t *testing.T) {
 	cases := []struct {
 		name               string
@@ -313,6 +959,51 @@ func TestSummitPutHandler(t *testing.T) {
 	}
 }
 
+func TestSummitPutHandlerAchievements(t *testing.T) {
+	// user 6 has climbed stolby, 1021 completes the ridge
+	app := GetMockApp(t, 6, &RuntimeConfig{Datadir: "testdata/summits"})
+	formData := url.Values{}
+	formData.Set("date", "20.05.2016")
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("PUT", "/api/summit/stolby/1021", strings.NewReader(formData.Encode()))
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	app.router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/api/user/6", nil)
+	require.NoError(t, err)
+	app.router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+	var user struct {
+		Achievements []UserAchievement `json:"achievements"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&user))
+	ids := make([]string, len(user.Achievements))
+	for i, a := range user.Achievements {
+		ids[i] = a.Id + "/" + a.Scope
+	}
+	// the latest earned go first
+	assert.Equal(t, []string{"ridge/stolby", "ridge-year/stolby", "season-first/2016"}, ids)
+	assert.Equal(t, "1021", user.Achievements[0].SummitId)
+	assert.Equal(t, InexactDate{2016, 5, 20}, user.Achievements[0].Date)
+
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/api/summit/stolby/1021", nil)
+	require.NoError(t, err)
+	app.router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+	var summit Summit
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&summit))
+	require.Len(t, summit.Achievements, 2)
+	assert.Equal(t, SummitAchievement{
+		UserId: 6, UserName: "Curtis Allen", Id: "ridge", Title: "Покоритель хребта",
+		Scope: "stolby", ScopeName: "Столбы", Date: InexactDate{2016, 5, 20},
+	}, summit.Achievements[0])
+}
+
 func TestSummitPutHandlerErrors(t *testing.T) {
 	cases := []struct {
 		name           string
@@ -412,6 +1103,162 @@ func TestSummitDeleteHandlerUnauthenticated(t *testing.T) {
 	assert.Equal(t, http.StatusUnauthorized, rr.Code, "handler returned wrong status code")
 }
 
+func TestSummitAscents(t *testing.T) {
+	app := GetMockApp(t, 7, &RuntimeConfig{Datadir: "testdata/summits", ItemsPerPage: 20})
+	cookie := &http.Cookie{Name: "session", Value: "mock_session_token"}
+
+	send := func(method, target string, form url.Values) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		var body io.Reader
+		if form != nil {
+			body = strings.NewReader(form.Encode())
+		}
+		req, err := http.NewRequest(method, target, body)
+		require.NoError(t, err)
+		req.AddCookie(cookie)
+		if form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		app.router.ServeHTTP(rr, req)
+		return rr
+	}
+	fetchSummit := func() Summit {
+		rr := send("GET", "/api/summit/malidak/kirel", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var summit Summit
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&summit))
+		return summit
+	}
+
+	before := fetchSummit()
+	require.NotNil(t, before.ClimbData)
+	assert.Nil(t, before.LatestClimb)
+	topBefore, err := app.Api.Storage.FetchTop(TopQuery{}, 0, 1, 20)
+	require.NoError(t, err)
+	climbsBefore, err := app.Api.Storage.FetchUserClimbs(7)
+	require.NoError(t, err)
+
+	rr := send("POST", "/api/summit/malidak/kirel/ascents", url.Values{"date": {"05.2030"}, "comment": {"Again"}})
+	require.Equal(t, http.StatusOK, rr.Code)
+	var added Ascent
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&added))
+	assert.Equal(t, Ascent{Id: added.Id, SummitId: "kirel", Date: InexactDate{2030, 5, 0}, Comment: "Again"}, added)
+
+	after := fetchSummit()
+	assert.Equal(t, before.ClimbData, after.ClimbData, "first ascent should not change")
+	assert.Equal(t, &ClimbData{Date: InexactDate{2030, 5, 0}, Comment: "Again"}, after.LatestClimb)
+	assert.Equal(t, 2, after.ClimbsNum)
+
+	rr = send("GET", "/api/summit/malidak/kirel/ascents", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var ascents []Ascent
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&ascents))
+	require.Len(t, ascents, 2)
+	assert.Equal(t, added, ascents[1])
+
+	// repeated ascents count neither in ratings nor in summit climbers
+	topAfter, err := app.Api.Storage.FetchTop(TopQuery{}, 0, 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, topBefore, topAfter)
+	climbsAfter, err := app.Api.Storage.FetchUserClimbs(7)
+	require.NoError(t, err)
+	assert.Equal(t, climbsBefore, climbsAfter)
+	_, total, err := app.Api.Storage.FetchSummitClimbs("kirel", 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, 6, total)
+
+	ascentUrl := fmt.Sprintf("/api/ascent/%d", added.Id)
+	rr = send("PUT", ascentUrl, url.Values{"date": {"2031"}, "comment": {"Edited"}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, &ClimbData{Date: InexactDate{2031, 0, 0}, Comment: "Edited"}, fetchSummit().LatestClimb)
+
+	rr = send("PUT", ascentUrl, url.Values{"date": {"invalid"}})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// other users' ascents can not be changed
+	found, err := app.Api.Storage.UpdateAscent(added.Id, 5, InexactDate{}, "", nil)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	rr = send("DELETE", ascentUrl, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Nil(t, fetchSummit().LatestClimb)
+
+	rr = send("DELETE", ascentUrl, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = send("PUT", "/api/ascent/abc", url.Values{"date": {"2031"}})
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestSummitPutHandlerTrack(t *testing.T) {
+	track, err := os.ReadFile("testdata/tracks/kirel.gpx")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name             string
+		url              string
+		date             string
+		track            []byte
+		expectedStatus   int
+		expectedDate     InexactDate
+		expectedVerified bool
+	}{
+		{"date taken from track", "/api/summit/malidak/kirel", "", track, http.StatusOK, InexactDate{2023, 7, 15}, true},
+		{"explicit date kept", "/api/summit/malidak/kirel", "2023", track, http.StatusOK, InexactDate{2023, 0, 0}, true},
+		{"summit not reached", "/api/summit/malidak/malinovaja", "", track, http.StatusOK, InexactDate{2023, 7, 15}, false},
+		{"invalid track", "/api/summit/malidak/kirel", "", []byte("not a gpx"), http.StatusBadRequest, InexactDate{}, false},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			app := GetMockApp(t, 7, &RuntimeConfig{Datadir: "testdata/summits"})
+			cookie := &http.Cookie{Name: "session", Value: "mock_session_token"}
+
+			body := &bytes.Buffer{}
+			mw := multipart.NewWriter(body)
+			require.NoError(t, mw.WriteField("date", tt.date))
+			require.NoError(t, mw.WriteField("comment", "With track"))
+			fw, err := mw.CreateFormFile("track", "track.gpx")
+			require.NoError(t, err)
+			_, err = fw.Write(tt.track)
+			require.NoError(t, err)
+			require.NoError(t, mw.Close())
+
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("PUT", tt.url, body)
+			require.NoError(t, err)
+			req.AddCookie(cookie)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			app.router.ServeHTTP(rr, req)
+			require.Equal(t, tt.expectedStatus, rr.Code, "handler returned wrong status code")
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			rr = httptest.NewRecorder()
+			req, err = http.NewRequest("GET", tt.url+"/ascents", nil)
+			require.NoError(t, err)
+			req.AddCookie(cookie)
+			app.router.ServeHTTP(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code)
+			var ascents []Ascent
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&ascents))
+			require.Len(t, ascents, 1)
+			assert.Equal(t, tt.expectedDate, ascents[0].Date)
+			assert.True(t, ascents[0].HasTrack)
+			assert.Equal(t, tt.expectedVerified, ascents[0].TrackVerified)
+
+			rr = httptest.NewRecorder()
+			req, err = http.NewRequest("GET", fmt.Sprintf("/api/ascent/%d/track", ascents[0].Id), nil)
+			require.NoError(t, err)
+			req.AddCookie(cookie)
+			app.router.ServeHTTP(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, "application/gpx+xml", rr.Header().Get("Content-Type"))
+			assert.Equal(t, tt.track, rr.Body.Bytes())
+		})
+	}
+}
+
 func TestSummitsGPXHandler(t *testing.T) {
 	conf := &RuntimeConfig{
 		Datadir:      "testdata/summits",
@@ -457,3 +1304,369 @@ func TestSummitsGPXHandler(t *testing.T) {
 		assert.Equal(t, expectedWpt.Description, actualWpt.Description, "Waypoint %d description mismatch", i)
 	}
 }
+
+func TestSummitsKMLHandler(t *testing.T) {
+	cases := []struct {
+		name                string
+		userId              int64
+		query               string
+		expectedFolders     []string
+		expectedStyles      int
+		expectedKirelStyle  string
+		expectedKirelFields int
+	}{
+		{"anonymous", 0, "", []string{"Куркак", "Малидак", "Столбы"}, 3, "#ridge-malidak", 4},
+		{"authenticated", 5, "", []string{"Куркак", "Малидак", "Столбы"}, 6, "#ridge-malidak-climbed", 5},
+		{"filtered", 5, "?ridge=malidak", []string{"Малидак"}, 2, "#ridge-malidak-climbed", 5},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			app := GetMockApp(t, tt.userId, &RuntimeConfig{Datadir: "testdata/summits"})
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/api/summits/kml"+tt.query, nil)
+			require.NoError(t, err)
+			if tt.userId != 0 {
+				req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+			}
+			app.router.ServeHTTP(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+			assert.Equal(t, "application/vnd.google-earth.kml+xml", rr.Header().Get("Content-Type"))
+
+			var doc kmlDocument
+			require.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &doc))
+			folders := make([]string, len(doc.Folders))
+			var kirel *kmlPlacemark
+			for i, f := range doc.Folders {
+				folders[i] = f.Name
+				for j, p := range f.Placemarks {
+					if p.Name == "Кирель" {
+						kirel = &doc.Folders[i].Placemarks[j]
+					}
+				}
+			}
+			assert.ElementsMatch(t, tt.expectedFolders, folders)
+			assert.Len(t, doc.Styles, tt.expectedStyles)
+			assert.Contains(t, doc.Styles, kmlStyle{"ridge-malidak", "ff798a7a", kmlIcon, 0.8})
+
+			require.NotNil(t, kirel, "Kirel placemark not found")
+			assert.Equal(t, tt.expectedKirelStyle, kirel.StyleUrl)
+			assert.Equal(t, "58.2311,54.0286,1162", kirel.Coordinates)
+			assert.Len(t, kirel.ExtendedData, tt.expectedKirelFields)
+			assert.Contains(t, kirel.ExtendedData, kmlData{"visitors", "6"})
+		})
+	}
+}
+
+func TestSummitsGeoJSONHandler(t *testing.T) {
+	cases := []struct {
+		name            string
+		userId          int64
+		expectedClimbed any
+	}{
+		{"anonymous", 0, nil},
+		{"authenticated", 5, true},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			app := GetMockApp(t, tt.userId, &RuntimeConfig{Datadir: "testdata/summits"})
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/api/summits/geojson", nil)
+			require.NoError(t, err)
+			if tt.userId != 0 {
+				req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+			}
+			app.router.ServeHTTP(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+			assert.Equal(t, "application/geo+json", rr.Header().Get("Content-Type"))
+
+			var fc struct {
+				Type     string `json:"type"`
+				Features []struct {
+					Id       string `json:"id"`
+					Geometry struct {
+						Type        string    `json:"type"`
+						Coordinates []float64 `json:"coordinates"`
+					} `json:"geometry"`
+					Properties map[string]any `json:"properties"`
+				} `json:"features"`
+			}
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&fc))
+			assert.Equal(t, "FeatureCollection", fc.Type)
+			require.Len(t, fc.Features, 5)
+			kirel := fc.Features[1]
+			assert.Equal(t, "kirel", kirel.Id)
+			assert.Equal(t, "Point", kirel.Geometry.Type)
+			assert.Equal(t, []float64{58.2311, 54.0286, 1162}, kirel.Geometry.Coordinates)
+			assert.Equal(t, "Кирель", kirel.Properties["name"])
+			assert.Equal(t, "Малидак", kirel.Properties["ridge"])
+			assert.Equal(t, float64(15), kirel.Properties["prominence"])
+			assert.Equal(t, float64(6), kirel.Properties["visitors"])
+			assert.Equal(t, tt.expectedClimbed, kirel.Properties["climbed"])
+		})
+	}
+}
+
+func TestUserSummitsExport(t *testing.T) {
+	cases := []struct {
+		name            string
+		url             string
+		expectedStatus  int
+		expectedSummits []string
+	}{
+		// waypoints are named by summit name or height
+		{"missing gpx", "/api/user/5/missing/gpx", http.StatusOK, []string{"1021", "Столбы"}},
+		{"climbed gpx", "/api/user/5/climbs/gpx", http.StatusOK, []string{"Кирель", "Куркак", "Малиновая"}},
+		{"climbed gpx by ridge", "/api/user/5/climbs/gpx?ridge=malidak", http.StatusOK, []string{"Кирель", "Малиновая"}},
+		{"climbed gpx by height", "/api/user/5/climbs/gpx?min_height=1155", http.StatusOK, []string{"Кирель"}},
+		{"missing gpx of user without climbs", "/api/user/1/missing/gpx?ridge=malidak", http.StatusOK, []string{"Кирель", "Малиновая"}},
+		{"missing kml", "/api/user/5/missing/kml", http.StatusOK, []string{"1021", "stolby"}},
+		{"unknown user", "/api/user/1000/missing/gpx", http.StatusNotFound, nil},
+		{"unknown format", "/api/user/5/missing/csv", http.StatusNotFound, nil},
+		{"invalid filter", "/api/user/5/missing/gpx?min_height=high", http.StatusBadRequest, nil},
+		{"paginated", "/api/user/5/climbs/gpx?page=1", http.StatusBadRequest, nil},
+		{"page size", "/api/user/5/missing/kml?per_page=1", http.StatusBadRequest, nil},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			app := GetMockApp(t, 0, &RuntimeConfig{Datadir: "testdata/summits"})
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", tt.url, nil)
+			require.NoError(t, err)
+			app.router.ServeHTTP(rr, req)
+			require.Equal(t, tt.expectedStatus, rr.Code, "handler returned wrong status code")
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var summits []string
+			if strings.Contains(tt.url, "/kml") {
+				assert.Equal(t, "application/vnd.google-earth.kml+xml", rr.Header().Get("Content-Type"))
+				var doc kmlDocument
+				require.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &doc))
+				assert.Equal(t, "Jonathan Nguyen: непокорённые вершины", doc.Name)
+				for _, f := range doc.Folders {
+					for _, p := range f.Placemarks {
+						summits = append(summits, p.ExtendedData[0].Value)
+					}
+				}
+			} else {
+				assert.Equal(t, "application/gpx+xml", rr.Header().Get("Content-Type"))
+				g, err := gpx.ParseBytes(rr.Body.Bytes())
+				require.NoError(t, err)
+				for _, wpt := range g.Waypoints {
+					summits = append(summits, wpt.Name)
+				}
+			}
+			assert.ElementsMatch(t, tt.expectedSummits, summits)
+		})
+	}
+}
+
+func TestPlanHandler(t *testing.T) {
+	cases := []struct {
+		name           string
+		userId         int64
+		query          string
+		expectedStatus int
+		expectedDays   [][]string
+	}{
+		{"summits by id", 0, "?start=54.03,58.2&summit=stolby&summit=kirel&summit=malinovaja&summit=1021&daily_distance=50",
+			http.StatusOK, [][]string{{"kirel", "malinovaja", "1021", "stolby"}}},
+		{"split into days", 0, "?start=54.03,58.2&summit=stolby&summit=kirel&summit=malinovaja&summit=1021&daily_distance=10",
+			http.StatusOK, [][]string{{"kirel", "malinovaja"}, {"1021"}, {"stolby"}}},
+		{"missing summits on ridge", 5, "?start=54.03,58.2&ridge=stolby&ridge=malidak&daily_distance=50",
+			http.StatusOK, [][]string{{"1021", "stolby"}}},
+		{"ridge requires authentication", 0, "?start=54.03,58.2&ridge=stolby", http.StatusUnauthorized, nil},
+		{"unknown summit", 0, "?start=54.03,58.2&summit=everest", http.StatusBadRequest, nil},
+		{"no summits", 0, "?start=54.03,58.2", http.StatusBadRequest, nil},
+		{"no start", 0, "?summit=kirel", http.StatusBadRequest, nil},
+		{"invalid daily distance", 0, "?start=54.03,58.2&summit=kirel&daily_distance=-1", http.StatusBadRequest, nil},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			app := GetMockApp(t, tt.userId, &RuntimeConfig{Datadir: "testdata/summits"})
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/api/plan"+tt.query, nil)
+			require.NoError(t, err)
+			if tt.userId != 0 {
+				req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+			}
+			app.router.ServeHTTP(rr, req)
+			require.Equal(t, tt.expectedStatus, rr.Code, "handler returned wrong status code")
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var plan Plan
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&plan))
+			days := make([][]string, len(plan.Days))
+			for i, day := range plan.Days {
+				for _, stop := range day.Stops {
+					days[i] = append(days[i], stop.Id)
+				}
+			}
+			assert.Equal(t, tt.expectedDays, days)
+			assert.Equal(t, [2]float64{54.03, 58.2}, plan.Start)
+		})
+	}
+}
+
+func TestPlanGPXHandler(t *testing.T) {
+	app := GetMockApp(t, 0, &RuntimeConfig{Datadir: "testdata/summits"})
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/api/plan/gpx?start=54.03,58.2&summit=stolby&summit=kirel&summit=malinovaja&summit=1021&daily_distance=10", nil)
+	require.NoError(t, err)
+	app.router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+	assert.Equal(t, "application/gpx+xml", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "attachment; filename=plan.gpx", rr.Header().Get("Content-Disposition"))
+
+	g, err := gpx.ParseBytes(rr.Body.Bytes())
+	require.NoError(t, err)
+	require.Len(t, g.Routes, 3)
+	require.Len(t, g.Waypoints, 4)
+	assert.Equal(t, "День 1", g.Routes[0].Name)
+	// every day starts where the previous one ended
+	require.Len(t, g.Routes[0].Points, 3)
+	assert.Equal(t, "Старт", g.Routes[0].Points[0].Name)
+	assert.Equal(t, "Кирель", g.Routes[0].Points[1].Name)
+	assert.Equal(t, 54.0286, g.Routes[0].Points[1].Latitude)
+	assert.Equal(t, 1162.0, g.Routes[0].Points[1].Elevation.Value())
+	require.Len(t, g.Routes[1].Points, 2)
+	assert.Equal(t, g.Routes[0].Points[2].Name, g.Routes[1].Points[0].Name)
+}
+
+func TestSummitNearbyHandler(t *testing.T) {
+	cases := []struct {
+		name           string
+		userId         int64
+		url            string
+		expectedStatus int
+		expectedIds    []string
+		expectedClimbs []bool
+	}{
+		{"default limit", 0, "/api/summit/malidak/kirel/nearby", http.StatusOK,
+			[]string{"malinovaja", "1021", "stolby", "kurkak"}, []bool{false, false, false, false}},
+		{"limited", 5, "/api/summit/malidak/kirel/nearby?limit=2", http.StatusOK,
+			[]string{"malinovaja", "1021"}, []bool{true, false}},
+		{"unknown summit", 0, "/api/summit/malidak/everest/nearby", http.StatusNotFound, nil, nil},
+		{"invalid limit", 0, "/api/summit/malidak/kirel/nearby?limit=0", http.StatusBadRequest, nil, nil},
+		{"point", 5, "/api/summits/near?lat=54.19&lng=58.47&radius=5", http.StatusOK,
+			[]string{"1021", "stolby"}, []bool{false, false}},
+		{"point default radius", 5, "/api/summits/near?lat=54.03&lng=58.2", http.StatusOK,
+			[]string{"kirel", "malinovaja"}, []bool{true, true}},
+		{"point without lng", 0, "/api/summits/near?lat=54.03", http.StatusBadRequest, nil, nil},
+		{"point radius too large", 0, "/api/summits/near?lat=54.03&lng=58.2&radius=1000", http.StatusBadRequest, nil, nil},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			app := GetMockApp(t, tt.userId, &RuntimeConfig{Datadir: "testdata/summits"})
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", tt.url, nil)
+			require.NoError(t, err)
+			if tt.userId != 0 {
+				req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+			}
+			app.router.ServeHTTP(rr, req)
+			require.Equal(t, tt.expectedStatus, rr.Code, "handler returned wrong status code")
+			if tt.expectedIds == nil {
+				return
+			}
+			var resp struct {
+				Summits []NearbySummit `json:"summits"`
+			}
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+			ids := make([]string, len(resp.Summits))
+			climbed := make([]bool, len(resp.Summits))
+			for i, s := range resp.Summits {
+				ids[i], climbed[i] = s.Id, s.Climbed
+			}
+			assert.Equal(t, tt.expectedIds, ids)
+			assert.Equal(t, tt.expectedClimbs, climbed)
+		})
+	}
+
+	app := GetMockApp(t, 0, &RuntimeConfig{Datadir: "testdata/summits"})
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/api/summit/malidak/kirel/nearby?limit=1", nil)
+	require.NoError(t, err)
+	app.router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp struct {
+		Summits []NearbySummit `json:"summits"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Len(t, resp.Summits, 1)
+	// malinovaja is about 4 km to the east-southeast of kirel
+	assert.InDelta(t, 4.0, resp.Summits[0].Distance, 0.1)
+	assert.InDelta(t, 101, resp.Summits[0].Bearing, 5)
+}
+
+func TestCatalogChangesHandler(t *testing.T) {
+	conf := &RuntimeConfig{
+		Datadir:      "testdata/summits",
+		ItemsPerPage: 5,
+	}
+	app := GetMockApp(t, 0, conf)
+
+	req, err := http.NewRequest("GET", "/api/catalog/changes", nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	app.router.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"), "Wrong Content-Type header")
+
+	var response struct {
+		Revisions      []CatalogRevision `json:"revisions"`
+		TotalRevisions int               `json:"total_revisions"`
+		Page           int               `json:"page"`
+	}
+	err = json.NewDecoder(rr.Body).Decode(&response)
+	require.NoError(t, err, "Failed to decode response")
+
+	assert.Equal(t, 1, response.TotalRevisions)
+	assert.Equal(t, 1, response.Page)
+	require.Len(t, response.Revisions, 1)
+	rev := response.Revisions[0]
+	assert.False(t, rev.CreatedAt.IsZero(), "Revision timestamp is not set")
+	assert.Equal(t, &CatalogDiff{
+		Summits: 5,
+		Added:   []string{"1021", "kirel", "kurkak", "malinovaja", "stolby"},
+		LegacyRemaps: []CatalogLegacyRemap{
+			{"1026", "stolby"},
+			{"1026-1", "stolby"},
+		},
+		ClimbsRewritten: []CatalogClimbsRewrite{{"1026", "stolby", 1}},
+	}, rev.Changes)
+}
+
+func TestSearchHandler(t *testing.T) {
+	conf := &RuntimeConfig{
+		Datadir:      "testdata/summits",
+		ItemsPerPage: 5,
+	}
+	app := GetMockApp(t, 0, conf)
+
+	req, err := http.NewRequest("GET", "/api/search?q="+url.QueryEscape("Kirel"), nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	app.router.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"), "Wrong Content-Type header")
+
+	var response struct {
+		Results []SearchResult `json:"results"`
+	}
+	err = json.NewDecoder(rr.Body).Decode(&response)
+	require.NoError(t, err, "Failed to decode response")
+	require.Len(t, response.Results, 1)
+	result := response.Results[0]
+	assert.Equal(t, "kirel", result.Id)
+	assert.Equal(t, "malidak", result.RidgeId)
+	assert.Equal(t, "Малидак", result.RidgeName)
+	assert.Equal(t, 1162, result.Height)
+	assert.Equal(t, "<b>Кирель</b>", result.Snippet)
+}
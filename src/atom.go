@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const atomNS = "http://www.w3.org/2005/Atom"
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	Uri  string `xml:"uri,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Id        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Link      atomLink   `xml:"link"`
+	Author    atomPerson `xml:"author"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Summary   atomText   `xml:"summary"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// AtomFeedInfo describes a feed. Paths are relative to the site base url:
+// Self is the feed itself and Page is the SPA route it is about.
+type AtomFeedInfo struct {
+	Title string
+	Self  string
+	Page  string
+}
+
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// climbTagDate is the fixed date of entry tags, climbs registered before
+// timestamps have no creation date to put there
+const climbTagDate = "2024"
+
+// climbEntryId makes a tag URI (RFC 4151) for the climb, it does not
+// change when the climb is edited or the site moves to https
+func climbEntryId(host string, e FeedEntry) string {
+	return fmt.Sprintf("tag:%s,%s:climb/%d", host, climbTagDate, e.ClimbId)
+}
+
+func climbEntryTitle(e FeedEntry) string {
+	summit := fmt.Sprintf("%d", e.Height)
+	if e.SummitName != nil && *e.SummitName != "" {
+		summit = fmt.Sprintf("%s (%d)", *e.SummitName, e.Height)
+	}
+	return fmt.Sprintf("%s: %s, хр. %s", e.UserName, summit, e.RidgeName)
+}
+
+func climbEntrySummary(e FeedEntry) string {
+	lines := make([]string, 0, 2)
+	if date := e.Date.String(); date != "" {
+		lines = append(lines, "Дата восхождения: "+date)
+	}
+	if e.Comment != "" {
+		lines = append(lines, e.Comment)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ClimbsAtom renders feed entries, the latest first, as Atom feed.
+// Entries link to the summit page, authors to the climber page.
+func ClimbsAtom(baseUrl string, info AtomFeedInfo, entries []FeedEntry) ([]byte, error) {
+	base, err := url.Parse(baseUrl)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid base url %q", baseUrl)
+	}
+	baseUrl = strings.TrimSuffix(baseUrl, "/")
+	feed := atomFeed{
+		Xmlns: atomNS,
+		Id:    baseUrl + info.Self,
+		Title: info.Title,
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: baseUrl + info.Self},
+			{Rel: "alternate", Type: "text/html", Href: baseUrl + info.Page},
+		},
+		Entries: make([]atomEntry, 0, len(entries)),
+	}
+	// feed is as fresh as its latest change
+	updated := time.Unix(0, 0)
+	for _, e := range entries {
+		if e.UpdatedAt.After(updated) {
+			updated = e.UpdatedAt
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Id:    climbEntryId(base.Hostname(), e),
+			Title: climbEntryTitle(e),
+			Link:  atomLink{Rel: "alternate", Type: "text/html", Href: fmt.Sprintf("%s/%s/%s", baseUrl, e.RidgeId, e.SummitId)},
+			Author: atomPerson{
+				Name: e.UserName,
+				Uri:  fmt.Sprintf("%s/user/%d", baseUrl, e.UserId),
+			},
+			Published: atomTime(e.CreatedAt),
+			Updated:   atomTime(e.UpdatedAt),
+			Summary:   atomText{Type: "text", Body: climbEntrySummary(e)},
+		})
+	}
+	feed.Updated = atomTime(updated)
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
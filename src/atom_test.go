@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClimbsAtom(t *testing.T) {
+	name := "Кирель"
+	created := time.Date(2024, 5, 12, 10, 30, 0, 0, time.UTC)
+	entries := []FeedEntry{
+		{
+			ClimbId: 42, UserId: 6, UserName: "Curtis Allen",
+			SummitId: "kirel", SummitName: &name, Height: 1162, RidgeId: "malidak", RidgeName: "Малидак",
+			Date: InexactDate{2024, 5, 11}, Comment: "Туман",
+			CreatedAt: created, UpdatedAt: created.Add(time.Hour),
+		},
+		{
+			ClimbId: 7, UserId: 5, UserName: "Jonathan Nguyen",
+			SummitId: "1021", Height: 1021, RidgeId: "stolby", RidgeName: "Столбы",
+			CreatedAt: created.Add(-time.Hour), UpdatedAt: created.Add(-time.Hour),
+		},
+	}
+	info := AtomFeedInfo{Title: "Восхождения", Self: "/api/feed/atom", Page: "/"}
+	data, err := ClimbsAtom("https://example.org/", info, entries)
+	require.NoError(t, err)
+
+	var feed atomFeed
+	require.NoError(t, xml.Unmarshal(data, &feed))
+	assert.Equal(t, "https://example.org/api/feed/atom", feed.Id)
+	assert.Equal(t, "Восхождения", feed.Title)
+	assert.Equal(t, []atomLink{
+		{Rel: "self", Type: "application/atom+xml", Href: "https://example.org/api/feed/atom"},
+		{Rel: "alternate", Type: "text/html", Href: "https://example.org/"},
+	}, feed.Links)
+	assert.Equal(t, "2024-05-12T11:30:00Z", feed.Updated)
+	require.Len(t, feed.Entries, 2)
+
+	e := feed.Entries[0]
+	assert.Equal(t, "tag:example.org,2024:climb/42", e.Id)
+	assert.Equal(t, "Curtis Allen: Кирель (1162), хр. Малидак", e.Title)
+	assert.Equal(t, "https://example.org/malidak/kirel", e.Link.Href)
+	assert.Equal(t, atomPerson{"Curtis Allen", "https://example.org/user/6"}, e.Author)
+	assert.Equal(t, "2024-05-12T10:30:00Z", e.Published)
+	assert.Equal(t, "2024-05-12T11:30:00Z", e.Updated)
+	assert.Equal(t, "Дата восхождения: 11.05.2024\nТуман", e.Summary.Body)
+
+	e = feed.Entries[1]
+	assert.Equal(t, "tag:example.org,2024:climb/7", e.Id)
+	assert.Equal(t, "Jonathan Nguyen: 1021, хр. Столбы", e.Title)
+	assert.Equal(t, "", e.Summary.Body)
+
+	_, err = ClimbsAtom("example.org", info, entries)
+	assert.Error(t, err)
+}
+
+func TestClimbsAtomEditedLegacyClimb(t *testing.T) {
+	storage := NewStorage(MockDatabase(t))
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+
+	entryId := func() string {
+		feed, err := storage.FetchFeed(FeedQuery{UserIds: []int64{1}, Dated: true, Limit: 100})
+		require.NoError(t, err)
+		for _, e := range feed.Items {
+			if e.ClimbId == 1 {
+				return climbEntryId("example.org", e)
+			}
+		}
+		require.Fail(t, "climb 1 is not in the feed")
+		return ""
+	}
+	// mock climb 1 was registered before timestamps
+	before := entryId()
+	ok, err := storage.UpdateAscent(1, 1, InexactDate{1991, 0, 0}, "edited", nil)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, before, entryId())
+}
+
+func TestClimbsAtomEmpty(t *testing.T) {
+	data, err := ClimbsAtom("http://localhost:8080", AtomFeedInfo{Title: "Пусто", Self: "/api/user/5/atom", Page: "/user/5"}, nil)
+	require.NoError(t, err)
+	var feed atomFeed
+	require.NoError(t, xml.Unmarshal(data, &feed))
+	// updated is required, empty feed must stay stable between requests
+	assert.Equal(t, "1970-01-01T00:00:00Z", feed.Updated)
+	assert.Empty(t, feed.Entries)
+	assert.Equal(t, "http://localhost:8080/user/5", feed.Links[1].Href)
+}
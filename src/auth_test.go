@@ -252,7 +252,7 @@ func NewApp(mockOauthProvider Provider, t *testing.T) *App {
 	providers["mock"] = mockOauthProvider
 	sm := scs.New()
 	conf := &RuntimeConfig{Datadir: "testdata/summits"}
-	api := NewApi(conf, storage, sm)
+	api := NewApi(conf, storage, sm, NewMockImageManager(t.TempDir()))
 	as := NewAuthServer(providers, storage, sm)
 	app := &App{
 		Api:        api,
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// summitFingerprint holds all the catalog data of a summit
+// which is enough to tell if summit was changed between loads
+type summitFingerprint struct {
+	RidgeId        string
+	Name           sql.NullString
+	NameAlt        sql.NullString
+	Interpretation sql.NullString
+	Description    sql.NullString
+	Height         int
+	Prominence     int
+	Lat            float64
+	Lng            float64
+	Images         string
+}
+
+// changedFields returns names of fields which differ in other fingerprint
+func (fp summitFingerprint) changedFields(other summitFingerprint) []string {
+	fields := make([]string, 0)
+	for _, f := range []struct {
+		name    string
+		changed bool
+	}{
+		{"ridge", fp.RidgeId != other.RidgeId},
+		{"name", fp.Name != other.Name},
+		{"name_alt", fp.NameAlt != other.NameAlt},
+		{"interpretation", fp.Interpretation != other.Interpretation},
+		{"description", fp.Description != other.Description},
+		{"height", fp.Height != other.Height},
+		{"prominence", fp.Prominence != other.Prominence},
+		{"coordinates", fp.Lat != other.Lat || fp.Lng != other.Lng},
+		{"images", fp.Images != other.Images},
+	} {
+		if f.changed {
+			fields = append(fields, f.name)
+		}
+	}
+	return fields
+}
+
+// catalogSnapshot is a state of the catalog at some point of LoadSummits transaction
+type catalogSnapshot struct {
+	summits   map[string]summitFingerprint
+	legacyIds map[string]string
+}
+
+func takeCatalogSnapshot(tx *sql.Tx) (*catalogSnapshot, error) {
+	snapshot := &catalogSnapshot{
+		summits:   make(map[string]summitFingerprint),
+		legacyIds: make(map[string]string),
+	}
+	rows, err := tx.Query(
+		`SELECT id, ridge_id, name, name_alt, interpretation, description, height, prominence, lat, lng
+		FROM summits`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var fp summitFingerprint
+		err := rows.Scan(&id, &fp.RidgeId, &fp.Name, &fp.NameAlt, &fp.Interpretation, &fp.Description,
+			&fp.Height, &fp.Prominence, &fp.Lat, &fp.Lng)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.summits[id] = fp
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	imgRows, err := tx.Query(
+		`SELECT summit_id, url, preview_url, comment FROM summit_images ORDER BY summit_id, url`)
+	if err != nil {
+		return nil, err
+	}
+	defer imgRows.Close()
+	for imgRows.Next() {
+		var summitId, url, previewUrl, comment string
+		if err := imgRows.Scan(&summitId, &url, &previewUrl, &comment); err != nil {
+			return nil, err
+		}
+		fp := snapshot.summits[summitId]
+		fp.Images += fmt.Sprintf("%s\x00%s\x00%s\n", url, previewUrl, comment)
+		snapshot.summits[summitId] = fp
+	}
+	if err := imgRows.Err(); err != nil {
+		return nil, err
+	}
+
+	legacyRows, err := tx.Query(`SELECT legacy_id, summit_id FROM summit_ids_legacy`)
+	if err != nil {
+		return nil, err
+	}
+	defer legacyRows.Close()
+	for legacyRows.Next() {
+		var legacyId, summitId string
+		if err := legacyRows.Scan(&legacyId, &summitId); err != nil {
+			return nil, err
+		}
+		snapshot.legacyIds[legacyId] = summitId
+	}
+	return snapshot, legacyRows.Err()
+}
+
+type CatalogRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type CatalogSummitChange struct {
+	SummitId       string      `json:"summit_id"`
+	Fields         []string    `json:"fields"`
+	OldHeight      int         `json:"old_height,omitempty"`
+	NewHeight      int         `json:"new_height,omitempty"`
+	OldCoordinates *[2]float64 `json:"old_coordinates,omitempty"`
+	NewCoordinates *[2]float64 `json:"new_coordinates,omitempty"`
+}
+
+type CatalogLegacyRemap struct {
+	LegacyId string `json:"legacy_id"`
+	SummitId string `json:"summit_id"`
+}
+
+type CatalogClimbsRewrite struct {
+	LegacyId string `json:"legacy_id"`
+	SummitId string `json:"summit_id"`
+	Climbs   int    `json:"climbs"`
+}
+
+// CatalogDiff describes what LoadSummits changed in summits catalog
+type CatalogDiff struct {
+	Summits         int                    `json:"summits"`
+	Added           []string               `json:"added,omitempty"`
+	Removed         []string               `json:"removed,omitempty"`
+	Renamed         []CatalogRename        `json:"renamed,omitempty"`
+	Changed         []CatalogSummitChange  `json:"changed,omitempty"`
+	LegacyRemaps    []CatalogLegacyRemap   `json:"legacy_remaps,omitempty"`
+	ClimbsRewritten []CatalogClimbsRewrite `json:"climbs_rewritten,omitempty"`
+	ClimbsArchived  []OrphanedClimbs       `json:"climbs_archived,omitempty"`
+}
+
+func (d *CatalogDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Renamed) == 0 &&
+		len(d.Changed) == 0 && len(d.LegacyRemaps) == 0 && len(d.ClimbsRewritten) == 0 &&
+		len(d.ClimbsArchived) == 0
+}
+
+func (d *CatalogDiff) addChange(summitId string, before, after summitFingerprint) {
+	fields := before.changedFields(after)
+	if len(fields) == 0 {
+		return
+	}
+	change := CatalogSummitChange{SummitId: summitId, Fields: fields}
+	if before.Height != after.Height {
+		change.OldHeight, change.NewHeight = before.Height, after.Height
+	}
+	if before.Lat != after.Lat || before.Lng != after.Lng {
+		change.OldCoordinates = &[2]float64{before.Lat, before.Lng}
+		change.NewCoordinates = &[2]float64{after.Lat, after.Lng}
+	}
+	d.Changed = append(d.Changed, change)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diffCatalogSnapshots compares catalog states before and after load.
+// Summit removed in favour of a new one having old id in legacy ids
+// is reported as renamed rather than removed and added.
+func diffCatalogSnapshots(before, after *catalogSnapshot) *CatalogDiff {
+	diff := &CatalogDiff{Summits: len(after.summits)}
+	renamedTo := make(map[string]bool)
+	for _, id := range sortedKeys(before.summits) {
+		if _, ok := after.summits[id]; ok {
+			continue
+		}
+		newId, ok := after.legacyIds[id]
+		if _, existed := before.summits[newId]; ok && !existed && !renamedTo[newId] {
+			renamedTo[newId] = true
+			diff.Renamed = append(diff.Renamed, CatalogRename{id, newId})
+			diff.addChange(newId, before.summits[id], after.summits[newId])
+			continue
+		}
+		diff.Removed = append(diff.Removed, id)
+	}
+	for _, id := range sortedKeys(after.summits) {
+		prev, ok := before.summits[id]
+		switch {
+		case renamedTo[id]:
+		case !ok:
+			diff.Added = append(diff.Added, id)
+		default:
+			diff.addChange(id, prev, after.summits[id])
+		}
+	}
+	for _, legacyId := range sortedKeys(after.legacyIds) {
+		summitId := after.legacyIds[legacyId]
+		if before.legacyIds[legacyId] != summitId {
+			diff.LegacyRemaps = append(diff.LegacyRemaps, CatalogLegacyRemap{legacyId, summitId})
+		}
+	}
+	return diff
+}
+
+// fetchLegacyClimbs counts climbs referencing legacy summit ids,
+// i.e. climbs to be rewritten by LoadSummits
+func fetchLegacyClimbs(tx *sql.Tx) ([]CatalogClimbsRewrite, error) {
+	rows, err := tx.Query(`SELECT sil.legacy_id, sil.summit_id, COUNT(*)
+		FROM climbs c INNER JOIN summit_ids_legacy sil ON c.summit_id = sil.legacy_id
+		GROUP BY sil.legacy_id, sil.summit_id
+		ORDER BY sil.legacy_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rewrites []CatalogClimbsRewrite
+	for rows.Next() {
+		var cr CatalogClimbsRewrite
+		if err := rows.Scan(&cr.LegacyId, &cr.SummitId, &cr.Climbs); err != nil {
+			return nil, err
+		}
+		rewrites = append(rewrites, cr)
+	}
+	return rewrites, rows.Err()
+}
+
+// OrphanedClimbs is a number of climbs referencing summit missing in the catalog
+type OrphanedClimbs struct {
+	SummitId string `json:"summit_id"`
+	Climbs   int    `json:"climbs"`
+}
+
+type OrphanedClimbsError struct {
+	Orphans []OrphanedClimbs
+}
+
+func (e *OrphanedClimbsError) Error() string {
+	return fmt.Sprintf(
+		"climbs reference summits missing in the catalog: %s; "+
+			"add missing ids to legacy_ids or archive these climbs explicitly",
+		formatOrphans(e.Orphans))
+}
+
+// OrphanedAttachmentsError is returned on archiving climbs which have
+// tracks, photos or partners. Archive keeps only date and comment
+// of a climb, so attachments are dropped only if asked to.
+type OrphanedAttachmentsError struct {
+	Orphans []OrphanedClimbs
+}
+
+func (e *OrphanedAttachmentsError) Error() string {
+	return fmt.Sprintf(
+		"climbs to archive have tracks, photos or partners which would be lost: %s; "+
+			"add missing ids to legacy_ids or drop attachments explicitly",
+		formatOrphans(e.Orphans))
+}
+
+func formatOrphans(orphans []OrphanedClimbs) string {
+	summits := make([]string, len(orphans))
+	for i, o := range orphans {
+		summits[i] = fmt.Sprintf("%s (%d)", o.SummitId, o.Climbs)
+	}
+	return strings.Join(summits, ", ")
+}
+
+// fetchOrphanedClimbs counts climbs which summit is not in the catalog
+func fetchOrphanedClimbs(tx *sql.Tx) ([]OrphanedClimbs, error) {
+	return queryOrphanedClimbs(tx, "")
+}
+
+// fetchOrphanedAttachedClimbs counts orphaned climbs having a track,
+// photos or partners, which do not survive archiving
+func fetchOrphanedAttachedClimbs(tx *sql.Tx) ([]OrphanedClimbs, error) {
+	return queryOrphanedClimbs(tx, `AND (
+		EXISTS (SELECT 1 FROM climb_tracks t WHERE t.climb_id = c.id)
+		OR EXISTS (SELECT 1 FROM climb_photos p WHERE p.climb_id = c.id)
+		OR EXISTS (SELECT 1 FROM climb_partners cp WHERE cp.climb_id = c.id OR cp.partner_climb_id = c.id))`)
+}
+
+func queryOrphanedClimbs(tx *sql.Tx, cond string) ([]OrphanedClimbs, error) {
+	rows, err := tx.Query(`SELECT summit_id, COUNT(*) FROM climbs c
+		WHERE summit_id NOT IN (SELECT id FROM summits) ` + cond + `
+		GROUP BY summit_id ORDER BY summit_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var orphans []OrphanedClimbs
+	for rows.Next() {
+		var o OrphanedClimbs
+		if err := rows.Scan(&o.SummitId, &o.Climbs); err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, o)
+	}
+	return orphans, rows.Err()
+}
+
+// archiveOrphanedClimbs moves climbs which summit is not in the catalog
+// to climbs_archived table, so users do not lose them completely.
+// Tracks, photos and partners go away with the climbs, renditions
+// of photos are queued for deletion from image storage.
+func archiveOrphanedClimbs(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT p.key FROM climb_photos p INNER JOIN climbs c ON c.id = p.climb_id
+		WHERE c.summit_id NOT IN (SELECT id FROM summits)`)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for rows.Next() {
+		var base string
+		if err := rows.Scan(&base); err != nil {
+			rows.Close()
+			return err
+		}
+		stale = append(stale, photoKeys(base)...)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := addStaleImages(tx, stale); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO climbs_archived
+			(user_id, summit_id, year, month, day, comment, archived_at)
+		SELECT user_id, summit_id, year, month, day, comment, ?
+		FROM climbs WHERE summit_id NOT IN (SELECT id FROM summits)`, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM climbs WHERE summit_id NOT IN (SELECT id FROM summits)`)
+	return err
+}
+
+type CatalogRevision struct {
+	Id        int64        `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	Changes   *CatalogDiff `json:"changes"`
+}
+
+func saveCatalogRevision(tx *sql.Tx, diff *CatalogDiff) error {
+	changes, err := json.Marshal(diff)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO catalog_revisions (created_at, changes) VALUES (?, ?)`,
+		time.Now().UTC(), string(changes))
+	return err
+}
+
+func (s *Storage) FetchCatalogRevisions(page, itemsPerPage int) ([]CatalogRevision, int, error) {
+	totalRevisions, err := s.Count("SELECT COUNT(*) FROM catalog_revisions")
+	if err != nil {
+		return nil, 0, err
+	}
+	offset := (page - 1) * itemsPerPage
+	rows, err := s.db.Query(`SELECT id, created_at, changes FROM catalog_revisions
+		ORDER BY id DESC LIMIT ? OFFSET ?`, itemsPerPage, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	revisions := make([]CatalogRevision, 0)
+	for rows.Next() {
+		var rev CatalogRevision
+		var changes string
+		if err := rows.Scan(&rev.Id, &rev.CreatedAt, &changes); err != nil {
+			return nil, 0, err
+		}
+		rev.Changes = &CatalogDiff{}
+		if err := json.Unmarshal([]byte(changes), rev.Changes); err != nil {
+			return nil, 0, fmt.Errorf("failed to decode catalog revision %d: %v", rev.Id, err)
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, totalRevisions, rows.Err()
+}
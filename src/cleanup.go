@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// AddStaleImages queues images for deletion from storage
+func (s *Storage) AddStaleImages(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := addStaleImages(tx, keys); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addStaleImages queues images for deletion within transaction
+// which makes them unreferenced
+func addStaleImages(tx *sql.Tx, keys []string) error {
+	now := time.Now().UTC()
+	for _, key := range keys {
+		_, err := tx.Exec(`INSERT INTO stale_images (key, created_at) VALUES (?, ?) ON CONFLICT DO NOTHING`, key, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchStaleImages returns images queued for deletion, the oldest first
+func (s *Storage) FetchStaleImages() ([]string, error) {
+	rows, err := s.db.Query(`SELECT key FROM stale_images ORDER BY created_at, key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *Storage) RemoveStaleImage(key string) error {
+	_, err := s.db.Exec(`DELETE FROM stale_images WHERE key = ?`, key)
+	return err
+}
+
+// CleanupImages deletes images queued as stale. Images failed to be
+// deleted stay in the queue. Returns number of images deleted.
+func CleanupImages(ctx context.Context, storage *Storage, im ImageManager) (int, error) {
+	stale, err := storage.FetchStaleImages()
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, key := range stale {
+		if err := im.Delete(ctx, key); err != nil {
+			slog.Error("Failed to delete stale image", "key", key, "error", err)
+			continue
+		}
+		if err := storage.RemoveStaleImage(key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deleteFailingImageManager fails to delete images while broken is set
+type deleteFailingImageManager struct {
+	*MockImageManager
+	broken bool
+}
+
+func (im *deleteFailingImageManager) Delete(ctx context.Context, key string) error {
+	if im.broken {
+		return errors.New("delete failed")
+	}
+	return im.MockImageManager.Delete(ctx, key)
+}
+
+func TestCleanupImages(t *testing.T) {
+	ctx := context.Background()
+	storage := NewStorage(MockDatabase(t))
+	dir := t.TempDir()
+	im := &deleteFailingImageManager{NewMockImageManager(dir), true}
+	for _, key := range []string{"photos/1/1_ab_L.jpg", "photos/1/1_ab_M.jpg"} {
+		require.NoError(t, im.Upload(ctx, testJPEG(t, 4, 4), key))
+	}
+
+	// failed deletions are queued
+	api := &Api{Storage: storage, ImageManager: im}
+	api.deleteImages(ctx, []string{"photos/1/1_ab_L.jpg", "photos/1/1_ab_M.jpg"})
+	stale, err := storage.FetchStaleImages()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"photos/1/1_ab_L.jpg", "photos/1/1_ab_M.jpg"}, stale)
+	require.NoError(t, storage.AddStaleImages([]string{"photos/1/1_ab_L.jpg"}), "queued image may be added again")
+
+	deleted, err := CleanupImages(ctx, storage, im)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+	stale, err = storage.FetchStaleImages()
+	require.NoError(t, err)
+	assert.Len(t, stale, 2, "images failed to delete stay in queue")
+	assert.FileExists(t, path.Join(dir, "photos/1/1_ab_L.jpg"))
+
+	// avatars are never touched, even if missing in storage
+	avatars, err := storage.Count("SELECT COUNT(*) FROM user_images")
+	require.NoError(t, err)
+
+	im.broken = false
+	deleted, err = CleanupImages(ctx, storage, im)
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	stale, err = storage.FetchStaleImages()
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+	assert.NoFileExists(t, path.Join(dir, "photos/1/1_ab_L.jpg"))
+	assert.NoFileExists(t, path.Join(dir, "photos/1/1_ab_M.jpg"))
+	n, err := storage.Count("SELECT COUNT(*) FROM user_images")
+	require.NoError(t, err)
+	assert.Equal(t, avatars, n)
+}
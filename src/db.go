@@ -96,10 +96,206 @@ var migrations []Migration = []Migration{
 			`ALTER TABLE summit_images ADD COLUMN preview_url TEXT NOT NULL DEFAULT ''`,
 		},
 	},
+	{
+		"AddCatalogRevisions",
+		[]string{
+			`CREATE TABLE catalog_revisions (
+				id INTEGER PRIMARY KEY,
+				created_at TIMESTAMP NOT NULL,
+				changes TEXT NOT NULL
+			)`,
+		},
+	},
+	{
+		"AddClimbsArchived",
+		[]string{
+			`CREATE TABLE climbs_archived (
+				user_id INTEGER NOT NULL,
+				summit_id TEXT NOT NULL,
+				year INTEGER, month INTEGER, day INTEGER,
+				comment TEXT,
+				archived_at TIMESTAMP NOT NULL,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`CREATE INDEX climbs_archived_user_idx ON climbs_archived(user_id)`,
+		},
+	},
+	{
+		"AddSummitsSearch",
+		[]string{
+			`CREATE VIRTUAL TABLE summits_search USING fts4(
+				summit_id, name, name_alt, ridge, description, translit,
+				notindexed=summit_id, tokenize=unicode61
+			)`,
+		},
+	},
+	{
+		"MultipleClimbsPerSummit",
+		[]string{
+			`CREATE TABLE climbs_new (
+				id INTEGER PRIMARY KEY,
+				user_id INTEGER NOT NULL,
+				summit_id TEXT NOT NULL,
+				year INTEGER, month INTEGER, day INTEGER,
+				comment TEXT,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`INSERT INTO climbs_new (user_id, summit_id, year, month, day, comment)
+				SELECT user_id, summit_id, year, month, day, comment FROM climbs`,
+			`DROP TABLE climbs`,
+			`ALTER TABLE climbs_new RENAME TO climbs`,
+			`CREATE INDEX climbs_user_summit_idx ON climbs(user_id, summit_id)`,
+			`CREATE INDEX climbs_summit_idx ON climbs(summit_id)`,
+			// the first ascent of every summit by every user,
+			// for places where only distinct summits matter
+			`CREATE VIEW first_climbs AS
+				SELECT id, user_id, summit_id, year, month, day, comment FROM (
+					SELECT c.*, ROW_NUMBER() OVER (
+						PARTITION BY user_id, summit_id
+						ORDER BY year ASC NULLS LAST, month ASC NULLS LAST, day ASC NULLS LAST, id ASC
+					) AS n
+					FROM climbs c
+				) WHERE n = 1`,
+		},
+	},
+	{
+		"AddClimbTracks",
+		[]string{
+			`CREATE TABLE climb_tracks (
+				climb_id INTEGER PRIMARY KEY,
+				gpx BLOB NOT NULL,
+				verified INTEGER NOT NULL,
+				distance REAL NOT NULL,
+				uploaded_at TIMESTAMP NOT NULL,
+				FOREIGN KEY(climb_id) REFERENCES climbs(id) ON DELETE CASCADE
+			)`,
+		},
+	},
+	{
+		"AddSummitsGrid",
+		[]string{
+			`CREATE TABLE summits_grid (
+				summit_id TEXT NOT NULL PRIMARY KEY,
+				cell_lat INTEGER NOT NULL,
+				cell_lng INTEGER NOT NULL
+			)`,
+			`CREATE INDEX summits_grid_cell_idx ON summits_grid(cell_lat, cell_lng)`,
+		},
+	},
+	{
+		"AddAchievements",
+		[]string{
+			// summit_id and date are of the ascent which completed the rule,
+			// scope is ridge id or year for per-ridge and per-year rules
+			`CREATE TABLE achievements (
+				user_id INTEGER NOT NULL,
+				achievement_id TEXT NOT NULL,
+				scope TEXT NOT NULL,
+				summit_id TEXT NOT NULL,
+				year INTEGER, month INTEGER, day INTEGER,
+				awarded_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (user_id, achievement_id, scope),
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`CREATE INDEX achievements_summit_idx ON achievements(summit_id)`,
+		},
+	},
+	{
+		"AddClimbsTimestamps",
+		[]string{
+			// climbs registered before have no timestamps and stay out of the feed
+			`ALTER TABLE climbs ADD COLUMN created_at TIMESTAMP`,
+			`ALTER TABLE climbs ADD COLUMN updated_at TIMESTAMP`,
+			`CREATE INDEX climbs_updated_idx ON climbs(updated_at, id)`,
+		},
+	},
+	{
+		"AddFollows",
+		[]string{
+			`CREATE TABLE follows (
+				follower_id INTEGER NOT NULL,
+				followee_id INTEGER NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (follower_id, followee_id),
+				FOREIGN KEY(follower_id) REFERENCES users(id),
+				FOREIGN KEY(followee_id) REFERENCES users(id)
+			)`,
+			`CREATE INDEX follows_followee_idx ON follows(followee_id)`,
+		},
+	},
+	{
+		"AddClimbPartners",
+		[]string{
+			// partner_climb_id is the partner's own climb created on accepting,
+			// the partnership goes away with either of the climbs
+			`CREATE TABLE climb_partners (
+				climb_id INTEGER NOT NULL,
+				partner_id INTEGER NOT NULL,
+				status TEXT NOT NULL,
+				partner_climb_id INTEGER,
+				created_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (climb_id, partner_id),
+				FOREIGN KEY(climb_id) REFERENCES climbs(id) ON DELETE CASCADE,
+				FOREIGN KEY(partner_id) REFERENCES users(id),
+				FOREIGN KEY(partner_climb_id) REFERENCES climbs(id) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX climb_partners_partner_idx ON climb_partners(partner_id, status)`,
+			`CREATE INDEX climb_partners_partner_climb_idx ON climb_partners(partner_climb_id)`,
+		},
+	},
+	{
+		"AddClimbPhotos",
+		[]string{
+			// key is the photo location in image storage
+			`CREATE TABLE climb_photos (
+				id INTEGER PRIMARY KEY,
+				climb_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				key TEXT NOT NULL,
+				width INTEGER NOT NULL,
+				height INTEGER NOT NULL,
+				uploaded_at TIMESTAMP NOT NULL,
+				FOREIGN KEY(climb_id) REFERENCES climbs(id) ON DELETE CASCADE,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`CREATE INDEX climb_photos_climb_idx ON climb_photos(climb_id)`,
+		},
+	},
+	{
+		// FTS5 ranks with bm25 and marks matches for snippets,
+		// the index is filled on loading summits
+		"SummitsSearchFts5",
+		[]string{
+			`DROP TABLE summits_search`,
+			`CREATE VIRTUAL TABLE summits_search USING fts5(
+				summit_id UNINDEXED, name, name_alt, ridge, description, translit,
+				tokenize=unicode61
+			)`,
+		},
+	},
+	{
+		"AddStaleImages",
+		[]string{
+			// images not referenced anymore, which failed to be deleted from storage
+			`CREATE TABLE stale_images (
+				key TEXT PRIMARY KEY,
+				created_at TIMESTAMP NOT NULL
+			)`,
+		},
+	},
+	{
+		// season badges are reevaluated per year on every climb change
+		"AddClimbsDateIndex",
+		[]string{
+			`CREATE INDEX climbs_date_idx ON climbs(year, month, day)`,
+		},
+	},
 }
 
 func NewDatabase(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=1", path))
+	// transactions take the write lock at once, so the ones reading before
+	// writing wait for each other instead of failing as locked
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=1&_txlock=immediate", path))
 	if err != nil {
 		return nil, err
 	}
@@ -108,6 +304,18 @@ func NewDatabase(path string) (*sql.DB, error) {
 	return db, nil
 }
 
+// NewMemoryDatabase creates a throwaway in-memory database.
+// Every connection gets its own memory database in sqlite,
+// so the pool is limited to a single connection.
+func NewMemoryDatabase() (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", "file::memory:?_foreign_keys=1")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
 func Migrate(db *sql.DB) error {
 
 	var err, rollbackErr error
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FeedEventCreated = "created"
+	FeedEventUpdated = "updated"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+type FeedEntry struct {
+	ClimbId    int64       `json:"climb_id"`
+	Event      string      `json:"event"`
+	UserId     int64       `json:"user_id"`
+	UserName   string      `json:"user_name"`
+	UserImage  string      `json:"user_image"`
+	SummitId   string      `json:"summit_id"`
+	SummitName *string     `json:"summit_name"`
+	Height     int         `json:"height"`
+	RidgeId    string      `json:"ridge_id"`
+	RidgeName  string      `json:"ridge"`
+	Date       InexactDate `json:"date"`
+	Comment    string      `json:"comment"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+type Feed struct {
+	Items []FeedEntry `json:"items"`
+	// NextCursor is set if there are more entries
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// FeedQuery holds feed filters and position. Empty filters mean the whole community.
+type FeedQuery struct {
+	RidgeId  string
+	SummitId string
+	UserIds  []int64
+	// FollowedBy limits the feed to users followed by the given one
+	FollowedBy int64
+	// Dated includes climbs registered before timestamps were introduced,
+	// they are timed by the climb date. Not suitable for cursor pagination.
+	Dated  bool
+	Cursor string
+	Limit  int
+}
+
+// FeedCursor points to the last entry of the previous page.
+// Entries are ordered by the last change time and climb id, descending.
+type FeedCursor struct {
+	UpdatedAt time.Time
+	ClimbId   int64
+}
+
+func (c FeedCursor) String() string {
+	raw := c.UpdatedAt.UTC().Format(time.RFC3339Nano) + "," + strconv.FormatInt(c.ClimbId, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func ParseFeedCursor(s string) (*FeedCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	ts, id, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return nil, errInvalidCursor
+	}
+	var c FeedCursor
+	if c.UpdatedAt, err = time.Parse(time.RFC3339Nano, ts); err != nil {
+		return nil, errInvalidCursor
+	}
+	if c.ClimbId, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
+
+// FetchFeed returns recently registered and edited climbs, the latest first
+func (s *Storage) FetchFeed(q FeedQuery) (*Feed, error) {
+	conds := []string{"c.updated_at IS NOT NULL"}
+	orderBy := "c.updated_at"
+	if q.Dated {
+		conds[0] = "(c.updated_at IS NOT NULL OR c.year IS NOT NULL)"
+		// timestamps are stored as text starting with the date, so they compare to it
+		orderBy = "COALESCE(c.updated_at, printf('%04d-%02d-%02d', c.year, COALESCE(c.month, 1), COALESCE(c.day, 1)))"
+	}
+	params := make([]any, 0)
+	if q.RidgeId != "" {
+		conds = append(conds, "s.ridge_id = ?")
+		params = append(params, q.RidgeId)
+	}
+	if q.SummitId != "" {
+		conds = append(conds, "c.summit_id = ?")
+		params = append(params, q.SummitId)
+	}
+	if q.UserIds != nil {
+		// filtering by an empty list of users gives an empty feed
+		placeholders := make([]string, len(q.UserIds))
+		for i, id := range q.UserIds {
+			placeholders[i] = "?"
+			params = append(params, id)
+		}
+		conds = append(conds, fmt.Sprintf("c.user_id IN (%s)", strings.Join(placeholders, ", ")))
+	}
+	if q.FollowedBy != 0 {
+		conds = append(conds, "c.user_id IN (SELECT followee_id FROM follows WHERE follower_id = ?)")
+		params = append(params, q.FollowedBy)
+	}
+	if q.Cursor != "" {
+		cursor, err := ParseFeedCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, "(c.updated_at < ? OR (c.updated_at = ? AND c.id < ?))")
+		params = append(params, cursor.UpdatedAt, cursor.UpdatedAt, cursor.ClimbId)
+	}
+	// one extra entry tells whether there is the next page
+	params = append(params, q.Limit+1)
+
+	rows, err := s.db.Query(`SELECT c.id, c.user_id, u.name, ui.url, c.summit_id, s.name, s.height,
+			r.id, r.name, c.year, c.month, c.day, c.comment, c.created_at, c.updated_at
+		FROM climbs c
+			INNER JOIN users u ON u.id = c.user_id
+			INNER JOIN summits s ON s.id = c.summit_id
+			INNER JOIN ridges r ON r.id = s.ridge_id
+			LEFT JOIN user_images ui ON ui.user_id = u.id AND ui.size = 'S'
+		WHERE `+strings.Join(conds, " AND ")+`
+		ORDER BY `+orderBy+` DESC, c.id DESC
+		LIMIT ?`, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	feed := &Feed{Items: make([]FeedEntry, 0, q.Limit)}
+	for rows.Next() {
+		var e FeedEntry
+		var image, comment sql.NullString
+		var year, month, day sql.NullInt64
+		var createdAt, updatedAt sql.NullTime
+		err := rows.Scan(&e.ClimbId, &e.UserId, &e.UserName, &image, &e.SummitId, &e.SummitName, &e.Height,
+			&e.RidgeId, &e.RidgeName, &year, &month, &day, &comment, &createdAt, &updatedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.UserImage, e.Comment = image.String, comment.String
+		e.Date.FromSQL(year, month, day)
+		e.CreatedAt, e.UpdatedAt = createdAt.Time, updatedAt.Time
+		// climbs registered before timestamps are timed by their date
+		if !createdAt.Valid {
+			e.CreatedAt = e.UpdatedAt
+			if e.Date.Year != 0 {
+				e.CreatedAt = climbDateTime(e.Date)
+			}
+		}
+		if !updatedAt.Valid {
+			e.UpdatedAt = e.CreatedAt
+		}
+		e.Event = FeedEventCreated
+		if e.UpdatedAt.After(e.CreatedAt) {
+			e.Event = FeedEventUpdated
+		}
+		feed.Items = append(feed.Items, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(feed.Items) > q.Limit {
+		feed.Items = feed.Items[:q.Limit]
+		last := feed.Items[q.Limit-1]
+		feed.NextCursor = FeedCursor{last.UpdatedAt, last.ClimbId}.String()
+	}
+	return feed, nil
+}
+
+// climbDateTime returns the start of the climb date for climbs without timestamps
+func climbDateTime(date InexactDate) time.Time {
+	return time.Date(int(date.Year), time.Month(max(date.Month, 1)), int(max(date.Day, 1)), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedCursor(t *testing.T) {
+	c := FeedCursor{time.Date(2024, 5, 12, 10, 30, 0, 123, time.UTC), 42}
+	parsed, err := ParseFeedCursor(c.String())
+	require.NoError(t, err)
+	assert.True(t, c.UpdatedAt.Equal(parsed.UpdatedAt))
+	assert.Equal(t, c.ClimbId, parsed.ClimbId)
+
+	for _, invalid := range []string{"", "!!!", "bm9jb21tYQ", "eCwx", "MjAyNC0wNS0xMlQxMDozMDowMFoseA"} {
+		_, err := ParseFeedCursor(invalid)
+		assert.ErrorIs(t, err, errInvalidCursor, invalid)
+	}
+}
+
+func TestFetchFeed(t *testing.T) {
+	storage := NewStorage(MockDatabase(t))
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+
+	// climbs from mock data have no timestamps and stay out of the feed
+	feed, err := storage.FetchFeed(FeedQuery{Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, feed.Items)
+	assert.Empty(t, feed.NextCursor)
+
+	kirel, err := storage.AddAscent("kirel", 6, InexactDate{2020, 7, 1}, "first", nil)
+	require.NoError(t, err)
+	stolby, err := storage.AddAscent("stolby", 7, InexactDate{2021, 0, 0}, "", nil)
+	require.NoError(t, err)
+	kurkak, err := storage.AddAscent("kurkak", 6, InexactDate{}, "", nil)
+	require.NoError(t, err)
+
+	// unchanged ascent keeps its place in the feed
+	ok, err := storage.UpdateAscent(stolby, 7, InexactDate{2021, 0, 0}, "", nil)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = storage.UpdateAscent(kirel, 6, InexactDate{2020, 7, 1}, "edited", nil)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	feed, err = storage.FetchFeed(FeedQuery{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, feed.Items, 3)
+	assert.Empty(t, feed.NextCursor)
+
+	e := feed.Items[0]
+	assert.Equal(t, kirel, e.ClimbId)
+	assert.Equal(t, FeedEventUpdated, e.Event)
+	assert.Equal(t, int64(6), e.UserId)
+	assert.Equal(t, "Curtis Allen", e.UserName)
+	assert.Equal(t, "users/6_S.jpg", e.UserImage)
+	assert.Equal(t, "kirel", e.SummitId)
+	assert.Equal(t, "malidak", e.RidgeId)
+	assert.Equal(t, InexactDate{2020, 7, 1}, e.Date)
+	assert.Equal(t, "edited", e.Comment)
+	assert.True(t, e.UpdatedAt.After(e.CreatedAt))
+
+	assert.Equal(t, kurkak, feed.Items[1].ClimbId)
+	assert.Equal(t, FeedEventCreated, feed.Items[1].Event)
+	assert.Equal(t, stolby, feed.Items[2].ClimbId)
+	assert.Equal(t, FeedEventCreated, feed.Items[2].Event)
+
+	t.Run("pagination", func(t *testing.T) {
+		ids := make([]int64, 0)
+		q := FeedQuery{Limit: 2}
+		for {
+			page, err := storage.FetchFeed(q)
+			require.NoError(t, err)
+			for _, e := range page.Items {
+				ids = append(ids, e.ClimbId)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+		assert.Equal(t, []int64{kirel, kurkak, stolby}, ids)
+	})
+
+	t.Run("by ridge", func(t *testing.T) {
+		feed, err := storage.FetchFeed(FeedQuery{RidgeId: "stolby", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, feed.Items, 1)
+		assert.Equal(t, stolby, feed.Items[0].ClimbId)
+	})
+
+	t.Run("by users", func(t *testing.T) {
+		feed, err := storage.FetchFeed(FeedQuery{UserIds: []int64{6, 5}, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, feed.Items, 2)
+		assert.Equal(t, kirel, feed.Items[0].ClimbId)
+		assert.Equal(t, kurkak, feed.Items[1].ClimbId)
+
+		feed, err = storage.FetchFeed(FeedQuery{UserIds: []int64{}, Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, feed.Items)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		_, err := storage.FetchFeed(FeedQuery{Cursor: "garbage", Limit: 10})
+		assert.ErrorIs(t, err, errInvalidCursor)
+	})
+}
+
+func TestFetchFeedEditedLegacyClimb(t *testing.T) {
+	storage := NewStorage(MockDatabase(t))
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+
+	// mock climb 1 was registered before timestamps
+	ok, err := storage.UpdateAscent(1, 1, InexactDate{1990, 9, 6}, "edited", nil)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	for _, q := range []FeedQuery{{Limit: 10}, {UserIds: []int64{1}, Dated: true, Limit: 100}} {
+		feed, err := storage.FetchFeed(q)
+		require.NoError(t, err)
+		require.NotEmpty(t, feed.Items)
+		e := feed.Items[0]
+		assert.Equal(t, int64(1), e.ClimbId)
+		assert.Equal(t, FeedEventUpdated, e.Event)
+		assert.Equal(t, time.Date(1990, 9, 6, 0, 0, 0, 0, time.UTC), e.CreatedAt.UTC())
+		assert.True(t, e.UpdatedAt.After(e.CreatedAt))
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var errFollowSelf = errors.New("users can not follow themselves")
+
+type FollowUser struct {
+	Id    int64  `json:"id"`
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	// Since is when the following started
+	Since time.Time `json:"since"`
+}
+
+// Follow makes follower receive followee's climbs in the timeline.
+// Following the same user again changes nothing.
+func (s *Storage) Follow(followerId, followeeId int64) error {
+	if followerId == followeeId {
+		return errFollowSelf
+	}
+	_, err := s.db.Exec(`INSERT INTO follows (follower_id, followee_id, created_at) VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING`, followerId, followeeId, time.Now().UTC())
+	return err
+}
+
+func (s *Storage) Unfollow(followerId, followeeId int64) error {
+	_, err := s.db.Exec(`DELETE FROM follows WHERE follower_id = ? AND followee_id = ?`, followerId, followeeId)
+	return err
+}
+
+func (s *Storage) fetchFollowUsers(query string, userId int64) ([]FollowUser, error) {
+	rows, err := s.db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := make([]FollowUser, 0)
+	for rows.Next() {
+		var u FollowUser
+		var image sql.NullString
+		if err := rows.Scan(&u.Id, &u.Name, &image, &u.Since); err != nil {
+			return nil, err
+		}
+		u.Image = image.String
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// FetchFollowing returns users followed by the user, the latest followed first
+func (s *Storage) FetchFollowing(userId int64) ([]FollowUser, error) {
+	return s.fetchFollowUsers(`SELECT u.id, u.name, ui.url, f.created_at
+		FROM follows f
+			INNER JOIN users u ON u.id = f.followee_id
+			LEFT JOIN user_images ui ON ui.user_id = u.id AND ui.size = 'S'
+		WHERE f.follower_id = ?
+		ORDER BY f.created_at DESC, u.id ASC`, userId)
+}
+
+// FetchFollowers returns users following the user, the latest first
+func (s *Storage) FetchFollowers(userId int64) ([]FollowUser, error) {
+	return s.fetchFollowUsers(`SELECT u.id, u.name, ui.url, f.created_at
+		FROM follows f
+			INNER JOIN users u ON u.id = f.follower_id
+			LEFT JOIN user_images ui ON ui.user_id = u.id AND ui.size = 'S'
+		WHERE f.followee_id = ?
+		ORDER BY f.created_at DESC, u.id ASC`, userId)
+}
+
+// CountFollowedClimbers returns how many users followed by the user climbed the summit
+func (s *Storage) CountFollowedClimbers(userId int64, summitId string) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(DISTINCT c.user_id)
+		FROM climbs c INNER JOIN follows f ON f.followee_id = c.user_id
+		WHERE f.follower_id = ? AND c.summit_id = ?`, userId, summitId).Scan(&n)
+	return n, err
+}
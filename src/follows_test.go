@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func followIds(users []FollowUser) []int64 {
+	ids := make([]int64, len(users))
+	for i, u := range users {
+		ids[i] = u.Id
+	}
+	return ids
+}
+
+func TestFollows(t *testing.T) {
+	storage := NewStorage(MockDatabase(t))
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+
+	require.NoError(t, storage.Follow(5, 6))
+	require.NoError(t, storage.Follow(5, 7))
+	// following again is not an error
+	require.NoError(t, storage.Follow(5, 6))
+	require.NoError(t, storage.Follow(7, 5))
+	assert.ErrorIs(t, storage.Follow(5, 5), errFollowSelf)
+
+	following, err := storage.FetchFollowing(5)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{7, 6}, followIds(following))
+	assert.Equal(t, "Todd Bowen", following[0].Name)
+	assert.Equal(t, "users/7_S.jpg", following[0].Image)
+	assert.False(t, following[0].Since.IsZero())
+
+	followers, err := storage.FetchFollowers(5)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{7}, followIds(followers))
+	followers, err = storage.FetchFollowers(6)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{5}, followIds(followers))
+
+	// 5 itself and 7 climbed kirel, only followed users count
+	n, err := storage.CountFollowedClimbers(5, "kirel")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = storage.CountFollowedClimbers(5, "stolby")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = storage.CountFollowedClimbers(5, "1021")
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	_, err = storage.AddAscent("kirel", 6, InexactDate{2024, 6, 1}, "", nil)
+	require.NoError(t, err)
+	_, err = storage.AddAscent("kirel", 7, InexactDate{2024, 6, 2}, "", nil)
+	require.NoError(t, err)
+	n, err = storage.CountFollowedClimbers(5, "kirel")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "repeated ascents count once")
+
+	feed, err := storage.FetchFeed(FeedQuery{FollowedBy: 5, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, feed.Items, 2)
+	assert.Equal(t, int64(7), feed.Items[0].UserId)
+	assert.Equal(t, int64(6), feed.Items[1].UserId)
+
+	require.NoError(t, storage.Unfollow(5, 6))
+	require.NoError(t, storage.Unfollow(5, 6))
+	following, err = storage.FetchFollowing(5)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{7}, followIds(following))
+	feed, err = storage.FetchFeed(FeedQuery{FollowedBy: 5, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, feed.Items, 1)
+	assert.Equal(t, int64(7), feed.Items[0].UserId)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+const (
+	geoExportTitle = "Тысячники Южного Урала"
+	kmlIcon        = "http://maps.google.com/mapfiles/kml/paddle/wht-blank.png"
+	kmlClimbedIcon = "http://maps.google.com/mapfiles/kml/paddle/wht-stars.png"
+)
+
+var ridgeColorRe = regexp.MustCompile(`^[0-9a-fA-F]{6}$`)
+
+// exactFloat64 converts float32 coordinate to float64 keeping its
+// shortest decimal representation, i.e. 54.0286 instead of 54.02859878540039
+func exactFloat64(f float32) float64 {
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64)
+	return v
+}
+
+// summitTitle returns summit name, falling back to height for unnamed summits
+func summitTitle(s SummitsTableItem) string {
+	if s.Name != nil && *s.Name != "" {
+		return *s.Name
+	}
+	return fmt.Sprint(s.Height)
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPlacemark struct {
+	Name         string    `xml:"name"`
+	Description  string    `xml:"description"`
+	StyleUrl     string    `xml:"styleUrl"`
+	ExtendedData []kmlData `xml:"ExtendedData>Data"`
+	Coordinates  string    `xml:"Point>coordinates"`
+}
+
+type kmlFolder struct {
+	Name       string         `xml:"name"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlStyle struct {
+	Id        string  `xml:"id,attr"`
+	IconColor string  `xml:"IconStyle>color"`
+	IconHref  string  `xml:"IconStyle>Icon>href"`
+	LabelSize float64 `xml:"LabelStyle>scale"`
+}
+
+type kmlDocument struct {
+	XMLName xml.Name    `xml:"kml"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Name    string      `xml:"Document>name"`
+	Styles  []kmlStyle  `xml:"Document>Style"`
+	Folders []kmlFolder `xml:"Document>Folder"`
+}
+
+// kmlColor converts RRGGBB ridge color to KML aabbggrr notation
+func kmlColor(color string) string {
+	if !ridgeColorRe.MatchString(color) {
+		return "ffffffff"
+	}
+	return "ff" + color[4:6] + color[2:4] + color[0:2]
+}
+
+// SummitsKML renders summits as KML document with a folder and an icon
+// style per ridge. Climbed summits get a distinct icon if withClimbed is set.
+func SummitsKML(title string, summits []SummitsTableItem, withClimbed bool) ([]byte, error) {
+	doc := kmlDocument{Xmlns: "http://www.opengis.net/kml/2.2", Name: title}
+	folders := make(map[string]int)
+	for _, s := range summits {
+		styleId := "ridge-" + s.RidgeId
+		idx, ok := folders[s.RidgeId]
+		if !ok {
+			idx = len(doc.Folders)
+			folders[s.RidgeId] = idx
+			doc.Folders = append(doc.Folders, kmlFolder{Name: s.RidgeName})
+			doc.Styles = append(doc.Styles, kmlStyle{styleId, kmlColor(s.Color), kmlIcon, 0.8})
+			if withClimbed {
+				doc.Styles = append(doc.Styles, kmlStyle{styleId + "-climbed", kmlColor(s.Color), kmlClimbedIcon, 0.8})
+			}
+		}
+
+		description := fmt.Sprintf("хр. %s, %d м", s.RidgeName, s.Height)
+		if s.Prominence > 0 {
+			description += fmt.Sprintf(", относительная высота %d м", s.Prominence)
+		}
+		description += fmt.Sprintf(", восходителей: %d", s.Visitors)
+		data := []kmlData{
+			{"id", s.Id},
+			{"height", fmt.Sprint(s.Height)},
+			{"prominence", fmt.Sprint(s.Prominence)},
+			{"visitors", fmt.Sprint(s.Visitors)},
+		}
+		if withClimbed {
+			data = append(data, kmlData{"climbed", fmt.Sprint(s.Climbed)})
+			if s.Climbed {
+				styleId += "-climbed"
+				description += ", покорена"
+			}
+		}
+		doc.Folders[idx].Placemarks = append(doc.Folders[idx].Placemarks, kmlPlacemark{
+			Name:         summitTitle(s),
+			Description:  description,
+			StyleUrl:     "#" + styleId,
+			ExtendedData: data,
+			Coordinates:  fmt.Sprintf("%v,%v,%d", s.Lng, s.Lat, s.Height),
+		})
+	}
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// SummitsGPX renders summits as GPX waypoints
+func SummitsGPX(summits []SummitsTableItem) ([]byte, error) {
+	gpxData := gpx.GPX{
+		Version: "1.1",
+		Creator: geoExportTitle,
+	}
+	for _, summit := range summits {
+		waypoint := gpx.GPXPoint{
+			Point: gpx.Point{
+				Latitude:  float64(summit.Lat),
+				Longitude: float64(summit.Lng),
+				Elevation: *gpx.NewNullableFloat64(float64(summit.Height)),
+			},
+			Name:        summitTitle(summit),
+			Description: fmt.Sprintf("хр. %s", summit.RidgeName),
+		}
+		gpxData.AppendWaypoint(&waypoint)
+	}
+	return gpxData.ToXml(gpx.ToXmlParams{Version: "1.1", Indent: true})
+}
+
+type geoJSONGeometry struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Id         string          `json:"id"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// SummitsGeoJSON renders summits as GeoJSON feature collection.
+// Climbed flag is included only if withClimbed is set.
+func SummitsGeoJSON(summits []SummitsTableItem, withClimbed bool) ([]byte, error) {
+	fc := geoJSONFeatureCollection{Type: "FeatureCollection", Features: make([]geoJSONFeature, 0, len(summits))}
+	for _, s := range summits {
+		props := map[string]any{
+			"name":       summitTitle(s),
+			"height":     s.Height,
+			"prominence": s.Prominence,
+			"ridge":      s.RidgeName,
+			"ridge_id":   s.RidgeId,
+			"visitors":   s.Visitors,
+		}
+		// simplestyle property understood by many viewers
+		if s.Color != "" {
+			props["marker-color"] = "#" + s.Color
+		}
+		if withClimbed {
+			props["climbed"] = s.Climbed
+		}
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type: "Feature",
+			Id:   s.Id,
+			Geometry: geoJSONGeometry{
+				Type:        "Point",
+				Coordinates: []float64{exactFloat64(s.Lng), exactFloat64(s.Lat), float64(s.Height)},
+			},
+			Properties: props,
+		})
+	}
+	return json.Marshal(fc)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummitsGeoJSONColor(t *testing.T) {
+	summits := []SummitsTableItem{
+		{Id: "kirel", Height: 1162, RidgeId: "malidak", Color: "7a8a79"},
+		{Id: "1021", Height: 1021, RidgeId: "stolby"},
+	}
+	data, err := SummitsGeoJSON(summits, false)
+	require.NoError(t, err)
+	var fc geoJSONFeatureCollection
+	require.NoError(t, json.Unmarshal(data, &fc))
+	require.Len(t, fc.Features, 2)
+	assert.Equal(t, "#7a8a79", fc.Features[0].Properties["marker-color"])
+	// ridge without color leaves the choice to the viewer
+	assert.NotContains(t, fc.Features[1].Properties, "marker-color")
+	assert.Equal(t, "1021", fc.Features[1].Properties["name"])
+}
@@ -3,50 +3,116 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 const (
-	S3_ENDPOINT = "https://s3.timeweb.cloud"
-	S3_BUCKET   = "302f9aa7-62c4d4d3-ccfd-4077-86c8-cca52e0da376"
+	DefaultS3Endpoint = "https://s3.timeweb.cloud"
+	DefaultS3Region   = "ru-1-ru"
+	DefaultS3Bucket   = "302f9aa7-62c4d4d3-ccfd-4077-86c8-cca52e0da376"
+	// localImagesPath is where the app serves images of local storage
+	localImagesPath = "/images/"
+	// signedImageExpires is lifetime of signed image urls,
+	// redirects to them are cached for half of it
+	signedImageExpires = time.Hour
 )
 
+var errImageNotFound = errors.New("image not found")
+
 type ImageManager interface {
+	// Upload stores the image, its content type is detected from the data
 	Upload(ctx context.Context, imageData []byte, key string) error
+	// Delete removes the image, deleting missing one is not an error
+	Delete(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	// Get returns the image data or errImageNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+	// URL returns public url of the image
+	URL(key string) string
+	// SignedURL returns url granting access to the image for a limited
+	// time, it works for storage which is not public
+	SignedURL(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// imageContentType detects content type of stored image from its data
+func imageContentType(data []byte) string {
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+func escapeKey(key string) string {
+	return (&url.URL{Path: key}).EscapedPath()
+}
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PublicUrl is where objects of the bucket are available to everyone,
+	// Endpoint/Bucket/ if empty
+	PublicUrl string
 }
 
 type S3ImageManager struct {
-	s3Client *s3.Client
+	s3Client  *s3.Client
+	bucket    string
+	publicUrl string
 }
 
-func NewS3ImageManager(accessKey, secretKey, endpoint string, ctx context.Context) (*S3ImageManager, error) {
+func NewS3ImageManager(ctx context.Context, conf S3Config) (*S3ImageManager, error) {
 	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(conf.AccessKey, conf.SecretKey, "")),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %v", err)
 	}
 
 	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String(endpoint)
+		o.BaseEndpoint = aws.String(conf.Endpoint)
 		o.UsePathStyle = true
-		o.Region = "ru-1-ru"
+		o.Region = conf.Region
 	})
 
-	return &S3ImageManager{s3Client: s3Client}, nil
+	publicUrl := conf.PublicUrl
+	if publicUrl == "" {
+		publicUrl = strings.TrimSuffix(conf.Endpoint, "/") + "/" + conf.Bucket + "/"
+	}
+	return &S3ImageManager{s3Client: s3Client, bucket: conf.Bucket, publicUrl: publicUrl}, nil
+}
+
+func isS3NotFound(err error) bool {
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound
 }
 
 func (im *S3ImageManager) Upload(ctx context.Context, imageData []byte, key string) error {
 	_, err := im.s3Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(S3_BUCKET),
+		Bucket:      aws.String(im.bucket),
 		Key:         aws.String(key),
 		Body:        bytes.NewReader(imageData),
-		ContentType: aws.String("image/jpeg"),
+		ContentType: aws.String(imageContentType(imageData)),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to upload image to S3: %v", err)
@@ -54,3 +120,234 @@ func (im *S3ImageManager) Upload(ctx context.Context, imageData []byte, key stri
 
 	return nil
 }
+
+func (im *S3ImageManager) Delete(ctx context.Context, key string) error {
+	_, err := im.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(im.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete image from S3: %v", err)
+	}
+
+	return nil
+}
+
+func (im *S3ImageManager) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := im.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(im.bucket),
+		Key:    aws.String(key),
+	})
+	if isS3NotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check image in S3: %v", err)
+	}
+	return true, nil
+}
+
+func (im *S3ImageManager) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := im.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(im.bucket),
+		Key:    aws.String(key),
+	})
+	if isS3NotFound(err) {
+		return nil, errImageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image from S3: %v", err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image from S3: %v", err)
+	}
+	return data, nil
+}
+
+func (im *S3ImageManager) URL(key string) string {
+	return im.publicUrl + escapeKey(key)
+}
+
+func (im *S3ImageManager) SignedURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(im.s3Client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(im.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign image url: %v", err)
+	}
+	return req.URL, nil
+}
+
+// LocalImageManager keeps images in a directory of local filesystem,
+// they are served by Handler
+type LocalImageManager struct {
+	dir string
+}
+
+func NewLocalImageManager(dir string) (*LocalImageManager, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create images directory: %v", err)
+	}
+	return &LocalImageManager{dir: dir}, nil
+}
+
+func (im *LocalImageManager) path(key string) (string, error) {
+	p := filepath.FromSlash(key)
+	if !filepath.IsLocal(p) {
+		return "", fmt.Errorf("invalid image key %s", key)
+	}
+	return filepath.Join(im.dir, p), nil
+}
+
+func (im *LocalImageManager) Upload(ctx context.Context, imageData []byte, key string) error {
+	p, err := im.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for image: %v", err)
+	}
+	// written to temporary file first, so that readers never see partial image
+	f, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to store image: %v", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(imageData)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store image: %v", err)
+	}
+	return nil
+}
+
+func (im *LocalImageManager) Delete(ctx context.Context, key string) error {
+	p, err := im.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete image: %v", err)
+	}
+	return nil
+}
+
+func (im *LocalImageManager) Exists(ctx context.Context, key string) (bool, error) {
+	p, err := im.path(key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (im *LocalImageManager) Get(ctx context.Context, key string) ([]byte, error) {
+	p, err := im.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errImageNotFound
+	}
+	return data, err
+}
+
+func (im *LocalImageManager) URL(key string) string {
+	return localImagesPath + escapeKey(key)
+}
+
+// SignedURL returns plain url, local images are served to everyone
+func (im *LocalImageManager) SignedURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return im.URL(key), nil
+}
+
+// imagesFS hides directories and temporary files, so that
+// file server does not list images
+type imagesFS struct {
+	http.FileSystem
+}
+
+func (ifs imagesFS) Open(name string) (http.File, error) {
+	if strings.HasPrefix(path.Base(name), ".") {
+		return nil, fs.ErrNotExist
+	}
+	f, err := ifs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if stat, err := f.Stat(); err != nil || stat.IsDir() {
+		f.Close()
+		return nil, fs.ErrNotExist
+	}
+	return f, nil
+}
+
+// Handler serves stored images by their keys. Avatars are replaced
+// under the same key, so images are cached for a day and revalidated
+// with Last-Modified afterwards.
+func (im *LocalImageManager) Handler() http.Handler {
+	fileServer := http.FileServer(imagesFS{http.Dir(im.dir)})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// imageKeyPrefixes are where the app stores images, other objects
+// of the bucket are not given out
+var imageKeyPrefixes = []string{"users/", "photos/", "summits/"}
+
+func isImageKey(key string) bool {
+	if path.Clean(key) != key {
+		return false
+	}
+	for _, prefix := range imageKeyPrefixes {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ImagesHandler redirects to images kept in remote storage, so that
+// clients can load images from the app whatever storage is used.
+// Signed urls are given out if the storage is not public.
+func ImagesHandler(im ImageManager, signed bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		if !isImageKey(key) {
+			http.NotFound(w, r)
+			return
+		}
+		target, maxAge := im.URL(key), 86400
+		if signed {
+			var err error
+			target, err = im.SignedURL(r.Context(), key, signedImageExpires)
+			if err != nil {
+				slog.Error("Failed to sign image url", "key", key, "error", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			maxAge = int(signedImageExpires.Seconds()) / 2
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+		http.Redirect(w, r, target, http.StatusFound)
+	})
+}
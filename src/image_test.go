@@ -3,49 +3,57 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/alexedwards/scs/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+const testS3Bucket = "test-bucket"
+
 // MockS3Service simulates S3 behavior for testing
 type MockS3Service struct {
-	objects map[string][]byte
+	objects      map[string][]byte
+	contentTypes map[string]string
 }
 
 func NewMockS3Service() *MockS3Service {
 	return &MockS3Service{
-		objects: make(map[string][]byte),
+		objects:      make(map[string][]byte),
+		contentTypes: make(map[string]string),
 	}
 }
 
+// objectKey extracts key from path-style url /{bucket}/{key}
+func objectKey(r *http.Request) string {
+	return strings.TrimPrefix(r.URL.Path, "/"+testS3Bucket+"/")
+}
+
 func (m *MockS3Service) HandlePutObject(w http.ResponseWriter, r *http.Request) {
-	// Extract bucket and key from URL path
-	// Expected format: /{bucket}/{key}
-	path := r.URL.Path[1:] // Remove leading slash
-	if path == "" {
+	key := objectKey(r)
+	if key == "" {
 		http.Error(w, "Missing bucket and key", http.StatusBadRequest)
 		return
 	}
 
-	// For simplicity, assume bucket is always the first part and key is the rest
-	key := path[len(S3_BUCKET)+1:] // Remove bucket name + slash
-	if key[0] == '/' {
-		key = key[1:] // Remove leading slash from key
-	}
-
-	// Read the body
-	body := make([]byte, r.ContentLength)
-	_, err := r.Body.Read(body)
-	if err != nil && err.Error() != "EOF" {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
 		http.Error(w, "Failed to read body", http.StatusBadRequest)
 		return
 	}
 
 	// Store the object
 	m.objects[key] = body
+	m.contentTypes[key] = r.Header.Get("Content-Type")
 
 	// Return success response
 	w.WriteHeader(http.StatusOK)
@@ -53,12 +61,7 @@ func (m *MockS3Service) HandlePutObject(w http.ResponseWriter, r *http.Request)
 }
 
 func (m *MockS3Service) HandleGetObject(w http.ResponseWriter, r *http.Request) {
-	// Extract key from URL path
-	path := r.URL.Path[1:]         // Remove leading slash
-	key := path[len(S3_BUCKET)+1:] // Remove bucket name + slash
-	if key[0] == '/' {
-		key = key[1:] // Remove leading slash from key
-	}
+	key := objectKey(r)
 
 	// Check if object exists
 	object, exists := m.objects[key]
@@ -68,15 +71,25 @@ func (m *MockS3Service) HandleGetObject(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Return the object
-	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Type", m.contentTypes[key])
+	w.Header().Set("Content-Length", strconv.Itoa(len(object)))
+	if r.Method == "HEAD" {
+		return
+	}
 	w.Write(object)
 }
 
+func (m *MockS3Service) HandleDeleteObject(w http.ResponseWriter, r *http.Request) {
+	// S3 responds the same way whether the object existed or not
+	delete(m.objects, objectKey(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (m *MockS3Service) HandleListObjects(w http.ResponseWriter, r *http.Request) {
 	// Return a simple XML response for list objects
 	response := `<?xml version="1.0" encoding="UTF-8"?>
 <ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
-	<Name>` + S3_BUCKET + `</Name>
+	<Name>` + testS3Bucket + `</Name>
 	<Prefix></Prefix>
 	<Marker></Marker>
 	<MaxKeys>1000</MaxKeys>
@@ -91,6 +104,10 @@ func (m *MockS3Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "PUT":
 		m.HandlePutObject(w, r)
+	case "DELETE":
+		m.HandleDeleteObject(w, r)
+	case "HEAD":
+		m.HandleGetObject(w, r)
 	case "GET":
 		if r.URL.Query().Get("list-type") == "2" {
 			m.HandleListObjects(w, r)
@@ -108,6 +125,18 @@ func (m *MockS3Service) GetObjectStored(key string) ([]byte, bool) {
 	return data, exists
 }
 
+func newTestS3ImageManager(t *testing.T, endpoint string) *S3ImageManager {
+	manager, err := NewS3ImageManager(context.Background(), S3Config{
+		Endpoint:  endpoint,
+		Region:    DefaultS3Region,
+		Bucket:    testS3Bucket,
+		AccessKey: "test-access-key",
+		SecretKey: "test-secret-key",
+	})
+	require.NoError(t, err)
+	return manager
+}
+
 func TestS3ImageManagerUpload(t *testing.T) {
 	// Create mock S3 server
 	mockS3 := NewMockS3Service()
@@ -145,12 +174,10 @@ func TestS3ImageManagerUpload(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Create S3ImageManager with mock endpoint
-			manager, err := NewS3ImageManager("test-access-key", "test-secret-key", server.URL, ctx)
-			assert.NoError(t, err)
-			assert.NotNil(t, manager)
+			manager := newTestS3ImageManager(t, server.URL)
 
 			// Test upload
-			err = manager.Upload(ctx, tt.imageData, tt.key)
+			err := manager.Upload(ctx, tt.imageData, tt.key)
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -175,15 +202,182 @@ func TestS3ImageManagerUploadWithContextCancellation(t *testing.T) {
 	ctx := context.Background()
 
 	// Create S3ImageManager with mock endpoint
-	manager, err := NewS3ImageManager("test-access-key", "test-secret-key", server.URL, ctx)
-	assert.NoError(t, err)
+	manager := newTestS3ImageManager(t, server.URL)
 
 	// Create a cancelled context
 	cancelledCtx, cancel := context.WithCancel(ctx)
 	cancel()
 
 	// Upload should fail due to cancelled context
-	err = manager.Upload(cancelledCtx, []byte("test data"), "test.jpg")
+	err := manager.Upload(cancelledCtx, []byte("test data"), "test.jpg")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "context canceled")
 }
+
+func TestS3ImageManagerDelete(t *testing.T) {
+	mockS3 := NewMockS3Service()
+	server := httptest.NewServer(mockS3)
+	defer server.Close()
+
+	ctx := context.Background()
+	manager := newTestS3ImageManager(t, server.URL)
+
+	assert.NoError(t, manager.Upload(ctx, []byte("fake image data"), "photos/5/1-abc.jpg"))
+	assert.NoError(t, manager.Delete(ctx, "photos/5/1-abc.jpg"))
+	_, exists := mockS3.GetObjectStored("photos/5/1-abc.jpg")
+	assert.False(t, exists, "Object should be deleted")
+
+	// deleting missing object is not an error
+	assert.NoError(t, manager.Delete(ctx, "photos/5/missing.jpg"))
+}
+
+func TestS3ImageManagerRead(t *testing.T) {
+	mockS3 := NewMockS3Service()
+	server := httptest.NewServer(mockS3)
+	defer server.Close()
+
+	ctx := context.Background()
+	manager := newTestS3ImageManager(t, server.URL)
+
+	photo := testJPEG(t, 4, 4)
+	require.NoError(t, manager.Upload(ctx, photo, "photos/5/1_ab_L.jpg"))
+	assert.Equal(t, "image/jpeg", mockS3.contentTypes["photos/5/1_ab_L.jpg"])
+	require.NoError(t, manager.Upload(ctx, []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "photos/5/1_ab_L.webp"))
+	assert.Equal(t, "image/webp", mockS3.contentTypes["photos/5/1_ab_L.webp"], "content type is detected from data")
+
+	exists, err := manager.Exists(ctx, "photos/5/1_ab_L.jpg")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = manager.Exists(ctx, "photos/5/missing.jpg")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	data, err := manager.Get(ctx, "photos/5/1_ab_L.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, photo, data)
+	_, err = manager.Get(ctx, "photos/5/missing.jpg")
+	assert.ErrorIs(t, err, errImageNotFound)
+
+	assert.Equal(t, server.URL+"/test-bucket/photos/5/1_ab_L.jpg", manager.URL("photos/5/1_ab_L.jpg"))
+	assert.Equal(t, server.URL+"/test-bucket/a%20b.jpg", manager.URL("a b.jpg"))
+	signed, err := manager.SignedURL(ctx, "photos/5/1_ab_L.jpg", time.Hour)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(signed, server.URL+"/test-bucket/photos/5/1_ab_L.jpg?"))
+	assert.Contains(t, signed, "X-Amz-Expires=3600")
+	assert.Contains(t, signed, "X-Amz-Signature=")
+
+	t.Run("public url", func(t *testing.T) {
+		manager, err := NewS3ImageManager(ctx, S3Config{
+			Endpoint:  server.URL,
+			Bucket:    testS3Bucket,
+			PublicUrl: "https://cdn.example.com/",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "https://cdn.example.com/users/5_S.jpg", manager.URL("users/5_S.jpg"))
+	})
+}
+
+func TestImagesHandler(t *testing.T) {
+	im := NewMockImageManager(t.TempDir())
+	storage := NewStorage(MockDatabase(t))
+	cases := []struct {
+		name         string
+		signed       bool
+		url          string
+		status       int
+		location     string
+		cacheControl string
+	}{
+		{"public", false, "/images/users/5_S.jpg", http.StatusFound,
+			"https://images.example.com/users/5_S.jpg", "public, max-age=86400"},
+		{"signed", true, "/images/users/5_S.jpg", http.StatusFound,
+			"https://images.example.com/users/5_S.jpg?expires=3600", "public, max-age=1800"},
+		{"no key", false, "/images/", http.StatusNotFound, "", ""},
+		{"unknown prefix", true, "/images/backups/db.sqlite", http.StatusNotFound, "", ""},
+		{"prefix only", true, "/images/users/", http.StatusNotFound, "", ""},
+		{"not clean", true, "/images/users/..%2Fbackups/db.sqlite", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			app := NewAppServer(&RuntimeConfig{Datadir: "testdata/summits", SignedImageUrls: tt.signed}, storage, scs.New(), im)
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", tt.url, nil)
+			require.NoError(t, err)
+			app.router.ServeHTTP(rr, req)
+			assert.Equal(t, tt.status, rr.Code)
+			assert.Equal(t, tt.location, rr.Header().Get("Location"))
+			assert.Equal(t, tt.cacheControl, rr.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestLocalImageManager(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "images")
+	manager, err := NewLocalImageManager(dir)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, manager.Upload(ctx, []byte("first"), "users/5_S.jpg"))
+	require.NoError(t, manager.Upload(ctx, []byte("second"), "users/5_S.jpg"))
+	data, err := os.ReadFile(filepath.Join(dir, "users", "5_S.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+	entries, err := os.ReadDir(filepath.Join(dir, "users"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files left")
+
+	assert.Error(t, manager.Upload(ctx, []byte("x"), "../outside.jpg"))
+	assert.Error(t, manager.Upload(ctx, []byte("x"), "/etc/outside.jpg"))
+	assert.NoFileExists(t, filepath.Join(dir, "..", "outside.jpg"))
+
+	photo := testJPEG(t, 4, 4)
+	require.NoError(t, manager.Upload(ctx, photo, "photos/5/1_ab_L.jpg"))
+	exists, err := manager.Exists(ctx, "photos/5/1_ab_L.jpg")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = manager.Exists(ctx, "photos/5/missing.jpg")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	data, err = manager.Get(ctx, "photos/5/1_ab_L.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, photo, data)
+	_, err = manager.Get(ctx, "photos/5/missing.jpg")
+	assert.ErrorIs(t, err, errImageNotFound)
+	assert.Equal(t, "/images/photos/5/1_ab_L.jpg", manager.URL("photos/5/1_ab_L.jpg"))
+	signed, err := manager.SignedURL(ctx, "photos/5/1_ab_L.jpg", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "/images/photos/5/1_ab_L.jpg", signed)
+
+	require.NoError(t, manager.Delete(ctx, "users/5_S.jpg"))
+	assert.NoFileExists(t, filepath.Join(dir, "users", "5_S.jpg"))
+	assert.NoError(t, manager.Delete(ctx, "users/5_S.jpg"), "deleting missing image is not an error")
+
+	t.Run("served by app", func(t *testing.T) {
+		storage := NewStorage(MockDatabase(t))
+		app := NewAppServer(&RuntimeConfig{Datadir: "testdata/summits"}, storage, scs.New(), manager)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "photos", ".upload-123"), []byte("partial"), 0644))
+
+		cases := []struct {
+			url    string
+			status int
+		}{
+			{"/images/photos/5/1_ab_L.jpg", http.StatusOK},
+			{"/images/users/5_S.jpg", http.StatusNotFound},
+			{"/images/photos/5/", http.StatusNotFound},
+			{"/images/photos/.upload-123", http.StatusNotFound},
+			{"/images/../go.mod", http.StatusNotFound},
+		}
+		for _, tt := range cases {
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", tt.url, nil)
+			require.NoError(t, err)
+			app.router.ServeHTTP(rr, req)
+			assert.Equal(t, tt.status, rr.Code, tt.url)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, "image/jpeg", rr.Header().Get("Content-Type"))
+				assert.Equal(t, "public, max-age=86400", rr.Header().Get("Cache-Control"))
+				assert.NotEmpty(t, rr.Header().Get("Last-Modified"))
+			}
+		}
+	})
+}
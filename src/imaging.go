@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"log/slog"
+	"net/http"
+
+	"github.com/chai2010/webp"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	maxImageSize = 10 * 1024 * 1024
+	// maxImagePixels keeps decoded image within about 200 MB of memory
+	maxImagePixels = 50_000_000
+	jpegQuality    = 90
+	webpQuality    = 80
+
+	FormatJPEG = "jpg"
+	FormatWebP = "webp"
+)
+
+var (
+	errImageFormat   = errors.New("only JPEG, PNG and WebP images are supported")
+	errImageTooLarge = fmt.Errorf("image is larger than %d MB", maxImageSize/1024/1024)
+)
+
+// imageSlots limits images processed at once, as every decoded one
+// may take up to maxImagePixels worth of memory
+var imageSlots = make(chan struct{}, 2)
+
+// imageSizes are renditions made of every processed image,
+// limited by the longest side. Images are never upscaled.
+var imageSizes = []struct {
+	Size    string
+	MaxSide int
+}{
+	{ImageLarge, 1600},
+	{ImageMedium, 480},
+	{ImageSmall, 120},
+}
+
+type Rendition struct {
+	Size          string
+	Format        string
+	Data          []byte
+	Width, Height int
+}
+
+// RenditionKey is a storage key of the image rendition,
+// e.g. users/5_S.jpg for base users/5
+func RenditionKey(base, size, format string) string {
+	return fmt.Sprintf("%s_%s.%s", base, size, format)
+}
+
+// renditionKeys lists keys of all renditions made for the base
+func renditionKeys(base string) []string {
+	keys := make([]string, 0, 2*len(imageSizes))
+	for _, s := range imageSizes {
+		keys = append(keys, RenditionKey(base, s.Size, FormatJPEG), RenditionKey(base, s.Size, FormatWebP))
+	}
+	return keys
+}
+
+// ProcessImage decodes JPEG, PNG or WebP image, turns it according to EXIF
+// orientation and makes renditions of every size in JPEG and WebP.
+// Metadata of the original, GPS position included, is not copied.
+func ProcessImage(data []byte) ([]Rendition, error) {
+	imageSlots <- struct{}{}
+	defer func() { <-imageSlots }()
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	renditions := make([]Rendition, 0, 2*len(imageSizes))
+	// every size is scaled from the previous one, which is much faster
+	// than scaling large original each time and looks the same
+	for _, s := range imageSizes {
+		img = fitImage(img, s.MaxSide)
+		width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, Rendition{s.Size, FormatJPEG, buf.Bytes(), width, height})
+
+		buf = bytes.Buffer{}
+		if err := webp.Encode(&buf, img, &webp.Options{Quality: webpQuality}); err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, Rendition{s.Size, FormatWebP, buf.Bytes(), width, height})
+	}
+	return renditions, nil
+}
+
+// UploadRenditions stores renditions under keys made of base.
+// Renditions uploaded before a failure are removed.
+func UploadRenditions(ctx context.Context, im ImageManager, base string, renditions []Rendition) error {
+	for i, r := range renditions {
+		err := im.Upload(ctx, r.Data, RenditionKey(base, r.Size, r.Format))
+		if err == nil {
+			continue
+		}
+		for _, uploaded := range renditions[:i] {
+			key := RenditionKey(base, uploaded.Size, uploaded.Format)
+			if err := im.Delete(context.WithoutCancel(ctx), key); err != nil {
+				slog.Error("Failed to delete image", "key", key, "error", err)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+func decodeImage(data []byte) (*image.RGBA, error) {
+	if len(data) > maxImageSize {
+		return nil, errImageTooLarge
+	}
+	// content type is detected from data, the one sent by client is not trusted
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/webp":
+	default:
+		return nil, errImageFormat
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errImageFormat
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, errImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errImageFormat
+	}
+
+	// transparent areas would turn black in JPEG
+	bounds := img.Bounds()
+	canvas := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(canvas, canvas.Bounds(), img, bounds.Min, draw.Over)
+
+	switch contentType {
+	case "image/jpeg":
+		return orientImage(canvas, tiffOrientation(jpegExif(data))), nil
+	case "image/webp":
+		return orientImage(canvas, tiffOrientation(webpExif(data))), nil
+	}
+	return canvas, nil
+}
+
+// fitImage scales image down so that its longest side is at most maxSide
+func fitImage(img *image.RGBA, maxSide int) *image.RGBA {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if width <= maxSide && height <= maxSide {
+		return img
+	}
+	if width >= height {
+		width, height = maxSide, max(1, height*maxSide/width)
+	} else {
+		width, height = max(1, width*maxSide/height), maxSide
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	return dst
+}
+
+// orientImage turns image the way EXIF orientation tag prescribes
+func orientImage(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		// 5-8 swap the sides
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // rotated 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // upside down mirrored
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // needs clockwise rotation
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // needs counterclockwise rotation
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], img.Pix[img.PixOffset(sx, sy):img.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+// jpegExif returns TIFF structure from APP1 segment of JPEG, or nil
+func jpegExif(data []byte) []byte {
+	pos := 2 // after SOI
+	for pos+4 <= len(data) && data[pos] == 0xff {
+		marker := data[pos+1]
+		// start of scan, metadata segments are over
+		if marker == 0xda {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		segment := data[pos+4 : end]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		pos = end
+	}
+	return nil
+}
+
+// webpExif returns TIFF structure from EXIF chunk of WebP, or nil
+func webpExif(data []byte) []byte {
+	pos := 12 // after RIFF header
+	for pos+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size
+		if end > len(data) {
+			break
+		}
+		if string(data[pos:pos+4]) == "EXIF" {
+			return bytes.TrimPrefix(data[pos+8:end], []byte("Exif\x00\x00"))
+		}
+		// chunks are padded to even size
+		pos = end + size%2
+	}
+	return nil
+}
+
+// tiffOrientation reads orientation tag from IFD0, 1 (normal) is returned
+// if there is none
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		// orientation is SHORT value stored within the entry
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 1
+}
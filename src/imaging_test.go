@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/chai2010/webp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testJPEG returns JPEG image with EXIF segment, like cameras write
+func testJPEG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+	data := buf.Bytes()
+	exif := append([]byte{0xff, 0xe1, 0x00, 0x10}, []byte("Exif\x00\x00GPS-DATA")...)
+	return append(append([]byte{0xff, 0xd8}, exif...), data[2:]...)
+}
+
+// testOrientedJPEG returns JPEG image with orientation tag, its left half is red
+func testOrientedJPEG(t *testing.T, width, height int, orientation byte) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}))
+	data := buf.Bytes()
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, // header, IFD0 at 8
+		1, 0, // one entry
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, orientation, 0, 0, 0,
+		0, 0, 0, 0} // no next IFD
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := append([]byte{0xff, 0xe1, 0, byte(len(segment) + 2)}, segment...)
+	return append(append([]byte{0xff, 0xd8}, app1...), data[2:]...)
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r>>8 > 200 && g>>8 < 60 && b>>8 < 60
+}
+
+func findRendition(renditions []Rendition, size, format string) *Rendition {
+	for i := range renditions {
+		if renditions[i].Size == size && renditions[i].Format == format {
+			return &renditions[i]
+		}
+	}
+	return nil
+}
+
+func TestProcessImage(t *testing.T) {
+	t.Run("renditions", func(t *testing.T) {
+		data := testJPEG(t, 2000, 1000)
+		require.True(t, bytes.Contains(data, []byte("GPS-DATA")))
+		renditions, err := ProcessImage(data)
+		require.NoError(t, err)
+		require.Len(t, renditions, 6)
+
+		cases := []struct {
+			size          string
+			width, height int
+		}{
+			{ImageLarge, 1600, 800},
+			{ImageMedium, 480, 240},
+			{ImageSmall, 120, 60},
+		}
+		for _, tt := range cases {
+			r := findRendition(renditions, tt.size, FormatJPEG)
+			require.NotNil(t, r, tt.size)
+			assert.Equal(t, []int{tt.width, tt.height}, []int{r.Width, r.Height})
+			assert.False(t, bytes.Contains(r.Data, []byte("GPS-DATA")))
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(r.Data))
+			require.NoError(t, err)
+			assert.Equal(t, []int{tt.width, tt.height}, []int{cfg.Width, cfg.Height})
+
+			r = findRendition(renditions, tt.size, FormatWebP)
+			require.NotNil(t, r, tt.size)
+			cfg, err = webp.DecodeConfig(bytes.NewReader(r.Data))
+			require.NoError(t, err)
+			assert.Equal(t, []int{tt.width, tt.height}, []int{cfg.Width, cfg.Height})
+		}
+	})
+
+	t.Run("small image is not upscaled", func(t *testing.T) {
+		renditions, err := ProcessImage(testJPEG(t, 100, 50))
+		require.NoError(t, err)
+		for _, r := range renditions {
+			assert.Equal(t, []int{100, 50}, []int{r.Width, r.Height})
+		}
+	})
+
+	t.Run("rotated by exif", func(t *testing.T) {
+		cases := []struct {
+			orientation byte
+			// where the red half goes
+			redAt    image.Point
+			notRedAt image.Point
+		}{
+			{1, image.Pt(2, 30), image.Pt(37, 30)},
+			{3, image.Pt(37, 30), image.Pt(2, 30)},
+			{6, image.Pt(30, 2), image.Pt(30, 37)},
+			{8, image.Pt(30, 37), image.Pt(30, 2)},
+		}
+		for _, tt := range cases {
+			renditions, err := ProcessImage(testOrientedJPEG(t, 40, 60, tt.orientation))
+			require.NoError(t, err)
+			r := findRendition(renditions, ImageLarge, FormatJPEG)
+			img, err := jpeg.Decode(bytes.NewReader(r.Data))
+			require.NoError(t, err)
+			if tt.orientation >= 5 {
+				assert.Equal(t, image.Rect(0, 0, 60, 40), img.Bounds())
+			} else {
+				assert.Equal(t, image.Rect(0, 0, 40, 60), img.Bounds())
+			}
+			assert.True(t, isRed(img.At(tt.redAt.X, tt.redAt.Y)), "orientation %d", tt.orientation)
+			assert.False(t, isRed(img.At(tt.notRedAt.X, tt.notRedAt.Y)), "orientation %d", tt.orientation)
+		}
+	})
+
+	t.Run("png becomes jpeg on white", func(t *testing.T) {
+		img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+		img.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, img))
+		renditions, err := ProcessImage(buf.Bytes())
+		require.NoError(t, err)
+		decoded, err := jpeg.Decode(bytes.NewReader(findRendition(renditions, ImageSmall, FormatJPEG).Data))
+		require.NoError(t, err)
+		r, g, b, _ := decoded.At(9, 9).RGBA()
+		assert.Greater(t, r>>8, uint32(240))
+		assert.Greater(t, g>>8, uint32(240))
+		assert.Greater(t, b>>8, uint32(240))
+	})
+
+	t.Run("webp", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, webp.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 30, 20)), nil))
+		renditions, err := ProcessImage(buf.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, 30, renditions[0].Width)
+		assert.Equal(t, 20, renditions[0].Height)
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		_, err := ProcessImage([]byte("GIF89a not really"))
+		assert.ErrorIs(t, err, errImageFormat)
+		_, err = ProcessImage([]byte{0xff, 0xd8, 0xff, 0xe0, 0x00})
+		assert.ErrorIs(t, err, errImageFormat)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		_, err := ProcessImage(make([]byte, maxImageSize+1))
+		assert.ErrorIs(t, err, errImageTooLarge)
+
+		// dimensions from the frame header are checked before decoding
+		data := testJPEG(t, 8, 8)
+		sof := bytes.Index(data, []byte{0xff, 0xc0})
+		require.Positive(t, sof)
+		copy(data[sof+5:sof+9], []byte{0x27, 0x10, 0x27, 0x10})
+		_, err = ProcessImage(data)
+		assert.ErrorIs(t, err, errImageTooLarge)
+	})
+}
+
+// failingImageManager fails uploads after the given number of them
+type failingImageManager struct {
+	*MockImageManager
+	uploads int
+}
+
+func (im *failingImageManager) Upload(ctx context.Context, imageData []byte, key string) error {
+	if im.uploads == 0 {
+		return errors.New("upload failed")
+	}
+	im.uploads--
+	return im.MockImageManager.Upload(ctx, imageData, key)
+}
+
+func TestUploadRenditions(t *testing.T) {
+	renditions, err := ProcessImage(testJPEG(t, 40, 30))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, UploadRenditions(context.Background(), NewMockImageManager(dir), "users/5", renditions))
+	for _, key := range renditionKeys("users/5") {
+		assert.FileExists(t, path.Join(dir, key))
+	}
+	assert.FileExists(t, path.Join(dir, "users/5_S.jpg"))
+
+	dir = t.TempDir()
+	im := &failingImageManager{NewMockImageManager(dir), 3}
+	assert.Error(t, UploadRenditions(context.Background(), im, "users/6", renditions))
+	entries, err := os.ReadDir(path.Join(dir, "users"))
+	require.NoError(t, err)
+	assert.Empty(t, entries, "uploaded renditions are removed")
+}
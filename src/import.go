@@ -0,0 +1,446 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+const (
+	maxImportSize  = 100 << 20
+	maxImportFiles = 2000
+)
+
+// Per-item statuses of climbs import
+const (
+	ImportCreated  = "created"
+	ImportUpdated  = "updated"
+	ImportSkipped  = "skipped"
+	ImportRejected = "rejected"
+)
+
+// DetectedAscent is a summit the imported track passed by.
+// Existing holds user's first ascent of the summit if there is one.
+type DetectedAscent struct {
+	SummitId   string      `json:"summit_id"`
+	SummitName *string     `json:"summit_name"`
+	RidgeId    string      `json:"ridge_id"`
+	Height     int         `json:"height"`
+	Date       InexactDate `json:"date"`
+	Distance   float64     `json:"distance"`
+	File       string      `json:"file"`
+	Existing   *ClimbData  `json:"existing"`
+}
+
+type TrackImportError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+type TrackImport struct {
+	Ascents []DetectedAscent   `json:"ascents"`
+	Errors  []TrackImportError `json:"errors"`
+}
+
+// detectAscents finds summits the track passes within radius (in meters).
+// Every summit is reported once per local day, at its closest approach.
+func detectAscents(g *gpx.GPX, summits []SummitsTableItem, radius float64) map[string]*DetectedAscent {
+	// cheap prefilter before computing exact distance, longitude
+	// degree is at least twice shorter than latitude one at these latitudes
+	latMargin := radius / 111000
+	lngMargin := 2 * latMargin
+
+	found := make(map[string]*DetectedAscent)
+	for _, t := range g.Tracks {
+		for _, seg := range t.Segments {
+			for _, p := range seg.Points {
+				for i := range summits {
+					s := &summits[i]
+					lat, lng := float64(s.Lat), float64(s.Lng)
+					if math.Abs(p.Latitude-lat) > latMargin || math.Abs(p.Longitude-lng) > lngMargin {
+						continue
+					}
+					d := gpx.HaversineDistance(lat, lng, p.Latitude, p.Longitude)
+					if d > radius {
+						continue
+					}
+					var date InexactDate
+					if !p.Timestamp.IsZero() {
+						local := p.Timestamp.In(summitsTimeZone)
+						date = InexactDate{int64(local.Year()), int64(local.Month()), int64(local.Day())}
+					}
+					key := fmt.Sprintf("%s/%d.%d.%d", s.Id, date.Day, date.Month, date.Year)
+					if prev, ok := found[key]; ok && prev.Distance <= d {
+						continue
+					}
+					found[key] = &DetectedAscent{
+						SummitId:   s.Id,
+						SummitName: s.Name,
+						RidgeId:    s.RidgeId,
+						Height:     s.Height,
+						Date:       date,
+						Distance:   d,
+					}
+				}
+			}
+		}
+	}
+	return found
+}
+
+// readZipTrack reads a single archive entry with size limit
+func readZipTrack(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > maxTrackSize {
+		return nil, fmt.Errorf("file is too large")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxTrackSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxTrackSize {
+		return nil, fmt.Errorf("file is too large")
+	}
+	return data, nil
+}
+
+// DetectArchiveAscents looks for summits ascents in zip archive of GPX tracks.
+// Problems with individual files are reported in the result, error is
+// returned only if the archive itself can not be read.
+func DetectArchiveAscents(archive []byte, summits []SummitsTableItem, radius float64) (*TrackImport, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zip archive: %v", err)
+	}
+	result := &TrackImport{Ascents: make([]DetectedAscent, 0), Errors: make([]TrackImportError, 0)}
+	found := make(map[string]*DetectedAscent)
+	files := 0
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		switch strings.ToLower(path.Ext(f.Name)) {
+		case ".gpx":
+		case ".fit":
+			result.Errors = append(result.Errors, TrackImportError{f.Name, "FIT files are not supported, convert them to GPX"})
+			continue
+		default:
+			continue
+		}
+		files++
+		if files > maxImportFiles {
+			return nil, fmt.Errorf("too many files in archive, at most %d allowed", maxImportFiles)
+		}
+		data, err := readZipTrack(f)
+		if err != nil {
+			result.Errors = append(result.Errors, TrackImportError{f.Name, err.Error()})
+			continue
+		}
+		g, err := gpx.ParseBytes(data)
+		if err != nil {
+			result.Errors = append(result.Errors, TrackImportError{f.Name, fmt.Sprintf("failed to parse gpx: %v", err)})
+			continue
+		}
+		for key, a := range detectAscents(g, summits, radius) {
+			if prev, ok := found[key]; ok && prev.Distance <= a.Distance {
+				continue
+			}
+			a.File = f.Name
+			found[key] = a
+		}
+	}
+	for _, a := range found {
+		result.Ascents = append(result.Ascents, *a)
+	}
+	sort.Slice(result.Ascents, func(i, j int) bool {
+		a, b := result.Ascents[i].Date, result.Ascents[j].Date
+		if a != b {
+			return a.Year < b.Year ||
+				a.Year == b.Year && (a.Month < b.Month || a.Month == b.Month && a.Day < b.Day)
+		}
+		return result.Ascents[i].SummitId < result.Ascents[j].SummitId
+	})
+	return result, nil
+}
+
+type AscentImportItem struct {
+	SummitId  string      `json:"summit_id"`
+	Date      InexactDate `json:"date"`
+	Overwrite bool        `json:"overwrite"`
+}
+
+type AscentImportResult struct {
+	// Row is 1-based number of data row in imported file
+	Row      int    `json:"row,omitempty"`
+	SummitId string `json:"summit_id"`
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+}
+
+// ImportAscents registers confirmed ascents. User's existing climbs
+// are kept intact unless the item explicitly asks to overwrite the date.
+func (s *Storage) ImportAscents(userId int64, items []AscentImportItem) ([]AscentImportResult, error) {
+	results := make([]AscentImportResult, 0, len(items))
+	// achievements are updated once, also when import stops halfway
+	years := make([]int64, 0)
+	defer func() {
+		if len(years) > 0 {
+			s.climbsChanged(userId, years...)
+		}
+	}()
+	for _, item := range items {
+		result := AscentImportResult{SummitId: item.SummitId}
+		if err := item.Date.Validate(); err != nil {
+			result.Status, result.Message = ImportRejected, fmt.Sprintf("invalid date: %s", err)
+			results = append(results, result)
+			continue
+		}
+		n, err := s.Count("SELECT COUNT(*) FROM summits WHERE id = ?", item.SummitId)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			result.Status, result.Message = ImportRejected, "unknown summit"
+			results = append(results, result)
+			continue
+		}
+
+		var comment sql.NullString
+		err = s.db.QueryRow(`SELECT comment FROM first_climbs WHERE summit_id = ? AND user_id = ?`,
+			item.SummitId, userId).Scan(&comment)
+		switch {
+		case err == sql.ErrNoRows:
+			result.Status = ImportCreated
+		case err != nil:
+			return nil, err
+		case !item.Overwrite:
+			result.Status, result.Message = ImportSkipped, "summit is already climbed"
+			results = append(results, result)
+			continue
+		default:
+			result.Status = ImportUpdated
+		}
+		_, oldYear, err := s.updateClimb(item.SummitId, userId, item.Date, comment.String, nil)
+		if err != nil {
+			return nil, err
+		}
+		years = append(years, oldYear, item.Date.Year)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// ClimbRecord is a row of user's climbs list export. Only summit id,
+// date and comment are used on import, the rest is informational.
+type ClimbRecord struct {
+	SummitId string `json:"summit_id"`
+	Name     string `json:"name"`
+	Ridge    string `json:"ridge"`
+	Height   int    `json:"height"`
+	Date     string `json:"date"`
+	Comment  string `json:"comment"`
+}
+
+var climbRecordColumns = []string{"summit_id", "name", "ridge", "height", "date", "comment"}
+
+// Spreadsheet applications need byte order mark to detect UTF-8 in CSV
+const utf8BOM = "\ufeff"
+
+// FetchUserClimbRecords returns all user's ascents for export
+func (s *Storage) FetchUserClimbRecords(userId int64) ([]ClimbRecord, error) {
+	rows, err := s.db.Query(`SELECT c.summit_id, COALESCE(s.name, ''), r.name, s.height,
+			c.year, c.month, c.day, COALESCE(c.comment, '')
+		FROM climbs c
+			INNER JOIN summits s ON s.id = c.summit_id
+			INNER JOIN ridges r ON r.id = s.ridge_id
+		WHERE c.user_id = ?
+		ORDER BY c.year ASC NULLS LAST, c.month ASC NULLS LAST, c.day ASC NULLS LAST, c.id ASC`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := make([]ClimbRecord, 0)
+	for rows.Next() {
+		var rec ClimbRecord
+		var date InexactDate
+		var year, month, day sql.NullInt64
+		err := rows.Scan(&rec.SummitId, &rec.Name, &rec.Ridge, &rec.Height, &year, &month, &day, &rec.Comment)
+		if err != nil {
+			return nil, err
+		}
+		date.FromSQL(year, month, day)
+		rec.Date = date.String()
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+func WriteClimbRecordsCSV(w io.Writer, records []ClimbRecord) error {
+	if _, err := io.WriteString(w, utf8BOM); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(climbRecordColumns); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		err := cw.Write([]string{
+			rec.SummitId, rec.Name, rec.Ridge, fmt.Sprintf("%d", rec.Height), rec.Date, rec.Comment,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadClimbRecordsCSV reads climbs list with header row. Columns are
+// matched by name, so they may be reordered, only summit_id is required.
+func ReadClimbRecordsCSV(r io.Reader) ([]ClimbRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, utf8BOM)
+		}
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["summit_id"]; !ok {
+		return nil, errors.New("summit_id column is required")
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	records := make([]ClimbRecord, 0)
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, ClimbRecord{
+			SummitId: field(row, "summit_id"),
+			Name:     field(row, "name"),
+			Ridge:    field(row, "ridge"),
+			Date:     field(row, "date"),
+			Comment:  field(row, "comment"),
+		})
+	}
+	return records, nil
+}
+
+func ReadClimbRecordsJSON(r io.Reader) ([]ClimbRecord, error) {
+	var records []ClimbRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ImportClimbRecords registers climbs from imported list. Summit ids
+// may be legacy ones. A row matching existing ascent by date updates its
+// comment, otherwise a new ascent is added, so nothing is lost and
+// importing the same file twice changes nothing.
+func (s *Storage) ImportClimbRecords(userId int64, records []ClimbRecord) ([]AscentImportResult, error) {
+	results := make([]AscentImportResult, 0, len(records))
+	// achievements are updated once, also when import stops halfway
+	years := make([]int64, 0)
+	defer func() {
+		if len(years) > 0 {
+			s.climbsChanged(userId, years...)
+		}
+	}()
+	for i, rec := range records {
+		result := AscentImportResult{Row: i + 1, SummitId: strings.TrimSpace(rec.SummitId)}
+		if result.SummitId == "" {
+			result.Status, result.Message = ImportRejected, "summit id is required"
+			results = append(results, result)
+			continue
+		}
+		var date InexactDate
+		if err := date.Parse(strings.TrimSpace(rec.Date)); err != nil {
+			result.Status, result.Message = ImportRejected, fmt.Sprintf("invalid date: %s", rec.Date)
+			results = append(results, result)
+			continue
+		}
+		canonicalId, err := s.ResolveLegacyId(result.SummitId)
+		if err != nil {
+			return nil, err
+		}
+		if canonicalId != "" {
+			result.SummitId = canonicalId
+		}
+		n, err := s.Count("SELECT COUNT(*) FROM summits WHERE id = ?", result.SummitId)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			result.Status, result.Message = ImportRejected, "unknown summit"
+			results = append(results, result)
+			continue
+		}
+
+		var changed []int64
+		result.Status, result.Message, changed, err = s.importClimb(userId, result.SummitId, date, rec.Comment)
+		if err != nil {
+			return nil, err
+		}
+		years = append(years, changed...)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// importClimb registers a single imported climb and returns import status
+// with the years of changed climbs, achievements are left to the caller
+func (s *Storage) importClimb(userId int64, summitId string, date InexactDate, comment string) (string, string, []int64, error) {
+	ascents, err := s.FetchUserAscents(userId, summitId)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if len(ascents) == 0 {
+		_, _, err = s.updateClimb(summitId, userId, date, comment, nil)
+		return ImportCreated, "", []int64{date.Year}, err
+	}
+	for _, a := range ascents {
+		if a.Date != date {
+			continue
+		}
+		if a.Comment == comment {
+			return ImportSkipped, "already registered", nil, nil
+		}
+		_, _, err = s.changeAscent(a.Id, userId, date, comment, nil)
+		return ImportUpdated, "", []int64{date.Year}, err
+	}
+	_, err = addAscent(s.db, summitId, userId, date, comment)
+	return ImportCreated, "repeated ascent", []int64{date.Year}, err
+}
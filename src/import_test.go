@@ -0,0 +1,322 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const malinovajaTrack = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1"><trk><trkseg>
+	<trkpt lat="54.0150" lon="58.2800"><time>2021-02-01T04:00:00Z</time></trkpt>
+	<trkpt lat="54.0205" lon="58.2914"><time>2021-02-01T06:00:00Z</time></trkpt>
+</trkseg></trk></gpx>`
+
+func makeTracksArchive(t *testing.T, files map[string][]byte) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, data := range files {
+		fw, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = fw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestImportGPX(t *testing.T) {
+	app := GetMockApp(t, 7, &RuntimeConfig{Datadir: "testdata/summits"})
+	cookie := &http.Cookie{Name: "session", Value: "mock_session_token"}
+
+	kirel, err := os.ReadFile("testdata/tracks/kirel.gpx")
+	require.NoError(t, err)
+	archive := makeTracksArchive(t, map[string][]byte{
+		"2023/kirel.gpx":      kirel,
+		"2021/malinovaja.GPX": []byte(malinovajaTrack),
+		"broken.gpx":          []byte("not a gpx"),
+		"watch.fit":           []byte("binary"),
+		"notes.txt":           []byte("ignored"),
+	})
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("archive", "tracks.zip")
+	require.NoError(t, err)
+	_, err = fw.Write(archive)
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/api/user/me/climbs/import/gpx", body)
+	require.NoError(t, err)
+	req.AddCookie(cookie)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	app.router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var detected TrackImport
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&detected))
+	require.Len(t, detected.Ascents, 2)
+	assert.Equal(t, "malinovaja", detected.Ascents[0].SummitId)
+	assert.Equal(t, InexactDate{2021, 2, 1}, detected.Ascents[0].Date)
+	assert.Equal(t, "2021/malinovaja.GPX", detected.Ascents[0].File)
+	assert.Nil(t, detected.Ascents[0].Existing)
+	assert.Equal(t, "kirel", detected.Ascents[1].SummitId)
+	assert.Equal(t, InexactDate{2023, 7, 15}, detected.Ascents[1].Date)
+	require.NotNil(t, detected.Ascents[1].Existing, "existing climb should be reported")
+	assert.Equal(t, InexactDate{2002, 11, 5}, detected.Ascents[1].Existing.Date)
+
+	errorFiles := make([]string, 0)
+	for _, e := range detected.Errors {
+		errorFiles = append(errorFiles, e.File)
+	}
+	assert.ElementsMatch(t, []string{"broken.gpx", "watch.fit"}, errorFiles)
+
+	confirm := func(items []AscentImportItem) []AscentImportResult {
+		data, err := json.Marshal(map[string]any{"ascents": items})
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/user/me/climbs/import/gpx/confirm", bytes.NewReader(data))
+		require.NoError(t, err)
+		req.AddCookie(cookie)
+		app.router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var response struct {
+			Results []AscentImportResult `json:"results"`
+		}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		return response.Results
+	}
+
+	kurkakClimbs, err := app.Api.Storage.Count("SELECT COUNT(*) FROM climbs WHERE summit_id = 'kurkak' AND user_id = 7")
+	require.NoError(t, err)
+	results := confirm([]AscentImportItem{
+		{SummitId: "malinovaja", Date: InexactDate{2021, 2, 1}},
+		{SummitId: "kirel", Date: InexactDate{2023, 7, 15}},
+		{SummitId: "nonexistent", Date: InexactDate{2023, 7, 15}},
+		{SummitId: "kurkak", Date: InexactDate{2023, 13, 42}},
+		{SummitId: "kurkak", Date: InexactDate{2023, 0, 5}},
+		{SummitId: "kurkak", Date: InexactDate{0, 7, 0}},
+	})
+	statuses := make([]string, len(results))
+	for i, r := range results {
+		statuses[i] = r.Status
+	}
+	assert.Equal(t, []string{ImportCreated, ImportSkipped, ImportRejected, ImportRejected, ImportRejected, ImportRejected}, statuses)
+	assert.Contains(t, results[3].Message, "invalid date")
+	climbs, err := app.Api.Storage.Count("SELECT COUNT(*) FROM climbs WHERE summit_id = 'kurkak' AND user_id = 7")
+	require.NoError(t, err)
+	assert.Equal(t, kurkakClimbs, climbs, "ascents with invalid dates are not stored")
+
+	summit, err := app.Api.Storage.FetchSummit("kirel", 7)
+	require.NoError(t, err)
+	assert.Equal(t, InexactDate{2002, 11, 5}, summit.ClimbData.Date, "climb should not be overwritten")
+
+	results = confirm([]AscentImportItem{{SummitId: "kirel", Date: InexactDate{2023, 7, 15}, Overwrite: true}})
+	require.Len(t, results, 1)
+	assert.Equal(t, ImportUpdated, results[0].Status)
+
+	summit, err = app.Api.Storage.FetchSummit("kirel", 7)
+	require.NoError(t, err)
+	assert.Equal(t, InexactDate{2023, 7, 15}, summit.ClimbData.Date)
+	assert.Equal(t, "Decentralized didactic customer loyalty", summit.ClimbData.Comment, "comment should be kept")
+
+	summit, err = app.Api.Storage.FetchSummit("malinovaja", 7)
+	require.NoError(t, err)
+	require.NotNil(t, summit.ClimbData)
+	assert.Equal(t, InexactDate{2021, 2, 1}, summit.ClimbData.Date)
+}
+
+func TestImportGPXErrors(t *testing.T) {
+	cases := []struct {
+		name           string
+		userId         int64
+		url            string
+		contentType    string
+		body           string
+		expectedStatus int
+	}{
+		{"unauthenticated", 0, "/api/user/me/climbs/import/gpx", "", "", http.StatusUnauthorized},
+		{"no archive", 7, "/api/user/me/climbs/import/gpx", "application/x-www-form-urlencoded", "", http.StatusBadRequest},
+		{"invalid confirmation", 7, "/api/user/me/climbs/import/gpx/confirm", "application/json", "{", http.StatusBadRequest},
+		{"unauthenticated confirmation", 0, "/api/user/me/climbs/import/gpx/confirm", "application/json", "{}", http.StatusUnauthorized},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			app := GetMockApp(t, tt.userId, &RuntimeConfig{Datadir: "testdata/summits"})
+			rr := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", tt.url, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			if tt.userId > 0 {
+				req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			app.router.ServeHTTP(rr, req)
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+		})
+	}
+}
+
+func uploadClimbsFile(t *testing.T, app *App, filename string, data []byte) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	_, err = fw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/api/user/me/climbs/import", body)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	app.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestClimbsExport(t *testing.T) {
+	app := GetMockApp(t, 7, &RuntimeConfig{Datadir: "testdata/summits"})
+
+	export := func(format string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/user/me/climbs/export?format="+format, nil)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "session", Value: "mock_session_token"})
+		app.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := export("csv")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	csvData := rr.Body.Bytes()
+	assert.Equal(t, utf8BOM+
+		"summit_id,name,ridge,height,date,comment\n"+
+		"kirel,Кирель,Малидак,1162,05.11.2002,Decentralized didactic customer loyalty\n"+
+		"kurkak,Куркак,Куркак,1008,15.12.2010,Digitized global intranet\n",
+		string(csvData))
+
+	rr = export("json")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var records []ClimbRecord
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&records))
+	require.Len(t, records, 2)
+	assert.Equal(t, ClimbRecord{"kirel", "Кирель", "Малидак", 1162, "05.11.2002", "Decentralized didactic customer loyalty"}, records[0])
+
+	rr = export("xml")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// importing own export back changes nothing
+	rr = uploadClimbsFile(t, app, "climbs.csv", csvData)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var response struct {
+		Results []AscentImportResult `json:"results"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	require.Len(t, response.Results, 2)
+	for _, r := range response.Results {
+		assert.Equal(t, ImportSkipped, r.Status)
+	}
+}
+
+func TestClimbsImport(t *testing.T) {
+	noSummitIdCsv := "date,comment\n"
+	jsonData := `[
+		{"summit_id": "1026-1", "date": "2019", "comment": "Legacy id"},
+		{"summit_id": "kirel", "date": "05.11.2002", "comment": "New comment"},
+		{"summit_id": "kirel", "date": "07.2020", "comment": "Once again"},
+		{"summit_id": "kurkak", "date": "31.02.2010"},
+		{"summit_id": "unknown", "date": "2010"},
+		{"summit_id": "", "date": "2010"}
+	]`
+	expected := []AscentImportResult{
+		{1, "stolby", ImportCreated, ""},
+		{2, "kirel", ImportUpdated, ""},
+		{3, "kirel", ImportCreated, "repeated ascent"},
+		{4, "kurkak", ImportRejected, "invalid date: 31.02.2010"},
+		{5, "unknown", ImportRejected, "unknown summit"},
+		{6, "", ImportRejected, "summit id is required"},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		app := GetMockApp(t, 7, &RuntimeConfig{Datadir: "testdata/summits"})
+		rr := uploadClimbsFile(t, app, "climbs.json", []byte(jsonData))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var response struct {
+			Results []AscentImportResult `json:"results"`
+		}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		assert.Equal(t, expected, response.Results)
+
+		ascents, err := app.Api.Storage.FetchUserAscents(7, "kirel")
+		require.NoError(t, err)
+		require.Len(t, ascents, 2)
+		assert.Equal(t, "New comment", ascents[0].Comment)
+		assert.Equal(t, InexactDate{2020, 7, 0}, ascents[1].Date)
+		summit, err := app.Api.Storage.FetchSummit("stolby", 7)
+		require.NoError(t, err)
+		require.NotNil(t, summit.ClimbData)
+		assert.Equal(t, InexactDate{2019, 0, 0}, summit.ClimbData.Date)
+	})
+
+	t.Run("achievements", func(t *testing.T) {
+		storage := NewStorage(MockDatabase(t))
+		_, err := storage.LoadSummits("testdata/summits")
+		require.NoError(t, err)
+		results, err := storage.ImportClimbRecords(7, []ClimbRecord{
+			{SummitId: "1021", Date: "01.06.2021"},
+			{SummitId: "stolby", Date: "12.05.2022"},
+		})
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+
+		achievements, err := storage.FetchUserAchievements(7)
+		require.NoError(t, err)
+		seasons := make([]string, 0)
+		for _, a := range achievements {
+			if a.Id == "season-first" {
+				seasons = append(seasons, a.Scope)
+			}
+		}
+		assert.Equal(t, []string{"2022", "2021"}, seasons)
+	})
+
+	t.Run("csv with reordered columns", func(t *testing.T) {
+		app := GetMockApp(t, 7, &RuntimeConfig{Datadir: "testdata/summits"})
+		data := "comment,date,summit_id\n" +
+			"Legacy id,2019,1026-1\n" +
+			"\"New comment\",05.11.2002,kirel\n"
+		rr := uploadClimbsFile(t, app, "climbs.csv", []byte(data))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var response struct {
+			Results []AscentImportResult `json:"results"`
+		}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+		assert.Equal(t, expected[:2], response.Results)
+	})
+
+	t.Run("csv without summit id", func(t *testing.T) {
+		app := GetMockApp(t, 7, &RuntimeConfig{Datadir: "testdata/summits"})
+		rr := uploadClimbsFile(t, app, "climbs.csv", []byte(noSummitIdCsv))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		app := GetMockApp(t, 7, &RuntimeConfig{Datadir: "testdata/summits"})
+		rr := uploadClimbsFile(t, app, "climbs.xls", []byte(noSummitIdCsv))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
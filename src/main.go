@@ -3,12 +3,17 @@ package main
 import (
 	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/alexedwards/scs/sqlite3store"
 	"github.com/alexedwards/scs/v2"
@@ -21,6 +26,14 @@ var uiFS embed.FS
 type RuntimeConfig struct {
 	Datadir      string
 	ItemsPerPage int
+	// TrackRadius is max distance in meters from the summit
+	// for uploaded track to verify the climb
+	TrackRadius float64
+	// BaseUrl is public site url used in links outside of the SPA, i.e. feeds
+	BaseUrl string
+	// SignedImageUrls makes /images/ redirect to signed urls,
+	// for image storage which is not public
+	SignedImageUrls bool
 }
 
 type App struct {
@@ -32,7 +45,7 @@ type App struct {
 
 func NewAppServer(conf *RuntimeConfig, storage *Storage, sm *scs.SessionManager, imageManager ImageManager) *App {
 	app := &App{
-		Api:        NewApi(conf, storage, sm),
+		Api:        NewApi(conf, storage, sm, imageManager),
 		AuthServer: NewAuthServer(GetAuthProviders(os.Getenv("BASE_URL"), imageManager), storage, sm),
 		SM:         sm,
 		router:     chi.NewRouter(),
@@ -59,6 +72,14 @@ func NewAppServer(conf *RuntimeConfig, storage *Storage, sm *scs.SessionManager,
 	app.router.Get("/climber_no_photo.svg", fileServer.ServeHTTP)
 	app.router.Get("/vklogo.svg", fileServer.ServeHTTP)
 
+	// images are available from the app whatever storage is used,
+	// local ones are served by the app itself
+	if local, ok := imageManager.(*LocalImageManager); ok {
+		app.router.Handle("/images/*", http.StripPrefix("/images", local.Handler()))
+	} else {
+		app.router.Handle("/images/*", http.StripPrefix("/images", ImagesHandler(imageManager, conf.SignedImageUrls)))
+	}
+
 	// Mount API and auth routes
 	app.router.Mount("/api", app.Api.router)
 	app.router.Mount("/auth", app.AuthServer.router)
@@ -97,25 +118,209 @@ func serveIndexHTML(w http.ResponseWriter, fsys fs.FS) {
 	w.Write(content)
 }
 
+func logCatalogDiff(msg string, diff *CatalogDiff) {
+	slog.Info(msg, "summits", diff.Summits,
+		"added", len(diff.Added), "removed", len(diff.Removed), "renamed", len(diff.Renamed),
+		"changed", len(diff.Changed), "climbsRewritten", len(diff.ClimbsRewritten),
+		"climbsArchived", len(diff.ClimbsArchived))
+}
+
+// generateSummitPreviews makes missing previews of summit images
+// in background, so that server starts without waiting for them
+func generateSummitPreviews(storage *Storage, im ImageManager) {
+	go func() {
+		n, err := GenerateSummitPreviews(context.Background(), storage, im)
+		if err != nil {
+			slog.Error("Failed to generate summit image previews", "error", err)
+			return
+		}
+		if n > 0 {
+			slog.Info("Summit image previews generated", "images", n)
+		}
+	}()
+}
+
+// renderLegacyClimbPhotos makes renditions of photos uploaded before
+// they were introduced, in background
+func renderLegacyClimbPhotos(storage *Storage, im ImageManager) {
+	go func() {
+		n, err := RenderLegacyClimbPhotos(context.Background(), storage, im)
+		if err != nil {
+			slog.Error("Failed to render legacy climb photos", "error", err)
+			return
+		}
+		if n > 0 {
+			slog.Info("Legacy climb photos rendered", "photos", n)
+		}
+	}()
+}
+
+// cleanupImagesDaily runs CleanupImages at start and then once a day
+func cleanupImagesDaily(storage *Storage, im ImageManager) {
+	go func() {
+		for {
+			deleted, err := CleanupImages(context.Background(), storage, im)
+			if err != nil {
+				slog.Error("Failed to clean up images", "error", err)
+			} else if deleted > 0 {
+				slog.Info("Stale images deleted", "images", deleted)
+			}
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
+
+// reloadSummitsOnSignal reloads summits catalog from dataDir on SIGHUP
+// while the server keeps serving requests. If reload fails,
+// previously loaded catalog stays in place.
+func reloadSummitsOnSignal(storage *Storage, dataDir string, afterReload func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	go func() {
+		for range sigs {
+			slog.Info("Reloading summits data...")
+			diff, err := storage.LoadSummits(dataDir)
+			if err != nil {
+				slog.Error("Failed to reload summits, keeping previous data", "error", err)
+				continue
+			}
+			logCatalogDiff("Summits data reloaded", diff)
+			afterReload()
+			// catalog changes may complete or break summit sets
+			if err := storage.UpdateAllAchievements(); err != nil {
+				slog.Error("Failed to update achievements", "error", err)
+			}
+		}
+	}()
+}
+
+// getenv returns value of environment variable or fallback if it is not set
+func getenv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// newImageManager creates image storage chosen by IMAGE_STORAGE:
+// S3 bucket (default) or local directory IMAGES_DIR
+func newImageManager() (ImageManager, error) {
+	switch imageStorage := os.Getenv("IMAGE_STORAGE"); imageStorage {
+	case "", "s3":
+		return NewS3ImageManager(context.Background(), S3Config{
+			Endpoint:  getenv("S3_ENDPOINT", DefaultS3Endpoint),
+			Region:    getenv("S3_REGION", DefaultS3Region),
+			Bucket:    getenv("S3_BUCKET", DefaultS3Bucket),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PublicUrl: os.Getenv("S3_PUBLIC_URL"),
+		})
+	case "local":
+		return NewLocalImageManager(getenv("IMAGES_DIR", "images"))
+	default:
+		return nil, fmt.Errorf("unknown IMAGE_STORAGE %q, s3 or local expected", imageStorage)
+	}
+}
+
+// runValidate checks summits catalog without starting the server
+// and prints all problems found. Returns process exit code.
+func runValidate(dataDir string) int {
+	// keep report readable: migrations of the throwaway database are not interesting
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	issues, err := ValidateCatalog(path.Clean(dataDir))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Validation failed: %v\n", err)
+		return 2
+	}
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+	if len(issues) > 0 {
+		fmt.Printf("%d problem(s) found\n", len(issues))
+		return 1
+	}
+	fmt.Println("Catalog is valid")
+	return 0
+}
+
+// runArchiveOrphans loads summits catalog moving climbs referencing
+// removed summits to archive. Climbs with tracks, photos or partners
+// are archived only with dropAttachments. Returns process exit code.
+func runArchiveOrphans(dataDir, dbPath string, dropAttachments bool) int {
+	db, err := NewDatabase(path.Clean(dbPath))
+	if err != nil {
+		slog.Error("Failed to connect to DB", "error", err)
+		return 1
+	}
+	defer db.Close()
+	if err = Migrate(db); err != nil {
+		slog.Error("Migrations failed", "error", err)
+		return 1
+	}
+	diff, err := NewStorage(db).LoadSummitsArchivingOrphans(path.Clean(dataDir), dropAttachments)
+	if err != nil {
+		slog.Error("Failed to load summits", "error", err)
+		var attachmentsErr *OrphanedAttachmentsError
+		if errors.As(err, &attachmentsErr) {
+			slog.Error("Run `archive-orphans --drop-attachments` to archive these climbs anyway")
+		}
+		return 1
+	}
+	logCatalogDiff("Summits data loaded", diff)
+	for _, o := range diff.ClimbsArchived {
+		slog.Info("Climbs archived", "summitId", o.SummitId, "climbs", o.Climbs)
+	}
+	return 0
+}
+
 func main() {
 	// Initialize logger first
 	initLogger()
 
+	if len(os.Args) == 3 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2]))
+	}
+	if len(os.Args) == 4 && os.Args[1] == "archive-orphans" {
+		os.Exit(runArchiveOrphans(os.Args[2], os.Args[3], false))
+	}
+	if len(os.Args) == 5 && os.Args[1] == "archive-orphans" && os.Args[2] == "--drop-attachments" {
+		os.Exit(runArchiveOrphans(os.Args[3], os.Args[4], true))
+	}
+
 	if len(os.Args) != 3 {
 		fmt.Println("Usage: api <datadir> <db_path>")
+		fmt.Println("       api validate <datadir>")
+		fmt.Println("       api archive-orphans [--drop-attachments] <datadir> <db_path>")
 		os.Exit(1)
 	}
 
 	conf := &RuntimeConfig{
 		Datadir:      path.Clean(os.Args[1]),
 		ItemsPerPage: 20,
+		TrackRadius:  DefaultTrackRadius,
+		BaseUrl:      os.Getenv("BASE_URL"),
+	}
+	if conf.BaseUrl == "" {
+		slog.Warn("BASE_URL is not set, Atom feeds are disabled")
+	}
+	if radius := os.Getenv("TRACK_RADIUS"); radius != "" {
+		var err error
+		conf.TrackRadius, err = strconv.ParseFloat(radius, 64)
+		if err != nil || conf.TrackRadius <= 0 {
+			slog.Error("Invalid TRACK_RADIUS value", "value", radius)
+			os.Exit(1)
+		}
+	}
+	if private := os.Getenv("IMAGES_PRIVATE"); private != "" {
+		var err error
+		conf.SignedImageUrls, err = strconv.ParseBool(private)
+		if err != nil {
+			slog.Error("Invalid IMAGES_PRIVATE value", "value", private)
+			os.Exit(1)
+		}
 	}
 
-	imageManager, err := NewS3ImageManager(
-		os.Getenv("S3_ACCESS_KEY"),
-		os.Getenv("S3_SECRET_KEY"),
-		S3_ENDPOINT,
-		context.Background())
+	imageManager, err := newImageManager()
 	if err != nil {
 		slog.Error("Failed to create image manager", "error", err)
 		os.Exit(1)
@@ -138,12 +343,29 @@ func main() {
 	storage := NewStorage(db)
 
 	slog.Info("Loading summits data to database...")
-	err = storage.LoadSummits(conf.Datadir)
+	diff, err := storage.LoadSummits(conf.Datadir)
 	if err != nil {
 		slog.Error("Failed to load summits", "error", err)
+		var orphansErr *OrphanedClimbsError
+		if errors.As(err, &orphansErr) {
+			slog.Error("Run `archive-orphans` command to load summits and archive orphaned climbs")
+		}
 		os.Exit(1)
 	}
-	slog.Info("Summits data loaded")
+	logCatalogDiff("Summits data loaded", diff)
+	if err := storage.UpdateAllAchievements(); err != nil {
+		slog.Error("Failed to update achievements", "error", err)
+	}
+
+	previews := func() {
+		generateSummitPreviews(storage, imageManager)
+	}
+	previews()
+
+	renderLegacyClimbPhotos(storage, imageManager)
+
+	reloadSummitsOnSignal(storage, conf.Datadir, previews)
+	cleanupImagesDaily(storage, imageManager)
 
 	sm := scs.New()
 	sm.Store = sqlite3store.New(db)
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"path"
 	"strconv"
@@ -102,6 +103,34 @@ func (id *InexactDate) Parse(date string) error {
 	return nil
 }
 
+// Validate checks date which did not come from Parse,
+// e.g. decoded from JSON, by the same rules as Parse
+func (id InexactDate) Validate() error {
+	var parsed InexactDate
+	if err := parsed.Parse(id.String()); err != nil {
+		return err
+	}
+	if parsed != id {
+		return fmt.Errorf("invalid inexact date: year %d, month %d, day %d", id.Year, id.Month, id.Day)
+	}
+	return nil
+}
+
+// String formats date the same way Parse accepts it: DD.MM.YYYY,
+// MM.YYYY, YYYY or empty string for unknown date
+func (id InexactDate) String() string {
+	switch {
+	case id.Year == 0:
+		return ""
+	case id.Month == 0:
+		return fmt.Sprintf("%d", id.Year)
+	case id.Day == 0:
+		return fmt.Sprintf("%02d.%d", id.Month, id.Year)
+	default:
+		return fmt.Sprintf("%02d.%02d.%d", id.Day, id.Month, id.Year)
+	}
+}
+
 type Ridge struct {
 	Id    string `json:"id"`
 	Name  string `json:"name"`
@@ -115,8 +144,11 @@ type SummitImage struct {
 }
 
 type ClimbData struct {
-	Date    InexactDate `json:"date"`
-	Comment string      `json:"comment"`
+	Date          InexactDate `json:"date"`
+	Comment       string      `json:"comment"`
+	TrackVerified bool        `json:"track_verified,omitempty"`
+	// Photos are of all user's ascents of the summit
+	Photos []ClimbPhoto `json:"photos,omitempty"`
 }
 
 type Summit struct {
@@ -131,6 +163,14 @@ type Summit struct {
 	Ridge          *Ridge        `json:"ridge"`
 	Images         []SummitImage `json:"images"`
 	ClimbData      *ClimbData    `json:"climb_data"`
+	// LatestClimb and ClimbsNum are set for repeated ascents,
+	// ClimbData holds the first one then
+	LatestClimb *ClimbData `json:"latest_climb,omitempty"`
+	ClimbsNum   int        `json:"climbs_num,omitempty"`
+	// Achievements are badges earned with ascents of this summit
+	Achievements []SummitAchievement `json:"achievements,omitempty"`
+	// FollowedClimbers is the number of users followed by the current one who climbed the summit
+	FollowedClimbers int `json:"followed_climbers,omitempty"`
 	// LegacyIds содержит старые идентификаторы вершины (использовались ранее в URL).
 	// Подгружается из YAML, но не экспортируется в публичное API JSON, чтобы не ломать клиентов и тесты.
 	LegacyIds []string `yaml:"legacy_ids" json:"-"`
@@ -165,7 +205,10 @@ type SummitsTableItem struct {
 }
 
 type SummitsTable struct {
-	Summits []SummitsTableItem `json:"summits"`
+	Summits    []SummitsTableItem `json:"summits"`
+	Total      int                `json:"total"`
+	Page       int                `json:"page,omitempty"`
+	TotalPages int                `json:"total_pages,omitempty"`
 }
 
 type TopItem struct {
@@ -173,7 +216,9 @@ type TopItem struct {
 	UserName  string `json:"user_name"`
 	UserImage string `json:"user_image"`
 	ClimbsNum int    `json:"climbs_num"`
-	Place     int    `json:"place"`
+	// Score is set for scoring other than by number of summits
+	Score float64 `json:"score,omitempty"`
+	Place int     `json:"place"`
 }
 
 type Top struct {
@@ -181,6 +226,9 @@ type Top struct {
 	Page         int       `json:"page"`
 	TotalPages   int       `json:"total_pages"`
 	TotalSummits int       `json:"total_summits"`
+	// Me is the current user's position, even if it is not on the page
+	Me     *TopItem `json:"me,omitempty"`
+	MyPage int      `json:"my_page,omitempty"`
 }
 
 type User struct {
@@ -190,6 +238,7 @@ type User struct {
 	Name       string `json:"name"`
 	ImageS     string `json:"image_s"`
 	ImageM     string `json:"image_m"`
+	ImageL     string `json:"image_l"`
 	SocialLink string `json:"social_link"`
 }
 
@@ -199,6 +248,10 @@ type SummitClimb struct {
 	UserImage string      `json:"user_image"`
 	Date      InexactDate `json:"date"`
 	Comment   string      `json:"comment"`
+	// GroupId is shared by people who climbed together, such climbs go one after another
+	GroupId int64 `json:"group_id,omitempty"`
+	// Photos are of all user's ascents of the summit
+	Photos []ClimbPhoto `json:"photos,omitempty"`
 }
 
 type Storage struct {
@@ -218,6 +271,9 @@ func (s *Storage) LoadSummitImages(images []SummitImage, summitId string, tx *sq
 	}
 	defer imageStmt.Close()
 	for _, img := range images {
+		if img.PreviewUrl == "" {
+			img.PreviewUrl = summitPreviewKey(img.Url)
+		}
 		_, err = imageStmt.Exec(img.Url, img.PreviewUrl, summitId, img.Comment)
 		if err != nil {
 			return fmt.Errorf("failed to load image %s: %v", img.Url, err)
@@ -299,12 +355,35 @@ func (s *Storage) LoadRidge(dir string, ridgeId string, tx *sql.Tx) error {
 	return nil
 }
 
-func (s *Storage) LoadSummits(dataDir string) error {
+// LoadSummits replaces summits catalog with the data from dataDir.
+// Everything is done in one transaction, so in case of error
+// the previously loaded catalog stays in place. Non-empty changes
+// are recorded into catalog_revisions table.
+// Loading fails with OrphanedClimbsError if some climbs reference
+// summits missing in the new data (and not mapped with legacy ids).
+func (s *Storage) LoadSummits(dataDir string) (*CatalogDiff, error) {
+	return s.loadSummits(dataDir, false, false)
+}
+
+// LoadSummitsArchivingOrphans works like LoadSummits, but instead of failing
+// moves climbs referencing missing summits to climbs_archived table.
+// Archive keeps date and comment only, so unless dropAttachments is set
+// loading fails with OrphanedAttachmentsError if some of these climbs
+// have tracks, photos or partners.
+func (s *Storage) LoadSummitsArchivingOrphans(dataDir string, dropAttachments bool) (*CatalogDiff, error) {
+	return s.loadSummits(dataDir, true, dropAttachments)
+}
+
+func (s *Storage) loadSummits(dataDir string, archiveOrphans, dropAttachments bool) (*CatalogDiff, error) {
 	tx, err := s.db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer tx.Rollback()
+	before, err := takeCatalogSnapshot(tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch current summits: %v", err)
+	}
 	cleanupQueries := []string{
 		"DELETE FROM summit_ids_legacy",
 		"DELETE FROM summit_images",
@@ -314,18 +393,18 @@ func (s *Storage) LoadSummits(dataDir string) error {
 	for _, sql := range cleanupQueries {
 		_, err = tx.Exec(sql)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 	ridgeStmt, err := tx.Prepare("INSERT INTO ridges VALUES (?, ?, ?)")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer ridgeStmt.Close()
 
 	ridgeDirs, err := os.ReadDir(dataDir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	for _, ridgeDir := range ridgeDirs {
 		if !ridgeDir.IsDir() {
@@ -338,41 +417,84 @@ func (s *Storage) LoadSummits(dataDir string) error {
 		ridgePath := path.Join(dataDir, ridgeId)
 		ridgeData, err := os.ReadFile(path.Join(ridgePath, "_meta.yaml"))
 		if err != nil {
-			return err
+			return nil, err
 		}
 		var ridge Ridge
 		if err := yaml.Unmarshal(ridgeData, &ridge); err != nil {
-			return err
+			return nil, err
 		}
 		ridge.Id = ridgeId
 		_, err = ridgeStmt.Exec(ridge.Id, ridge.Name, ridge.Color)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		if err = s.LoadRidge(ridgePath, ridge.Id, tx); err != nil {
-			return err
+			return nil, err
 		}
 	}
+	climbsRewritten, err := fetchLegacyClimbs(tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch climbs with legacy summit ids: %v", err)
+	}
 	// After loading summits and legacy mappings, update climbs referencing legacy ids
 	_, err = tx.Exec(`UPDATE climbs SET summit_id = (
 			SELECT summit_id FROM summit_ids_legacy sil WHERE sil.legacy_id = climbs.summit_id
 		) WHERE summit_id IN (SELECT legacy_id FROM summit_ids_legacy)`)
 	if err != nil {
-		return fmt.Errorf("failed to update climbs with legacy summit ids: %v", err)
+		return nil, fmt.Errorf("failed to update climbs with legacy summit ids: %v", err)
 	}
 	// Дополнительная страховка: проверяем что нет пересечения legacy_id с основными id уже в БД
 	var conflictCount int
 	if err := tx.QueryRow(`SELECT COUNT(*) FROM summits INNER JOIN summit_ids_legacy sil ON summits.id = sil.legacy_id`).Scan(&conflictCount); err != nil {
-		return fmt.Errorf("failed to check legacy/main id conflicts: %v", err)
+		return nil, fmt.Errorf("failed to check legacy/main id conflicts: %v", err)
 	}
 	if conflictCount > 0 {
-		return fmt.Errorf("conflict: %d legacy ids overlap with main summit ids", conflictCount)
+		return nil, fmt.Errorf("conflict: %d legacy ids overlap with main summit ids", conflictCount)
+	}
+	after, err := takeCatalogSnapshot(tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch loaded summits: %v", err)
+	}
+	if err := buildSearchIndex(tx); err != nil {
+		return nil, fmt.Errorf("failed to build search index: %v", err)
+	}
+	if err := buildGridIndex(tx); err != nil {
+		return nil, fmt.Errorf("failed to build grid index: %v", err)
+	}
+	orphans, err := fetchOrphanedClimbs(tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check orphaned climbs: %v", err)
+	}
+	if len(orphans) > 0 {
+		if !archiveOrphans {
+			return nil, &OrphanedClimbsError{orphans}
+		}
+		if !dropAttachments {
+			attached, err := fetchOrphanedAttachedClimbs(tx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check orphaned climbs attachments: %v", err)
+			}
+			if len(attached) > 0 {
+				return nil, &OrphanedAttachmentsError{attached}
+			}
+		}
+		if err := archiveOrphanedClimbs(tx); err != nil {
+			return nil, fmt.Errorf("failed to archive orphaned climbs: %v", err)
+		}
+	}
+	diff := diffCatalogSnapshots(before, after)
+	diff.ClimbsRewritten = climbsRewritten
+	diff.ClimbsArchived = orphans
+	if !diff.Empty() {
+		if err := saveCatalogRevision(tx, diff); err != nil {
+			return nil, fmt.Errorf("failed to save catalog revision: %v", err)
+		}
 	}
 	if err := tx.Commit(); err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return diff, nil
 }
 
 func (s *Storage) Count(query string, params ...any) (int, error) {
@@ -381,33 +503,137 @@ func (s *Storage) Count(query string, params ...any) (int, error) {
 	return count, err
 }
 
-func (s *Storage) FetchSummits(userId int64) (*SummitsTable, error) {
+// SummitsQuery holds filters, sorting and pagination for FetchSummits.
+// Nil bounds and zero Page mean no filtering and no pagination respectively.
+type SummitsQuery struct {
+	RidgeIds      []string
+	MinHeight     *int
+	MaxHeight     *int
+	MinProminence *int
+	MaxProminence *int
+	MinVisitors   *int
+	MaxVisitors   *int
+	Climbed       *bool
+	// Bbox is min lat, min lng, max lat, max lng
+	Bbox *[4]float64
+	// Sort is one of SummitsSortKeys, optionally prefixed
+	// with "-" for descending order
+	Sort    string
+	Page    int
+	PerPage int
+}
+
+// SummitsSortKeys maps sort keys accepted by FetchSummits to columns
+var SummitsSortKeys = map[string]string{
+	"id":         "id",
+	"height":     "height",
+	"prominence": "prominence",
+	"visitors":   "visitors",
+	"name":       "name",
+	"lat":        "lat",
+}
+
+func (q *SummitsQuery) where() (string, []any) {
+	conditions := []string{}
+	params := []any{}
+	if len(q.RidgeIds) > 0 {
+		conditions = append(conditions,
+			"ridge_id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(q.RidgeIds)), ", ")+")")
+		for _, r := range q.RidgeIds {
+			params = append(params, r)
+		}
+	}
+	for _, bound := range []struct {
+		cond  string
+		value *int
+	}{
+		{"height >= ?", q.MinHeight},
+		{"height <= ?", q.MaxHeight},
+		{"prominence >= ?", q.MinProminence},
+		{"prominence <= ?", q.MaxProminence},
+		{"visitors >= ?", q.MinVisitors},
+		{"visitors <= ?", q.MaxVisitors},
+	} {
+		if bound.value != nil {
+			conditions = append(conditions, bound.cond)
+			params = append(params, *bound.value)
+		}
+	}
+	if q.Climbed != nil {
+		conditions = append(conditions, "climbed = ?")
+		params = append(params, *q.Climbed)
+	}
+	if q.Bbox != nil {
+		conditions = append(conditions, "lat BETWEEN ? AND ? AND lng BETWEEN ? AND ?")
+		params = append(params, q.Bbox[0], q.Bbox[2], q.Bbox[1], q.Bbox[3])
+	}
+	if len(conditions) == 0 {
+		return "", params
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), params
+}
+
+func (q *SummitsQuery) orderBy() (string, error) {
+	if q.Sort == "" {
+		return " ORDER BY id", nil
+	}
+	key, direction := q.Sort, "ASC"
+	if strings.HasPrefix(key, "-") {
+		key, direction = key[1:], "DESC"
+	}
+	column, ok := SummitsSortKeys[key]
+	if !ok {
+		return "", fmt.Errorf("unknown sort key: %s", q.Sort)
+	}
+	return fmt.Sprintf(" ORDER BY %s %s NULLS LAST, id", column, direction), nil
+}
+
+// FetchSummits returns summits table for user (0 for anonymous) filtered
+// and sorted according to query. Rank and is_main flag are computed
+// over the whole catalog regardless of filters.
+func (s *Storage) FetchSummits(userId int64, q SummitsQuery) (*SummitsTable, error) {
 	summits := make([]SummitsTableItem, 0)
-	query := `SELECT s.id, s.name, s.height, s.prominence, s.lat, s.lng, r.name, r.id, r.color, COUNT(c.user_id), 
-			ROW_NUMBER() OVER (ORDER BY s.height DESC) as rank,
-			EXISTS(
-				SELECT * FROM 
-					(
-						SELECT ridge_id, max(height) AS maxheight
-						FROM summits
-						WHERE ridge_id=s.ridge_id GROUP BY ridge_id
-					) as smtsg
-					INNER JOIN summits smts
-						ON smtsg.ridge_id=smts.ridge_id
-						AND smts.height=smtsg.maxheight 
-					WHERE id=s.id
-			) AS is_main,
-			EXISTS(
-				SELECT * FROM climbs
-				WHERE summit_id=s.id AND user_id = ?
-			) as climbed
-		FROM ridges r 
-			INNER JOIN summits s ON r.id = s.ridge_id
-			LEFT JOIN climbs c ON c.summit_id = s.id
-		GROUP BY s.id, s.name, s.height, s.prominence, s.lat, r.name
-		ORDER BY s.id
+	table := `WITH visitors AS (
+			SELECT summit_id, COUNT(DISTINCT user_id) AS cnt FROM climbs GROUP BY summit_id
+		), summits_table AS (
+			SELECT s.id, s.name, s.height, s.prominence, s.lat, s.lng,
+				r.name AS ridge_name, r.id AS ridge_id, r.color,
+				COALESCE(v.cnt, 0) AS visitors,
+				ROW_NUMBER() OVER (ORDER BY s.height DESC) AS rank,
+				s.height = MAX(s.height) OVER (PARTITION BY s.ridge_id) AS is_main,
+				EXISTS(
+					SELECT * FROM climbs
+					WHERE summit_id=s.id AND user_id = ?
+				) AS climbed
+			FROM ridges r
+				INNER JOIN summits s ON r.id = s.ridge_id
+				LEFT JOIN visitors v ON v.summit_id = s.id
+		)
 	`
-	rows, err := s.db.Query(query, userId)
+	where, whereParams := q.where()
+	orderBy, err := q.orderBy()
+	if err != nil {
+		return nil, err
+	}
+	params := append([]any{userId}, whereParams...)
+
+	var total int
+	err = s.db.QueryRow(table+"SELECT COUNT(*) FROM summits_table"+where, params...).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+
+	query := table + `SELECT id, name, height, prominence, lat, lng, ridge_name, ridge_id, color,
+			visitors, rank, is_main, climbed
+		FROM summits_table` + where + orderBy
+	result := &SummitsTable{Total: total}
+	if q.Page > 0 {
+		query += " LIMIT ? OFFSET ?"
+		params = append(params, q.PerPage, (q.Page-1)*q.PerPage)
+		result.Page = q.Page
+		result.TotalPages = (total + q.PerPage - 1) / q.PerPage
+	}
+	rows, err := s.db.Query(query, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -424,7 +650,11 @@ func (s *Storage) FetchSummits(userId int64) (*SummitsTable, error) {
 		}
 		summits = append(summits, s)
 	}
-	return &SummitsTable{summits}, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	result.Summits = summits
+	return result, nil
 }
 
 func (s *Storage) FetchSummitImages(summit_id string) ([]SummitImage, error) {
@@ -448,21 +678,40 @@ func (s *Storage) FetchSummitImages(summit_id string) ([]SummitImage, error) {
 
 func (s *Storage) FetchSummitClimbs(summitId string, page, itemsPerPage int) ([]SummitClimb, int, error) {
 	totalClimbs := 0
-	countQuery := `SELECT COUNT(*) FROM climbs WHERE summit_id = ?`
+	countQuery := `SELECT COUNT(DISTINCT user_id) FROM climbs WHERE summit_id = ?`
 	err := s.db.QueryRow(countQuery, summitId).Scan(&totalClimbs)
 	if err != nil {
 		return nil, 0, err
 	}
 	offset := (page - 1) * itemsPerPage
+	// a group is the listed first climbs joined by accepted invitations,
+	// also through other members, keyed by the smallest climb id in it.
+	// Joint repeated ascents do not group climbs listed by other dates.
 	query := `
-		SELECT c.user_id, u.name, ui.url, c.year, c.month, c.day, c.comment 
-		FROM climbs c
+		WITH RECURSIVE
+			listed(id) AS (SELECT id FROM first_climbs WHERE summit_id = ?),
+			joint(a, b) AS (
+				SELECT p.climb_id, p.partner_climb_id FROM climb_partners p
+					INNER JOIN listed la ON la.id = p.climb_id
+					INNER JOIN listed lb ON lb.id = p.partner_climb_id
+				WHERE p.status = 'accepted'
+			),
+			edges(a, b) AS (SELECT a, b FROM joint UNION ALL SELECT b, a FROM joint),
+			reach(id, root) AS (
+				SELECT a, a FROM edges
+				UNION
+				SELECT e.b, r.root FROM reach r INNER JOIN edges e ON e.a = r.id
+			),
+			climb_groups(id, group_id) AS (SELECT id, MIN(root) FROM reach GROUP BY id)
+		SELECT c.user_id, u.name, ui.url, c.year, c.month, c.day, c.comment, g.group_id
+		FROM first_climbs c
 		INNER JOIN users u ON c.user_id = u.id
 		LEFT JOIN user_images ui ON u.id = ui.user_id AND ui.size = 'S'
+		LEFT JOIN climb_groups g ON g.id = c.id
 		WHERE c.summit_id = ?
-		ORDER BY year ASC NULLS LAST, month ASC NULLS LAST, day ASC NULLS LAST
+		ORDER BY year ASC NULLS LAST, month ASC NULLS LAST, day ASC NULLS LAST, g.group_id ASC NULLS LAST
 		LIMIT ? OFFSET ?`
-	rows, err := s.db.Query(query, summitId, itemsPerPage, offset)
+	rows, err := s.db.Query(query, summitId, summitId, itemsPerPage, offset)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -472,7 +721,8 @@ func (s *Storage) FetchSummitClimbs(summitId string, page, itemsPerPage int) ([]
 		var climb SummitClimb
 		var year, month, day sql.NullInt64
 		var url sql.NullString
-		err := rows.Scan(&climb.UserId, &climb.UserName, &url, &year, &month, &day, &climb.Comment)
+		var groupId sql.NullInt64
+		err := rows.Scan(&climb.UserId, &climb.UserName, &url, &year, &month, &day, &climb.Comment, &groupId)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -489,9 +739,20 @@ func (s *Storage) FetchSummitClimbs(summitId string, page, itemsPerPage int) ([]
 		if day.Valid {
 			climb.Date.Day = day.Int64
 		}
+		climb.GroupId = groupId.Int64
 
 		climbs = append(climbs, climb)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	photos, err := s.FetchSummitPhotos(summitId)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range climbs {
+		climbs[i].Photos = photos[climbs[i].UserId]
+	}
 	return climbs, totalClimbs, nil
 }
 
@@ -538,103 +799,248 @@ func (s *Storage) FetchSummit(summitId string, userId int64) (*Summit, error) {
 		return nil, err
 	}
 	if userId != 0 {
-		climbQuery := `SELECT year, month, day, comment FROM climbs WHERE summit_id = ? AND user_id = ?`
-		var year, month, day sql.NullInt64
-		var comment sql.NullString
-		err := s.db.QueryRow(climbQuery, summit.Id, userId).Scan(&year, &month, &day, &comment)
-		switch err {
-		case sql.ErrNoRows:
-			summit.ClimbData = nil
-		case nil:
-			summit.ClimbData = &ClimbData{}
-			if year.Valid {
-				summit.ClimbData.Date.Year = year.Int64
-			}
-			if month.Valid {
-				summit.ClimbData.Date.Month = month.Int64
-			}
-			if day.Valid {
-				summit.ClimbData.Date.Day = day.Int64
-			}
-			if comment.Valid {
-				summit.ClimbData.Comment = comment.String
-			}
-		default:
+		ascents, err := s.FetchUserAscents(userId, summit.Id)
+		if err != nil {
 			return nil, err
 		}
+		if len(ascents) > 0 {
+			first, latest := ascents[0], ascents[len(ascents)-1]
+			summit.ClimbData = &ClimbData{Date: first.Date, Comment: first.Comment, TrackVerified: first.TrackVerified}
+			if len(ascents) > 1 {
+				summit.LatestClimb = &ClimbData{Date: latest.Date, Comment: latest.Comment, TrackVerified: latest.TrackVerified}
+				summit.ClimbsNum = len(ascents)
+			}
+		}
 	}
 
 	return &summit, nil
 }
 
-func (s *Storage) fetchTopItems(year, page, itemsPerPage int) ([]TopItem, int, error) {
-	whereClause := ""
-	params := []any{}
-	if year != 0 {
-		whereClause = " WHERE year = ?"
-		params = append(params, year)
+// Top scoring methods, TopScoreClimbs is the default
+const (
+	TopScoreClimbs       = "climbs"
+	TopScoreHeight       = "height"
+	TopScoreProminence   = "prominence"
+	TopScoreRidgePercent = "ridge_percent"
+)
+
+// Top ranking methods: with competition ranking users after a tie skip
+// places (1, 1, 3), with dense ranking they do not (1, 1, 2)
+const (
+	TopRankCompetition = "competition"
+	TopRankDense       = "dense"
+)
+
+// Seasons are sets of months, climbs without month never fall into one.
+// Months are listed from the season start, a season crossing new year
+// belongs to the year it starts in.
+var TopSeasons = map[string][]int{
+	"summer": {5, 6, 7, 8, 9, 10},
+	"winter": {11, 12, 1, 2, 3, 4},
+}
+
+// TopQuery holds filters and scoring for FetchTop. Zero values mean no filtering.
+type TopQuery struct {
+	RidgeId string
+	Year    int
+	// From and To bound climb dates inclusively, climbs with
+	// inexact dates count only if they fit the range entirely
+	From, To *time.Time
+	Season   string
+	Score    string
+	Rank     string
+}
+
+// dateKey packs date into integer comparable the same way as climb_key
+func dateKey(year, month, day int) int {
+	return day | month<<8 | year<<16
+}
+
+// monthsIn returns SQL condition on climb month being one of months
+func monthsIn(months []int, params *[]any) string {
+	placeholders := make([]string, len(months))
+	for i, m := range months {
+		placeholders[i] = "?"
+		*params = append(*params, m)
 	}
-	queryCount := `SELECT COUNT(DISTINCT user_id) 
-        FROM users INNER JOIN climbs ON users.id=climbs.user_id`
-	queryCount += whereClause
+	return "c.month IN (" + strings.Join(placeholders, ", ") + ")"
+}
 
-	totalItems, err := s.Count(queryCount, params...)
-	if err != nil {
-		return nil, 0, err
+func (q TopQuery) where() (string, []any) {
+	conds := make([]string, 0)
+	params := make([]any, 0)
+	months, inSeason := TopSeasons[q.Season]
+	switch {
+	case inSeason && q.Year != 0:
+		// months after new year are of the next year
+		split := len(months)
+		for i := 1; i < len(months); i++ {
+			if months[i] < months[i-1] {
+				split = i
+				break
+			}
+		}
+		params = append(params, q.Year)
+		cond := "(c.year = ? AND " + monthsIn(months[:split], &params) + ")"
+		if split < len(months) {
+			params = append(params, q.Year+1)
+			cond = "(" + cond + " OR (c.year = ? AND " + monthsIn(months[split:], &params) + "))"
+		}
+		conds = append(conds, cond)
+	case inSeason:
+		conds = append(conds, monthsIn(months, &params))
+	case q.Year != 0:
+		conds = append(conds, "c.year = ?")
+		params = append(params, q.Year)
+	}
+	if q.RidgeId != "" {
+		conds = append(conds, "s.ridge_id = ?")
+		params = append(params, q.RidgeId)
+	}
+	if q.From != nil {
+		conds = append(conds, "c.year IS NOT NULL AND (coalesce(c.day, 1) | (coalesce(c.month, 1) << 8) | (c.year << 16)) >= ?")
+		params = append(params, dateKey(q.From.Year(), int(q.From.Month()), q.From.Day()))
+	}
+	if q.To != nil {
+		conds = append(conds, "c.year IS NOT NULL AND (coalesce(c.day, 31) | (coalesce(c.month, 12) << 8) | (c.year << 16)) <= ?")
+		params = append(params, dateKey(q.To.Year(), int(q.To.Month()), q.To.Day()))
+	}
+	if len(conds) == 0 {
+		return "", params
 	}
-	totalPages := totalItems/itemsPerPage + 1
+	return " WHERE " + strings.Join(conds, " AND "), params
+}
 
-	items := make([]TopItem, 0, itemsPerPage)
-	query := `SELECT users.id, users.name, ui.url, count(*) as climbs, 
-            MAX(coalesce(day, 32) | (coalesce(month, 13) << 8) | (coalesce(year, 2100) << 16)) 
-                AS last_climb 
-        FROM users INNER JOIN climbs ON users.id=climbs.user_id 
-        LEFT JOIN user_images ui ON users.id = ui.user_id AND ui.size = 'S'`
-	query += whereClause
-	query += " GROUP BY users.id, users.name ORDER BY climbs DESC, last_climb ASC LIMIT ? OFFSET ?"
-	offset := (page - 1) * itemsPerPage
-	params = append(params, itemsPerPage, offset)
+// ranking returns query ranking all users matching q with window functions.
+// Users with equal scores share the place, the order within a tie
+// is by the date when the last new summit was reached.
+func (q TopQuery) ranking() (string, []any) {
+	whereClause, params := q.where()
+	score := "count(*)"
+	switch q.Score {
+	case TopScoreHeight:
+		score = "SUM(s.height)"
+	case TopScoreProminence:
+		score = "SUM(s.prominence)"
+	}
+	rank := "RANK()"
+	if q.Rank == TopRankDense {
+		rank = "DENSE_RANK()"
+	}
+	query := `SELECT *,
+            ` + rank + ` OVER (ORDER BY score DESC) AS place,
+            ROW_NUMBER() OVER (ORDER BY score DESC, climbs DESC, last_climb ASC, id ASC) AS pos
+        FROM (
+            SELECT users.id, users.name, ui.url, count(*) as climbs, ` + score + ` AS score,
+                MAX(c.climb_key) AS last_climb 
+            FROM users INNER JOIN (
+                SELECT c.user_id, c.summit_id,
+                    MIN(coalesce(c.day, 32) | (coalesce(c.month, 13) << 8) | (coalesce(c.year, 2100) << 16)) AS climb_key
+                FROM climbs c INNER JOIN summits s ON s.id = c.summit_id` + whereClause + `
+                GROUP BY c.user_id, c.summit_id
+            ) c ON users.id=c.user_id 
+            INNER JOIN summits s ON s.id = c.summit_id
+            LEFT JOIN user_images ui ON users.id = ui.user_id AND ui.size = 'S'
+            GROUP BY users.id, users.name
+        )`
+	return query, params
+}
+
+// fetchTopItems returns ranked users at positions (from, to], and the user
+// with given id wherever placed. Users are ranked by number of distinct summits
+// climbed, repeated ascents do not count, or by the sum of their heights or prominences.
+func (s *Storage) fetchTopItems(q TopQuery, totalSummits int, userId int64, from, to int) ([]TopItem, *TopItem, int, error) {
+	ranking, params := q.ranking()
+	query := `SELECT id, name, url, climbs, score, place, pos FROM (` + ranking + `)
+        WHERE (pos > ? AND pos <= ?) OR id = ? ORDER BY pos`
+	params = append(params, from, to, userId)
 	rows, err := s.db.Query(query, params...)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, 0, err
 	}
 	defer rows.Close()
 
-	i := 0
+	items := make([]TopItem, 0, to-from)
+	var me *TopItem
+	var myPos int
 	for rows.Next() {
 		var ti TopItem
-		var lastClimb int
+		var pos int
+		var score float64
 		var imageUrl sql.NullString
-		err := rows.Scan(&ti.UserId, &ti.UserName, &imageUrl, &ti.ClimbsNum, &lastClimb)
+		err := rows.Scan(&ti.UserId, &ti.UserName, &imageUrl, &ti.ClimbsNum, &score, &ti.Place, &pos)
 		if err != nil {
-			return nil, 0, err
+			return nil, nil, 0, err
 		}
 		if imageUrl.Valid {
 			ti.UserImage = imageUrl.String
 		}
-		ti.Place = offset + i + 1
-		items = append(items, ti)
-		i++
+		switch q.Score {
+		case TopScoreHeight, TopScoreProminence:
+			ti.Score = score
+		case TopScoreRidgePercent:
+			if totalSummits > 0 {
+				ti.Score = math.Round(float64(ti.ClimbsNum)*1000/float64(totalSummits)) / 10
+			}
+		}
+		if int64(ti.UserId) == userId {
+			me, myPos = &ti, pos
+		}
+		if pos > from && pos <= to {
+			items = append(items, ti)
+		}
 	}
-	return items, totalPages, nil
+	return items, me, myPos, rows.Err()
 }
 
-func (s *Storage) FetchTop(year, page, itemsPerPage int) (*Top, error) {
+// FetchTop returns a page of the top. Page 0 means the page with the user
+// with given id, or the first one if the user is not in the top.
+func (s *Storage) FetchTop(q TopQuery, userId int64, page, itemsPerPage int) (*Top, error) {
 	var result Top
-	result.Page = page
 
-	totalSummits, err := s.Count("SELECT COUNT(*) FROM summits")
+	countQuery, countParams := "SELECT COUNT(*) FROM summits", []any{}
+	if q.RidgeId != "" {
+		countQuery += " WHERE ridge_id = ?"
+		countParams = append(countParams, q.RidgeId)
+	}
+	totalSummits, err := s.Count(countQuery, countParams...)
 	if err != nil {
 		return nil, err
 	}
 	result.TotalSummits = totalSummits
 
-	items, totalPages, err := s.fetchTopItems(year, page, itemsPerPage)
+	whereClause, params := q.where()
+	totalItems, err := s.Count(`SELECT COUNT(DISTINCT c.user_id)
+        FROM users INNER JOIN climbs c ON users.id=c.user_id
+        INNER JOIN summits s ON s.id = c.summit_id`+whereClause, params...)
+	if err != nil {
+		return nil, err
+	}
+	// an empty top still has a single empty page
+	result.TotalPages = max(1, (totalItems+itemsPerPage-1)/itemsPerPage)
+
+	if page == 0 {
+		_, me, myPos, err := s.fetchTopItems(q, totalSummits, userId, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		page = 1
+		if me != nil {
+			page = (myPos-1)/itemsPerPage + 1
+		}
+	}
+	result.Page = page
+
+	offset := (page - 1) * itemsPerPage
+	items, me, myPos, err := s.fetchTopItems(q, totalSummits, userId, offset, offset+itemsPerPage)
 	if err != nil {
 		return nil, err
 	}
 	result.Items = items
-	result.TotalPages = totalPages
+	if me != nil {
+		result.Me = me
+		result.MyPage = (myPos-1)/itemsPerPage + 1
+	}
 
 	return &result, nil
 }
@@ -681,6 +1087,10 @@ func (s *Storage) getUser(row *sql.Row) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
+	user.ImageL, err = s.GetUserImage(user.Id, "L")
+	if err != nil {
+		return nil, err
+	}
 	user.SocialLink = generateSocialLink(user.OauthId, user.Src)
 	return &user, nil
 }
@@ -720,31 +1130,249 @@ func (s *Storage) GetUserImage(userId int64, size string) (string, error) {
 	return img, nil
 }
 
-func (s *Storage) UpdateClimb(summitId string, userId int64, date InexactDate, comment string) error {
-	query := `INSERT INTO climbs (
-		user_id, summit_id, year, month, day, comment
-	) VALUES (?, ?, ?, ?, ?, ?)
-	ON CONFLICT (user_id, summit_id) 
-	DO UPDATE SET year=excluded.year, month=excluded.month, day=excluded.day, comment=excluded.comment
-	`
-	_, err := s.db.Exec(
-		query, userId, summitId,
-		toSqlNullInt64(date.Year), toSqlNullInt64(date.Month), toSqlNullInt64(date.Day), comment)
+// UpdateClimb registers user's climb of the summit. If the summit
+// is already climbed, the first ascent is updated instead,
+// use AddAscent to register a repeated one. Track is attached
+// unless it is nil. Id of the ascent is returned.
+func (s *Storage) UpdateClimb(summitId string, userId int64, date InexactDate, comment string, track *ClimbTrack) (int64, error) {
+	ascentId, oldYear, err := s.updateClimb(summitId, userId, date, comment, track)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	s.climbsChanged(userId, oldYear, date.Year)
+	return ascentId, nil
+}
+
+// updateClimb is UpdateClimb leaving achievements to the caller,
+// it also returns the year the climb was dated before
+func (s *Storage) updateClimb(summitId string, userId int64, date InexactDate, comment string, track *ClimbTrack) (int64, int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	var ascentId, oldYear int64
+	err = tx.QueryRow(`SELECT id FROM first_climbs WHERE summit_id = ? AND user_id = ?`,
+		summitId, userId).Scan(&ascentId)
+	if err == sql.ErrNoRows {
+		ascentId, err = addAscent(tx, summitId, userId, date, comment)
+	} else if err == nil {
+		oldYear, _, err = updateAscent(tx, ascentId, userId, date, comment)
+	}
+	if err == nil && track != nil {
+		err = saveClimbTrack(tx, ascentId, track)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return ascentId, oldYear, tx.Commit()
 }
 
+// DeleteClimb removes all user's ascents of the summit
 func (s *Storage) DeleteClimb(summitId string, userId int64) error {
-	query := `DELETE FROM climbs WHERE summit_id = ? AND user_id = ?`
-	_, err := s.db.Exec(query, summitId, userId)
+	query := `DELETE FROM climbs WHERE summit_id = ? AND user_id = ? RETURNING year`
+	rows, err := s.db.Query(query, summitId, userId)
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
+	years := make([]int64, 0)
+	for rows.Next() {
+		var year sql.NullInt64
+		if err := rows.Scan(&year); err != nil {
+			return err
+		}
+		years = append(years, year.Int64)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	s.climbsChanged(userId, years...)
 	return nil
 }
 
+type Ascent struct {
+	Id            int64       `json:"id"`
+	SummitId      string      `json:"summit_id"`
+	Date          InexactDate `json:"date"`
+	Comment       string      `json:"comment"`
+	HasTrack      bool        `json:"has_track"`
+	TrackVerified bool        `json:"track_verified"`
+}
+
+const ascentColumns = `c.id, c.summit_id, c.year, c.month, c.day, c.comment,
+	t.climb_id IS NOT NULL, COALESCE(t.verified, 0)`
+
+func scanAscent(row interface{ Scan(...any) error }) (*Ascent, error) {
+	var a Ascent
+	var year, month, day sql.NullInt64
+	var comment sql.NullString
+	err := row.Scan(&a.Id, &a.SummitId, &year, &month, &day, &comment, &a.HasTrack, &a.TrackVerified)
+	if err != nil {
+		return nil, err
+	}
+	a.Date.FromSQL(year, month, day)
+	a.Comment = comment.String
+	return &a, nil
+}
+
+// FetchUserAscents returns all user's ascents of the summit, earliest first
+func (s *Storage) FetchUserAscents(userId int64, summitId string) ([]Ascent, error) {
+	query := `SELECT ` + ascentColumns + `
+		FROM climbs c LEFT JOIN climb_tracks t ON t.climb_id = c.id
+		WHERE c.user_id = ? AND c.summit_id = ?
+		ORDER BY c.year ASC NULLS LAST, c.month ASC NULLS LAST, c.day ASC NULLS LAST, c.id ASC`
+	rows, err := s.db.Query(query, userId, summitId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ascents := make([]Ascent, 0)
+	for rows.Next() {
+		a, err := scanAscent(rows)
+		if err != nil {
+			return nil, err
+		}
+		ascents = append(ascents, *a)
+	}
+	return ascents, rows.Err()
+}
+
+// FetchAscent returns user's ascent by id, or nil if there is no such
+// ascent or it belongs to another user
+func (s *Storage) FetchAscent(ascentId, userId int64) (*Ascent, error) {
+	query := `SELECT ` + ascentColumns + `
+		FROM climbs c LEFT JOIN climb_tracks t ON t.climb_id = c.id
+		WHERE c.id = ? AND c.user_id = ?`
+	a, err := scanAscent(s.db.QueryRow(query, ascentId, userId))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return a, err
+}
+
+// AddAscent registers a repeated ascent of the summit with the track
+// unless it is nil
+func (s *Storage) AddAscent(summitId string, userId int64, date InexactDate, comment string, track *ClimbTrack) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	ascentId, err := addAscent(tx, summitId, userId, date, comment)
+	if err == nil && track != nil {
+		err = saveClimbTrack(tx, ascentId, track)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	s.climbsChanged(userId, date.Year)
+	return ascentId, nil
+}
+
+// addAscent inserts a climb with db or within a transaction
+func addAscent(db interface {
+	Exec(string, ...any) (sql.Result, error)
+}, summitId string, userId int64, date InexactDate, comment string) (int64, error) {
+	query := `INSERT INTO climbs (
+		user_id, summit_id, year, month, day, comment, created_at, updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	now := time.Now().UTC()
+	res, err := db.Exec(
+		query, userId, summitId,
+		toSqlNullInt64(date.Year), toSqlNullInt64(date.Month), toSqlNullInt64(date.Day), comment, now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// UpdateAscent changes date and comment of user's ascent and replaces
+// the track unless it is nil. False is returned if user has no such ascent.
+func (s *Storage) UpdateAscent(ascentId, userId int64, date InexactDate, comment string, track *ClimbTrack) (bool, error) {
+	oldYear, found, err := s.changeAscent(ascentId, userId, date, comment, track)
+	if err != nil || !found {
+		return false, err
+	}
+	s.climbsChanged(userId, oldYear, date.Year)
+	return true, nil
+}
+
+// changeAscent is UpdateAscent leaving achievements to the caller,
+// it also returns the year the ascent was dated before
+func (s *Storage) changeAscent(ascentId, userId int64, date InexactDate, comment string, track *ClimbTrack) (int64, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+	oldYear, found, err := updateAscent(tx, ascentId, userId, date, comment)
+	if err != nil || !found {
+		return 0, false, err
+	}
+	if track != nil {
+		if err := saveClimbTrack(tx, ascentId, track); err != nil {
+			return 0, false, err
+		}
+	}
+	return oldYear, true, tx.Commit()
+}
+
+// updateAscent changes the ascent within a transaction and returns
+// the year it was dated before
+func updateAscent(tx *sql.Tx, ascentId, userId int64, date InexactDate, comment string) (int64, bool, error) {
+	var oldYear, oldMonth, oldDay sql.NullInt64
+	err := tx.QueryRow(`SELECT year, month, day FROM climbs WHERE id = ? AND user_id = ?`, ascentId, userId).
+		Scan(&oldYear, &oldMonth, &oldDay)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	// climbs registered before timestamps get their creation time from the original date
+	now := time.Now().UTC()
+	created := now
+	var oldDate InexactDate
+	oldDate.FromSQL(oldYear, oldMonth, oldDay)
+	if oldDate.Year != 0 {
+		created = climbDateTime(oldDate)
+	}
+	year, month, day := toSqlNullInt64(date.Year), toSqlNullInt64(date.Month), toSqlNullInt64(date.Day)
+	// saving unchanged ascent is not an edit worth showing in the feed
+	query := `UPDATE climbs SET
+		updated_at = CASE WHEN year IS ? AND month IS ? AND day IS ? AND comment IS ? THEN updated_at ELSE ? END,
+		created_at = COALESCE(created_at, ?),
+		year = ?, month = ?, day = ?, comment = ?
+		WHERE id = ? AND user_id = ?`
+	_, err = tx.Exec(query, year, month, day, comment, now, created,
+		year, month, day, comment, ascentId, userId)
+	if err != nil {
+		return 0, false, err
+	}
+	return oldYear.Int64, true, nil
+}
+
+// DeleteAscent removes user's ascent.
+// False is returned if user has no such ascent.
+func (s *Storage) DeleteAscent(ascentId, userId int64) (bool, error) {
+	var year sql.NullInt64
+	err := s.db.QueryRow(`DELETE FROM climbs WHERE id = ? AND user_id = ? RETURNING year`,
+		ascentId, userId).Scan(&year)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s.climbsChanged(userId, year.Int64)
+	return true, nil
+}
+
 func (s *Storage) FetchUserMissingSummits(userId int64) ([]Summit, error) {
 	query := `select summits.id, summits.name, summits.height,
 					 ridges.id, ridges.name
@@ -774,10 +1402,12 @@ func (s *Storage) FetchUserMissingSummits(userId int64) ([]Summit, error) {
 func (s *Storage) FetchUserClimbs(userId int64) ([]Summit, error) {
 	query := `select summits.id, summits.name, summits.height,
 					 ridges.id, ridges.name, 
-					 climbs.year, climbs.month, climbs.day, climbs.comment
+					 climbs.year, climbs.month, climbs.day, climbs.comment,
+					 COALESCE(t.verified, 0)
 		from summits 
-			inner join climbs on summits.id = climbs.summit_id 
+			inner join first_climbs climbs on summits.id = climbs.summit_id 
 			inner join ridges on summits.ridge_id = ridges.id 
+			left join climb_tracks t on t.climb_id = climbs.id
 		where climbs.user_id = ?
 		order by year ASC NULLS LAST, month ASC NULLS LAST, day ASC NULLS LAST, summits.id ASC`
 	rows, err := s.db.Query(query, userId)
@@ -792,7 +1422,8 @@ func (s *Storage) FetchUserClimbs(userId int64) ([]Summit, error) {
 		var climbData ClimbData
 		var year, month, day sql.NullInt64
 		err := rows.Scan(
-			&summit.Id, &summit.Name, &summit.Height, &ridge.Id, &ridge.Name, &year, &month, &day, &climbData.Comment)
+			&summit.Id, &summit.Name, &summit.Height, &ridge.Id, &ridge.Name, &year, &month, &day, &climbData.Comment,
+			&climbData.TrackVerified)
 
 		if err != nil {
 			return nil, err
@@ -802,9 +1433,54 @@ func (s *Storage) FetchUserClimbs(userId int64) ([]Summit, error) {
 		summit.Ridge = &ridge
 		climbs = append(climbs, summit)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	photos, err := s.FetchUserPhotos(userId)
+	if err != nil {
+		return nil, err
+	}
+	for i := range climbs {
+		climbs[i].ClimbData.Photos = photos[climbs[i].Id]
+	}
 	return climbs, nil
 }
 
+type ArchivedClimb struct {
+	SummitId   string      `json:"summit_id"`
+	Date       InexactDate `json:"date"`
+	Comment    string      `json:"comment"`
+	ArchivedAt time.Time   `json:"archived_at"`
+}
+
+// FetchUserArchivedClimbs returns user's climbs which summits
+// were removed from the catalog
+func (s *Storage) FetchUserArchivedClimbs(userId int64) ([]ArchivedClimb, error) {
+	query := `SELECT summit_id, year, month, day, comment, archived_at
+		FROM climbs_archived
+		WHERE user_id = ?
+		ORDER BY year ASC NULLS LAST, month ASC NULLS LAST, day ASC NULLS LAST, summit_id ASC`
+	rows, err := s.db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	climbs := make([]ArchivedClimb, 0)
+	for rows.Next() {
+		var climb ArchivedClimb
+		var year, month, day sql.NullInt64
+		var comment sql.NullString
+		err := rows.Scan(&climb.SummitId, &year, &month, &day, &comment, &climb.ArchivedAt)
+		if err != nil {
+			return nil, err
+		}
+		climb.Date.FromSQL(year, month, day)
+		climb.Comment = comment.String
+		climbs = append(climbs, climb)
+	}
+	return climbs, rows.Err()
+}
+
 func (s *Storage) CountSummits() (int, error) {
 	query := "select count(*) from summits"
 	row := s.db.QueryRow(query)
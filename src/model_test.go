@@ -1,8 +1,15 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 /*
@@ -24,7 +31,7 @@ func TestLoadSummits(t *testing.T) {
 		db := MockDatabase(t)
 		defer db.Close()
 		storage := NewStorage(db)
-		err := storage.LoadSummits(datadir)
+		_, err := storage.LoadSummits(datadir)
 		if err == nil {
 			t.Fatalf("Error expected to be non-nil for %s", datadir)
 		}
@@ -41,6 +48,153 @@ func TestLoadSummits(t *testing.T) {
 	}
 }
 
+func TestLoadSummitsReload(t *testing.T) {
+	db := MockDatabase(t)
+	storage := NewStorage(db)
+
+	diff, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+	assert.Equal(t, &CatalogDiff{
+		Summits: 5,
+		Added:   []string{"1021", "kirel", "kurkak", "malinovaja", "stolby"},
+		LegacyRemaps: []CatalogLegacyRemap{
+			{"1026", "stolby"},
+			{"1026-1", "stolby"},
+		},
+		ClimbsRewritten: []CatalogClimbsRewrite{{"1026", "stolby", 1}},
+	}, diff)
+
+	// loading the same data again changes nothing
+	diff, err = storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+	assert.Equal(t, &CatalogDiff{Summits: 5}, diff)
+
+	datadir := t.TempDir()
+	require.NoError(t, os.CopyFS(datadir, os.DirFS("testdata/summits")))
+	require.NoError(t, os.Remove(filepath.Join(datadir, "stolby", "1021.yaml")))
+	kurkakFile := filepath.Join(datadir, "kurkak", "kurkak.yaml")
+	kurkak, err := os.ReadFile(kurkakFile)
+	require.NoError(t, err)
+	kurkak = []byte(strings.Replace(string(kurkak), "height: 1008", "height: 1009", 1))
+	require.NoError(t, os.WriteFile(kurkakFile, kurkak, 0644))
+	newSummit := "coordinates:\n- 54.19\n- 58.47\nheight: 1030\n"
+	require.NoError(t, os.WriteFile(filepath.Join(datadir, "stolby", "1030.yaml"), []byte(newSummit), 0644))
+	kirel, err := os.ReadFile(filepath.Join(datadir, "malidak", "kirel.yaml"))
+	require.NoError(t, err)
+	kirel = append(kirel, []byte("legacy_ids:\n- kirel\n")...)
+	require.NoError(t, os.WriteFile(filepath.Join(datadir, "malidak", "kyrel.yaml"), kirel, 0644))
+	require.NoError(t, os.Remove(filepath.Join(datadir, "malidak", "kirel.yaml")))
+
+	diff, err = storage.LoadSummits(datadir)
+	require.NoError(t, err)
+	assert.Equal(t, &CatalogDiff{
+		Summits: 5,
+		Added:   []string{"1030"},
+		Removed: []string{"1021"},
+		Renamed: []CatalogRename{{"kirel", "kyrel"}},
+		Changed: []CatalogSummitChange{
+			{SummitId: "kurkak", Fields: []string{"height"}, OldHeight: 1008, NewHeight: 1009},
+		},
+		LegacyRemaps:    []CatalogLegacyRemap{{"kirel", "kyrel"}},
+		ClimbsRewritten: []CatalogClimbsRewrite{{"kirel", "kyrel", 6}},
+	}, diff)
+
+	// failed reload keeps previously loaded catalog
+	_, err = storage.LoadSummits("testdata/summits_broken0")
+	require.Error(t, err)
+	var height int
+	require.NoError(t, db.QueryRow("SELECT height FROM summits WHERE id = 'kurkak'").Scan(&height))
+	assert.Equal(t, 1009, height)
+	summitsNum, err := storage.CountSummits()
+	require.NoError(t, err)
+	assert.Equal(t, 5, summitsNum)
+
+	// only loads changing something are recorded
+	revisions, total, err := storage.FetchCatalogRevisions(1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, []CatalogRename{{"kirel", "kyrel"}}, revisions[0].Changes.Renamed)
+	assert.Len(t, revisions[1].Changes.Added, 5)
+}
+
+func TestLoadSummitsOrphanedClimbs(t *testing.T) {
+	db := MockDatabase(t)
+	storage := NewStorage(db)
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+
+	datadir := t.TempDir()
+	require.NoError(t, os.CopyFS(datadir, os.DirFS("testdata/summits")))
+	require.NoError(t, os.Remove(filepath.Join(datadir, "malidak", "malinovaja.yaml")))
+
+	// by default climbs are protected from being orphaned
+	_, err = storage.LoadSummits(datadir)
+	var orphansErr *OrphanedClimbsError
+	require.ErrorAs(t, err, &orphansErr)
+	assert.Equal(t, []OrphanedClimbs{{"malinovaja", 2}}, orphansErr.Orphans)
+	climbsNum, err := storage.Count("SELECT COUNT(*) FROM climbs WHERE summit_id = 'malinovaja'")
+	require.NoError(t, err)
+	assert.Equal(t, 2, climbsNum)
+
+	var climbId int64
+	require.NoError(t, db.QueryRow("SELECT id FROM climbs WHERE summit_id = 'malinovaja' AND user_id = 9").Scan(&climbId))
+	_, err = storage.AddClimbPhoto(climbId, 9, "photos/9/1_ab", 40, 30)
+	require.NoError(t, err)
+
+	// archive keeps only date and comment, so attachments are not dropped silently
+	_, err = storage.LoadSummitsArchivingOrphans(datadir, false)
+	var attachmentsErr *OrphanedAttachmentsError
+	require.ErrorAs(t, err, &attachmentsErr)
+	assert.Equal(t, []OrphanedClimbs{{"malinovaja", 1}}, attachmentsErr.Orphans)
+	climbsNum, err = storage.Count("SELECT COUNT(*) FROM climbs WHERE summit_id = 'malinovaja'")
+	require.NoError(t, err)
+	assert.Equal(t, 2, climbsNum)
+
+	diff, err := storage.LoadSummitsArchivingOrphans(datadir, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"malinovaja"}, diff.Removed)
+	assert.Equal(t, []OrphanedClimbs{{"malinovaja", 2}}, diff.ClimbsArchived)
+	climbsNum, err = storage.Count("SELECT COUNT(*) FROM climbs WHERE summit_id = 'malinovaja'")
+	require.NoError(t, err)
+	assert.Equal(t, 0, climbsNum)
+
+	archived, err := storage.FetchUserArchivedClimbs(9)
+	require.NoError(t, err)
+	require.Len(t, archived, 1)
+	assert.Equal(t, "malinovaja", archived[0].SummitId)
+	assert.Equal(t, InexactDate{2002, 0, 0}, archived[0].Date)
+	assert.Equal(t, "Down-sized intermediate framework", archived[0].Comment)
+	assert.False(t, archived[0].ArchivedAt.IsZero())
+
+	// photos of archived climbs are deleted from image storage later
+	photosNum, err := storage.Count("SELECT COUNT(*) FROM climb_photos")
+	require.NoError(t, err)
+	assert.Zero(t, photosNum)
+	stale, err := storage.FetchStaleImages()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, renditionKeys("photos/9/1_ab"), stale)
+}
+
+func TestUpdateClimbConcurrent(t *testing.T) {
+	storage := NewStorage(MockDatabase(t))
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+
+	// user 6 has not climbed kirel, concurrent requests register one climb
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			_, err := storage.UpdateClimb("kirel", 6, InexactDate{2020, 1, int64(i + 1)}, "", nil)
+			assert.NoError(t, err)
+		})
+	}
+	wg.Wait()
+	ascents, err := storage.FetchUserAscents(6, "kirel")
+	require.NoError(t, err)
+	assert.Len(t, ascents, 1)
+}
+
 func TestInexactDateParseValid(t *testing.T) {
 	cases := []struct {
 		input    string
@@ -78,3 +232,30 @@ func TestInexactDateParseInvalid(t *testing.T) {
 		}
 	}
 }
+
+func TestInexactDateString(t *testing.T) {
+	cases := []struct {
+		input    InexactDate
+		expected string
+	}{
+		{InexactDate{}, ""},
+		{InexactDate{2010, 0, 0}, "2010"},
+		{InexactDate{2010, 2, 0}, "02.2010"},
+		{InexactDate{2014, 6, 1}, "01.06.2014"},
+	}
+	for _, tt := range cases {
+		assert.Equal(t, tt.expected, tt.input.String())
+		var parsed InexactDate
+		assert.NoError(t, parsed.Parse(tt.input.String()))
+		assert.Equal(t, tt.input, parsed)
+	}
+}
+
+func TestInexactDateValidate(t *testing.T) {
+	for _, valid := range []InexactDate{{}, {2010, 0, 0}, {2010, 2, 0}, {2016, 2, 29}} {
+		assert.NoError(t, valid.Validate(), "%v", valid)
+	}
+	for _, invalid := range []InexactDate{{2010, 13, 0}, {2010, 2, 30}, {2010, 0, 5}, {0, 2, 0}, {-1, 0, 0}} {
+		assert.Error(t, invalid.Validate(), "%v", invalid)
+	}
+}
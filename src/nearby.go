@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+const (
+	// gridCellSize is a side of summits_grid cell in degrees,
+	// about 11 km by 6.5 km in South Urals
+	gridCellSize        = 0.1
+	DefaultNearbyLimit  = 10
+	maxNearbyLimit      = 50
+	DefaultNearbyRadius = 10.0
+	// maxNearbyRadius, in kilometers, limits the number of grid cells scanned
+	maxNearbyRadius = 200.0
+	kmPerDegree     = 111.2
+)
+
+type NearbySummit struct {
+	Id        string  `json:"id"`
+	Name      *string `json:"name"`
+	Height    int     `json:"height"`
+	RidgeId   string  `json:"ridge_id"`
+	RidgeName string  `json:"ridge"`
+	Lat       float32 `json:"lat"`
+	Lng       float32 `json:"lng"`
+	// Distance in kilometers
+	Distance float64 `json:"distance"`
+	// Bearing is initial great-circle bearing in degrees clockwise from north
+	Bearing float64 `json:"bearing"`
+	Climbed bool    `json:"climbed"`
+}
+
+func gridCell(lat, lng float64) (int, int) {
+	return int(math.Floor(lat / gridCellSize)), int(math.Floor(lng / gridCellSize))
+}
+
+// bearing returns initial bearing from the first point to the second one
+func bearing(lat1, lng1, lat2, lng2 float64) float64 {
+	rlat1, rlat2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dlng := (lng2 - lng1) * math.Pi / 180
+	y := math.Sin(dlng) * math.Cos(rlat2)
+	x := math.Cos(rlat1)*math.Sin(rlat2) - math.Sin(rlat1)*math.Cos(rlat2)*math.Cos(dlng)
+	deg := math.Atan2(y, x) * 180 / math.Pi
+	return math.Mod(math.Round(deg)+360, 360)
+}
+
+// buildGridIndex refills summits_grid table from summits loaded in transaction
+func buildGridIndex(tx *sql.Tx) error {
+	if _, err := tx.Exec("DELETE FROM summits_grid"); err != nil {
+		return err
+	}
+	// sqlite is built without math functions, so cells are computed here
+	rows, err := tx.Query("SELECT id, lat, lng FROM summits")
+	if err != nil {
+		return err
+	}
+	type gridItem struct {
+		id       string
+		lat, lng int
+	}
+	items := make([]gridItem, 0)
+	for rows.Next() {
+		var id string
+		var lat, lng float64
+		if err := rows.Scan(&id, &lat, &lng); err != nil {
+			rows.Close()
+			return err
+		}
+		cellLat, cellLng := gridCell(lat, lng)
+		items = append(items, gridItem{id, cellLat, cellLng})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO summits_grid (summit_id, cell_lat, cell_lng) VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, item := range items {
+		if _, err := stmt.Exec(item.id, item.lat, item.lng); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchSummitsNear returns summits within radius (in kilometers) from the point
+// ordered by distance. Only grid cells overlapping the radius are scanned.
+func (s *Storage) FetchSummitsNear(userId int64, lat, lng, radius float64) ([]NearbySummit, error) {
+	dLat := radius / kmPerDegree
+	// cells get narrower towards the poles, cap the stretch to stay finite
+	dLng := radius / (kmPerDegree * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+	minLat, minLng := gridCell(lat-dLat, lng-dLng)
+	maxLat, maxLng := gridCell(lat+dLat, lng+dLng)
+
+	rows, err := s.db.Query(`SELECT s.id, s.name, s.height, r.id, r.name, s.lat, s.lng,
+			EXISTS(SELECT 1 FROM climbs c WHERE c.user_id = ? AND c.summit_id = s.id)
+		FROM summits_grid g
+			INNER JOIN summits s ON s.id = g.summit_id
+			INNER JOIN ridges r ON r.id = s.ridge_id
+		WHERE g.cell_lat BETWEEN ? AND ? AND g.cell_lng BETWEEN ? AND ?`,
+		userId, minLat, maxLat, minLng, maxLng)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	summits := make([]NearbySummit, 0)
+	for rows.Next() {
+		var ns NearbySummit
+		err := rows.Scan(&ns.Id, &ns.Name, &ns.Height, &ns.RidgeId, &ns.RidgeName, &ns.Lat, &ns.Lng, &ns.Climbed)
+		if err != nil {
+			return nil, err
+		}
+		toLat, toLng := exactFloat64(ns.Lat), exactFloat64(ns.Lng)
+		d := gpx.HaversineDistance(lat, lng, toLat, toLng)
+		if d > radius*1000 {
+			continue
+		}
+		ns.Distance = d
+		ns.Bearing = bearing(lat, lng, toLat, toLng)
+		summits = append(summits, ns)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(summits, func(i, j int) bool {
+		if summits[i].Distance != summits[j].Distance {
+			return summits[i].Distance < summits[j].Distance
+		}
+		return summits[i].Id < summits[j].Id
+	})
+	for i := range summits {
+		summits[i].Distance = roundKm(summits[i].Distance)
+	}
+	return summits, nil
+}
+
+// FetchNearestSummits returns up to limit summits closest to the given one.
+// Search radius is doubled until enough summits are found or maxNearbyRadius is reached.
+func (s *Storage) FetchNearestSummits(userId int64, summit *Summit, limit int) ([]NearbySummit, error) {
+	lat, lng := exactFloat64(summit.Coordinates[0]), exactFloat64(summit.Coordinates[1])
+	for radius := DefaultNearbyRadius; ; radius *= 2 {
+		radius = math.Min(radius, maxNearbyRadius)
+		found, err := s.FetchSummitsNear(userId, lat, lng, radius)
+		if err != nil {
+			return nil, err
+		}
+		nearest := make([]NearbySummit, 0, len(found))
+		for _, ns := range found {
+			if ns.Id != summit.Id {
+				nearest = append(nearest, ns)
+			}
+		}
+		if len(nearest) >= limit || radius >= maxNearbyRadius {
+			if len(nearest) > limit {
+				nearest = nearest[:limit]
+			}
+			return nearest, nil
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBearing(t *testing.T) {
+	assert.Equal(t, 0.0, bearing(54.0, 58.0, 54.1, 58.0))
+	assert.Equal(t, 180.0, bearing(54.1, 58.0, 54.0, 58.0))
+	assert.Equal(t, 90.0, bearing(54.0, 58.0, 54.0, 58.001))
+	assert.Equal(t, 270.0, bearing(54.0, 58.001, 54.0, 58.0))
+}
+
+func TestFetchSummitsNear(t *testing.T) {
+	db := MockDatabase(t)
+	storage := NewStorage(db)
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+
+	var cells int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM summits_grid").Scan(&cells))
+	assert.Equal(t, 5, cells)
+
+	cases := []struct {
+		name        string
+		lat, lng    float64
+		radius      float64
+		expectedIds []string
+	}{
+		{"stolby area", 54.19, 58.47, 5, []string{"1021", "stolby"}},
+		{"malidak area", 54.03, 58.2, 10, []string{"kirel", "malinovaja"}},
+		{"whole catalog", 54.03, 58.2, 100, []string{"kirel", "malinovaja", "1021", "stolby", "kurkak"}},
+		{"nothing around", 55.0, 60.0, 10, []string{}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			summits, err := storage.FetchSummitsNear(0, tt.lat, tt.lng, tt.radius)
+			require.NoError(t, err)
+			ids := make([]string, len(summits))
+			for i, s := range summits {
+				ids[i] = s.Id
+				assert.LessOrEqual(t, s.Distance, tt.radius)
+			}
+			assert.Equal(t, tt.expectedIds, ids)
+		})
+	}
+}
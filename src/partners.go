@@ -0,0 +1,221 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	PartnerPending   = "pending"
+	PartnerAccepted  = "accepted"
+	PartnerDeclined  = "declined"
+	maxClimbPartners = 30
+)
+
+var (
+	errPartnerSelf     = errors.New("users can not be partners of themselves")
+	errUnknownPartner  = errors.New("unknown partner")
+	errTooManyPartners = fmt.Errorf("at most %d partners are allowed", maxClimbPartners)
+)
+
+type ClimbPartner struct {
+	UserId    int64  `json:"user_id"`
+	UserName  string `json:"user_name"`
+	UserImage string `json:"user_image"`
+	Status    string `json:"status"`
+}
+
+// Invitation is a pending request to confirm the climb made together
+type Invitation struct {
+	ClimbId    int64       `json:"climb_id"`
+	UserId     int64       `json:"user_id"`
+	UserName   string      `json:"user_name"`
+	UserImage  string      `json:"user_image"`
+	SummitId   string      `json:"summit_id"`
+	SummitName *string     `json:"summit_name"`
+	Height     int         `json:"height"`
+	RidgeId    string      `json:"ridge_id"`
+	RidgeName  string      `json:"ridge"`
+	Date       InexactDate `json:"date"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+// SetClimbPartners replaces partners of user's climb. New partners get
+// pending invitations, the ones left keep their status, so a declined
+// invitation is not sent again. False is returned if user has no such climb.
+func (s *Storage) SetClimbPartners(climbId, userId int64, partnerIds []int64) (bool, error) {
+	if len(partnerIds) > maxClimbPartners {
+		return false, errTooManyPartners
+	}
+	for _, id := range partnerIds {
+		if id == userId {
+			return false, errPartnerSelf
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var owner int64
+	err = tx.QueryRow(`SELECT user_id FROM climbs WHERE id = ?`, climbId).Scan(&owner)
+	if err == sql.ErrNoRows || (err == nil && owner != userId) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	placeholders := make([]string, len(partnerIds))
+	params := []any{climbId}
+	for i, id := range partnerIds {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)`, id).Scan(&exists); err != nil {
+			return false, err
+		}
+		if !exists {
+			return false, errUnknownPartner
+		}
+		placeholders[i] = "?"
+		params = append(params, id)
+	}
+	// NOT IN () is valid in sqlite and removes all partners
+	_, err = tx.Exec(fmt.Sprintf(`DELETE FROM climb_partners WHERE climb_id = ? AND partner_id NOT IN (%s)`,
+		strings.Join(placeholders, ", ")), params...)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UTC()
+	for _, id := range partnerIds {
+		_, err := tx.Exec(`INSERT INTO climb_partners (climb_id, partner_id, status, created_at)
+			VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`, climbId, id, PartnerPending, now)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// FetchClimbPartners returns partners tagged on the climb in order of tagging
+func (s *Storage) FetchClimbPartners(climbId int64) ([]ClimbPartner, error) {
+	rows, err := s.db.Query(`SELECT u.id, u.name, ui.url, p.status
+		FROM climb_partners p
+			INNER JOIN users u ON u.id = p.partner_id
+			LEFT JOIN user_images ui ON ui.user_id = u.id AND ui.size = 'S'
+		WHERE p.climb_id = ?
+		ORDER BY p.created_at ASC, u.id ASC`, climbId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	partners := make([]ClimbPartner, 0)
+	for rows.Next() {
+		var p ClimbPartner
+		var image sql.NullString
+		if err := rows.Scan(&p.UserId, &p.UserName, &image, &p.Status); err != nil {
+			return nil, err
+		}
+		p.UserImage = image.String
+		partners = append(partners, p)
+	}
+	return partners, rows.Err()
+}
+
+// FetchInvitations returns pending invitations of the user, the latest first
+func (s *Storage) FetchInvitations(userId int64) ([]Invitation, error) {
+	rows, err := s.db.Query(`SELECT c.id, u.id, u.name, ui.url, s.id, s.name, s.height, r.id, r.name,
+			c.year, c.month, c.day, p.created_at
+		FROM climb_partners p
+			INNER JOIN climbs c ON c.id = p.climb_id
+			INNER JOIN users u ON u.id = c.user_id
+			INNER JOIN summits s ON s.id = c.summit_id
+			INNER JOIN ridges r ON r.id = s.ridge_id
+			LEFT JOIN user_images ui ON ui.user_id = u.id AND ui.size = 'S'
+		WHERE p.partner_id = ? AND p.status = ?
+		ORDER BY p.created_at DESC, c.id DESC`, userId, PartnerPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	invitations := make([]Invitation, 0)
+	for rows.Next() {
+		var inv Invitation
+		var image sql.NullString
+		var year, month, day sql.NullInt64
+		err := rows.Scan(&inv.ClimbId, &inv.UserId, &inv.UserName, &image, &inv.SummitId, &inv.SummitName,
+			&inv.Height, &inv.RidgeId, &inv.RidgeName, &year, &month, &day, &inv.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		inv.UserImage = image.String
+		inv.Date.FromSQL(year, month, day)
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// AcceptInvitation confirms that the user climbed together with the author
+// of the climb. The user's ascent of the summit on the same date is linked
+// if there is one, otherwise it is created. Id of the ascent is returned,
+// or zero if there is no pending invitation.
+func (s *Storage) AcceptInvitation(climbId, userId int64) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var summitId string
+	var year, month, day sql.NullInt64
+	err = tx.QueryRow(`SELECT c.summit_id, c.year, c.month, c.day
+		FROM climb_partners p INNER JOIN climbs c ON c.id = p.climb_id
+		WHERE p.climb_id = ? AND p.partner_id = ? AND p.status = ?`,
+		climbId, userId, PartnerPending).Scan(&summitId, &year, &month, &day)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var ascentId int64
+	err = tx.QueryRow(`SELECT id FROM climbs
+		WHERE user_id = ? AND summit_id = ? AND year IS ? AND month IS ? AND day IS ?
+		ORDER BY id LIMIT 1`, userId, summitId, year, month, day).Scan(&ascentId)
+	if err == sql.ErrNoRows {
+		var date InexactDate
+		date.FromSQL(year, month, day)
+		ascentId, err = addAscent(tx, summitId, userId, date, "")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE climb_partners SET status = ?, partner_climb_id = ?
+		WHERE climb_id = ? AND partner_id = ?`, PartnerAccepted, ascentId, climbId, userId)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	s.climbsChanged(userId, year.Int64)
+	return ascentId, nil
+}
+
+// DeclineInvitation refuses pending invitation.
+// False is returned if there is no such invitation.
+func (s *Storage) DeclineInvitation(climbId, userId int64) (bool, error) {
+	res, err := s.db.Exec(`UPDATE climb_partners SET status = ?
+		WHERE climb_id = ? AND partner_id = ? AND status = ?`, PartnerDeclined, climbId, userId, PartnerPending)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClimbPartners(t *testing.T) {
+	storage := NewStorage(MockDatabase(t))
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+
+	date := InexactDate{2024, 6, 1}
+	climbId, err := storage.AddAscent("1021", 6, date, "втроём", nil)
+	require.NoError(t, err)
+
+	found, err := storage.SetClimbPartners(climbId, 5, []int64{7})
+	require.NoError(t, err)
+	assert.False(t, found, "only the author tags partners")
+	_, err = storage.SetClimbPartners(climbId, 6, []int64{6})
+	assert.ErrorIs(t, err, errPartnerSelf)
+	_, err = storage.SetClimbPartners(climbId, 6, []int64{5, 1000})
+	assert.ErrorIs(t, err, errUnknownPartner)
+
+	found, err = storage.SetClimbPartners(climbId, 6, []int64{5, 7})
+	require.NoError(t, err)
+	require.True(t, found)
+	partners, err := storage.FetchClimbPartners(climbId)
+	require.NoError(t, err)
+	assert.Equal(t, []ClimbPartner{
+		{UserId: 5, UserName: "Jonathan Nguyen", UserImage: "users/5_S.jpg", Status: PartnerPending},
+		{UserId: 7, UserName: "Todd Bowen", UserImage: "users/7_S.jpg", Status: PartnerPending},
+	}, partners)
+
+	invitations, err := storage.FetchInvitations(5)
+	require.NoError(t, err)
+	require.Len(t, invitations, 1)
+	assert.Equal(t, climbId, invitations[0].ClimbId)
+	assert.Equal(t, int64(6), invitations[0].UserId)
+	assert.Equal(t, "1021", invitations[0].SummitId)
+	assert.Equal(t, "stolby", invitations[0].RidgeId)
+	assert.Equal(t, date, invitations[0].Date)
+
+	ascentId, err := storage.AcceptInvitation(climbId, 5)
+	require.NoError(t, err)
+	require.NotZero(t, ascentId)
+	ascent, err := storage.FetchAscent(ascentId, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "1021", ascent.SummitId)
+	assert.Equal(t, date, ascent.Date)
+	// already answered
+	ascentId, err = storage.AcceptInvitation(climbId, 5)
+	require.NoError(t, err)
+	assert.Zero(t, ascentId)
+
+	declined, err := storage.DeclineInvitation(climbId, 7)
+	require.NoError(t, err)
+	assert.True(t, declined)
+	declined, err = storage.DeclineInvitation(climbId, 7)
+	require.NoError(t, err)
+	assert.False(t, declined)
+	invitations, err = storage.FetchInvitations(7)
+	require.NoError(t, err)
+	assert.Empty(t, invitations)
+
+	// declined invitation is not sent again
+	found, err = storage.SetClimbPartners(climbId, 6, []int64{5, 7})
+	require.NoError(t, err)
+	require.True(t, found)
+	partners, err = storage.FetchClimbPartners(climbId)
+	require.NoError(t, err)
+	require.Len(t, partners, 2)
+	assert.Equal(t, PartnerAccepted, partners[0].Status)
+	assert.Equal(t, PartnerDeclined, partners[1].Status)
+
+	t.Run("grouped in summit climbs", func(t *testing.T) {
+		// climbed the same day, but not together
+		_, err := storage.AddAscent("1021", 7, date, "", nil)
+		require.NoError(t, err)
+		_, err = storage.AddAscent("1021", 9, InexactDate{2020, 0, 0}, "", nil)
+		require.NoError(t, err)
+
+		climbs, total, err := storage.FetchSummitClimbs("1021", 1, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 4, total)
+		users := make([]int64, len(climbs))
+		groups := make([]int64, len(climbs))
+		for i, c := range climbs {
+			users[i], groups[i] = c.UserId, c.GroupId
+		}
+		assert.ElementsMatch(t, []int64{5, 6}, users[1:3])
+		assert.Equal(t, []int64{9, 7}, []int64{users[0], users[3]})
+		assert.Equal(t, []int64{0, climbId, climbId, 0}, groups)
+	})
+
+	t.Run("existing ascent is linked", func(t *testing.T) {
+		// user 5 climbed kurkak on 07.03.1990
+		climbId, err := storage.AddAscent("kurkak", 7, InexactDate{1990, 3, 7}, "", nil)
+		require.NoError(t, err)
+		_, err = storage.SetClimbPartners(climbId, 7, []int64{5})
+		require.NoError(t, err)
+		before, err := storage.FetchUserAscents(5, "kurkak")
+		require.NoError(t, err)
+		ascentId, err := storage.AcceptInvitation(climbId, 5)
+		require.NoError(t, err)
+		after, err := storage.FetchUserAscents(5, "kurkak")
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+		require.Len(t, after, 1)
+		assert.Equal(t, after[0].Id, ascentId)
+	})
+
+	t.Run("repeated ascents are not grouped", func(t *testing.T) {
+		// users 3 and 9 climbed kurkak alone before, in 2012 and 1998
+		repeat := InexactDate{2024, 6, 1}
+		partnerAscentId, err := storage.AddAscent("kurkak", 9, repeat, "", nil)
+		require.NoError(t, err)
+		climbId, err := storage.AddAscent("kurkak", 3, repeat, "", nil)
+		require.NoError(t, err)
+		_, err = storage.SetClimbPartners(climbId, 3, []int64{9})
+		require.NoError(t, err)
+		ascentId, err := storage.AcceptInvitation(climbId, 9)
+		require.NoError(t, err)
+		require.Equal(t, partnerAscentId, ascentId)
+
+		climbs, _, err := storage.FetchSummitClimbs("kurkak", 1, 20)
+		require.NoError(t, err)
+		groups := make(map[int64]int64)
+		for _, c := range climbs {
+			groups[c.UserId] = c.GroupId
+		}
+		assert.Zero(t, groups[3])
+		assert.Zero(t, groups[9])
+	})
+
+	t.Run("groups join through members", func(t *testing.T) {
+		date := InexactDate{2023, 8, 20}
+		first, err := storage.AddAscent("stolby", 1, date, "", nil)
+		require.NoError(t, err)
+		_, err = storage.SetClimbPartners(first, 1, []int64{2})
+		require.NoError(t, err)
+		second, err := storage.AcceptInvitation(first, 2)
+		require.NoError(t, err)
+		_, err = storage.AddAscent("stolby", 3, date, "", nil)
+		require.NoError(t, err)
+		_, err = storage.SetClimbPartners(second, 2, []int64{3})
+		require.NoError(t, err)
+		_, err = storage.AcceptInvitation(second, 3)
+		require.NoError(t, err)
+
+		climbs, _, err := storage.FetchSummitClimbs("stolby", 1, 20)
+		require.NoError(t, err)
+		groups := make(map[int64]int64)
+		for _, c := range climbs {
+			groups[c.UserId] = c.GroupId
+		}
+		assert.Equal(t, first, groups[1])
+		assert.Equal(t, first, groups[2])
+		assert.Equal(t, first, groups[3])
+	})
+
+	t.Run("removed with the climb", func(t *testing.T) {
+		found, err := storage.DeleteAscent(climbId, 6)
+		require.NoError(t, err)
+		require.True(t, found)
+		partners, err := storage.FetchClimbPartners(climbId)
+		require.NoError(t, err)
+		assert.Empty(t, partners)
+		// partner's climb stays
+		ascents, err := storage.FetchUserAscents(5, "1021")
+		require.NoError(t, err)
+		assert.Len(t, ascents, 1)
+	})
+}
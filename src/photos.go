@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// maxPhotosUpload limits request with several photos
+	maxPhotosUpload = 3 * maxImageSize
+	maxClimbPhotos  = 10
+)
+
+var errTooManyPhotos = fmt.Errorf("at most %d photos per climb are allowed", maxClimbPhotos)
+
+// ClimbPhoto links to the large rendition of the photo and to the medium
+// one for previews. Other renditions are stored next to them.
+type ClimbPhoto struct {
+	Id         int64  `json:"id"`
+	ClimbId    int64  `json:"climb_id"`
+	Url        string `json:"url"`
+	PreviewUrl string `json:"preview_url"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+}
+
+// climb_photos key is the base of renditions keys. Photos uploaded before
+// renditions were introduced have full key of the single image instead,
+// until RenderLegacyClimbPhotos makes renditions of them.
+func isLegacyPhotoKey(key string) bool {
+	return path.Ext(key) != ""
+}
+
+// photoKeys returns storage keys of all images of the photo
+func photoKeys(key string) []string {
+	if isLegacyPhotoKey(key) {
+		return []string{key}
+	}
+	return renditionKeys(key)
+}
+
+func newClimbPhoto(id, climbId int64, key string, width, height int) ClimbPhoto {
+	if isLegacyPhotoKey(key) {
+		return ClimbPhoto{Id: id, ClimbId: climbId, Url: key, PreviewUrl: key, Width: width, Height: height}
+	}
+	return ClimbPhoto{
+		Id:         id,
+		ClimbId:    climbId,
+		Url:        RenditionKey(key, ImageLarge, FormatJPEG),
+		PreviewUrl: RenditionKey(key, ImageMedium, FormatJPEG),
+		Width:      width,
+		Height:     height,
+	}
+}
+
+// photoBase makes a storage key base for renditions of the photo. Photos of
+// a user share the prefix, random part keeps urls of deleted photos from being reused.
+func photoBase(userId, climbId int64) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("photos/%d/%d_%s", userId, climbId, hex.EncodeToString(suffix)), nil
+}
+
+// AddClimbPhoto registers uploaded photo of user's climb, base is the key
+// base of its renditions, width and height are of the large one.
+// errTooManyPhotos is returned if the climb has enough photos already.
+func (s *Storage) AddClimbPhoto(climbId, userId int64, base string, width, height int) (*ClimbPhoto, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM climb_photos WHERE climb_id = ?`, climbId).Scan(&n); err != nil {
+		return nil, err
+	}
+	if n >= maxClimbPhotos {
+		return nil, errTooManyPhotos
+	}
+	res, err := tx.Exec(`INSERT INTO climb_photos (climb_id, user_id, key, width, height, uploaded_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, climbId, userId, base, width, height, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	photo := newClimbPhoto(id, climbId, base, width, height)
+	return &photo, tx.Commit()
+}
+
+// CountClimbPhotos returns number of photos attached to the climb
+func (s *Storage) CountClimbPhotos(climbId int64) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM climb_photos WHERE climb_id = ?`, climbId).Scan(&n)
+	return n, err
+}
+
+// DeleteClimbPhoto removes user's photo and returns keys of its renditions
+// for removal from image storage, or nil if user has no such photo
+func (s *Storage) DeleteClimbPhoto(photoId, userId int64) ([]string, error) {
+	var base string
+	err := s.db.QueryRow(`DELETE FROM climb_photos WHERE id = ? AND user_id = ? RETURNING key`,
+		photoId, userId).Scan(&base)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return photoKeys(base), nil
+}
+
+func (s *Storage) fetchPhotoKeys(query string, params ...any) ([]string, error) {
+	rows, err := s.db.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]string, 0)
+	for rows.Next() {
+		var base string
+		if err := rows.Scan(&base); err != nil {
+			return nil, err
+		}
+		keys = append(keys, photoKeys(base)...)
+	}
+	return keys, rows.Err()
+}
+
+// FetchAscentPhotoKeys returns storage keys of photo renditions of user's ascent
+func (s *Storage) FetchAscentPhotoKeys(ascentId, userId int64) ([]string, error) {
+	return s.fetchPhotoKeys(`SELECT key FROM climb_photos WHERE climb_id = ? AND user_id = ?`, ascentId, userId)
+}
+
+// FetchSummitPhotoKeys returns storage keys of photo renditions of all user's ascents of the summit
+func (s *Storage) FetchSummitPhotoKeys(summitId string, userId int64) ([]string, error) {
+	return s.fetchPhotoKeys(`SELECT p.key FROM climb_photos p INNER JOIN climbs c ON c.id = p.climb_id
+		WHERE c.summit_id = ? AND c.user_id = ?`, summitId, userId)
+}
+
+// fetchPhotos returns photos grouped by the first selected column
+func fetchPhotos[K comparable](s *Storage, query string, params ...any) (map[K][]ClimbPhoto, error) {
+	rows, err := s.db.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	photos := make(map[K][]ClimbPhoto)
+	for rows.Next() {
+		var group K
+		var id, climbId int64
+		var base string
+		var width, height int
+		if err := rows.Scan(&group, &id, &climbId, &base, &width, &height); err != nil {
+			return nil, err
+		}
+		photos[group] = append(photos[group], newClimbPhoto(id, climbId, base, width, height))
+	}
+	return photos, rows.Err()
+}
+
+// FetchSummitPhotos returns photos of the summit by user id
+func (s *Storage) FetchSummitPhotos(summitId string) (map[int64][]ClimbPhoto, error) {
+	return fetchPhotos[int64](s, `SELECT c.user_id, p.id, p.climb_id, p.key, p.width, p.height
+		FROM climb_photos p INNER JOIN climbs c ON c.id = p.climb_id
+		WHERE c.summit_id = ?
+		ORDER BY p.id`, summitId)
+}
+
+// FetchUserPhotos returns photos of the user by summit id
+func (s *Storage) FetchUserPhotos(userId int64) (map[string][]ClimbPhoto, error) {
+	return fetchPhotos[string](s, `SELECT c.summit_id, p.id, p.climb_id, p.key, p.width, p.height
+		FROM climb_photos p INNER JOIN climbs c ON c.id = p.climb_id
+		WHERE c.user_id = ?
+		ORDER BY p.id`, userId)
+}
+
+type legacyClimbPhoto struct {
+	Id  int64
+	Key string
+}
+
+func (s *Storage) fetchLegacyClimbPhotos() ([]legacyClimbPhoto, error) {
+	// renditions bases have no extension
+	rows, err := s.db.Query(`SELECT id, key FROM climb_photos WHERE key LIKE '%.%' ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	photos := make([]legacyClimbPhoto, 0)
+	for rows.Next() {
+		var p legacyClimbPhoto
+		if err := rows.Scan(&p.Id, &p.Key); err != nil {
+			return nil, err
+		}
+		photos = append(photos, p)
+	}
+	return photos, rows.Err()
+}
+
+// replaceLegacyClimbPhoto points the photo to its renditions and queues
+// the original for deletion. If the photo is gone meanwhile,
+// renditions are queued instead. Returns false in that case.
+func (s *Storage) replaceLegacyClimbPhoto(photo legacyClimbPhoto, base string, width, height int) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`UPDATE climb_photos SET key = ?, width = ?, height = ? WHERE id = ? AND key = ?`,
+		base, width, height, photo.Id, photo.Key)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	stale := []string{photo.Key}
+	if n == 0 {
+		stale = renditionKeys(base)
+	}
+	if err := addStaleImages(tx, stale); err != nil {
+		return false, err
+	}
+	return n > 0, tx.Commit()
+}
+
+// RenderLegacyClimbPhotos makes renditions of photos uploaded before
+// renditions were introduced. Failures are logged and the rest of photos
+// are processed anyway. Returns number of photos rendered.
+func RenderLegacyClimbPhotos(ctx context.Context, storage *Storage, im ImageManager) (int, error) {
+	photos, err := storage.fetchLegacyClimbPhotos()
+	if err != nil {
+		return 0, err
+	}
+	rendered := 0
+	for _, photo := range photos {
+		data, err := im.Get(ctx, photo.Key)
+		if err != nil {
+			slog.Error("Failed to load climb photo", "photoId", photo.Id, "key", photo.Key, "error", err)
+			continue
+		}
+		renditions, err := ProcessImage(data)
+		if err != nil {
+			slog.Error("Failed to process climb photo", "photoId", photo.Id, "key", photo.Key, "error", err)
+			continue
+		}
+		base := strings.TrimSuffix(photo.Key, path.Ext(photo.Key))
+		if err := UploadRenditions(ctx, im, base, renditions); err != nil {
+			slog.Error("Failed to upload climb photo renditions", "photoId", photo.Id, "error", err)
+			continue
+		}
+		ok, err := storage.replaceLegacyClimbPhoto(photo, base, renditions[0].Width, renditions[0].Height)
+		if err != nil {
+			return rendered, err
+		}
+		if ok {
+			rendered++
+		}
+	}
+	return rendered, nil
+}
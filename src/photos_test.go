@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClimbPhotos(t *testing.T) {
+	storage := NewStorage(MockDatabase(t))
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+
+	ascents, err := storage.FetchUserAscents(5, "kirel")
+	require.NoError(t, err)
+	climbId := ascents[0].Id
+	repeated, err := storage.AddAscent("kirel", 5, InexactDate{2024, 6, 1}, "", nil)
+	require.NoError(t, err)
+
+	first, err := storage.AddClimbPhoto(climbId, 5, "photos/5/a", 40, 30)
+	require.NoError(t, err)
+	assert.Equal(t, ClimbPhoto{Id: first.Id, ClimbId: climbId, Url: "photos/5/a_L.jpg",
+		PreviewUrl: "photos/5/a_M.jpg", Width: 40, Height: 30}, *first)
+	second, err := storage.AddClimbPhoto(repeated, 5, "photos/5/b", 30, 40)
+	require.NoError(t, err)
+	others, err := storage.FetchUserAscents(7, "kirel")
+	require.NoError(t, err)
+	_, err = storage.AddClimbPhoto(others[0].Id, 7, "photos/7/c", 30, 40)
+	require.NoError(t, err)
+
+	climbs, _, err := storage.FetchSummitClimbs("kirel", 1, 20)
+	require.NoError(t, err)
+	for _, c := range climbs {
+		switch c.UserId {
+		case 5:
+			assert.Equal(t, []ClimbPhoto{*first, *second}, c.Photos)
+		case 7:
+			assert.Len(t, c.Photos, 1)
+		default:
+			assert.Empty(t, c.Photos)
+		}
+	}
+
+	summits, err := storage.FetchUserClimbs(5)
+	require.NoError(t, err)
+	for _, s := range summits {
+		if s.Id == "kirel" {
+			assert.Equal(t, []ClimbPhoto{*first, *second}, s.ClimbData.Photos)
+		} else {
+			assert.Empty(t, s.ClimbData.Photos)
+		}
+	}
+
+	keys, err := storage.FetchSummitPhotoKeys("kirel", 5)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, append(renditionKeys("photos/5/a"), renditionKeys("photos/5/b")...), keys)
+	keys, err = storage.FetchAscentPhotoKeys(repeated, 5)
+	require.NoError(t, err)
+	assert.Equal(t, renditionKeys("photos/5/b"), keys)
+
+	keys, err = storage.DeleteClimbPhoto(first.Id, 7)
+	require.NoError(t, err)
+	assert.Nil(t, keys, "photo of another user")
+	keys, err = storage.DeleteClimbPhoto(first.Id, 5)
+	require.NoError(t, err)
+	assert.Contains(t, keys, "photos/5/a_L.jpg")
+	assert.Contains(t, keys, "photos/5/a_S.webp")
+
+	t.Run("limit", func(t *testing.T) {
+		for n := 1; n < maxClimbPhotos; n++ {
+			_, err := storage.AddClimbPhoto(repeated, 5, "photos/5/x", 1, 1)
+			require.NoError(t, err)
+		}
+		_, err := storage.AddClimbPhoto(repeated, 5, "photos/5/y", 1, 1)
+		assert.ErrorIs(t, err, errTooManyPhotos)
+	})
+
+	t.Run("removed with the climb", func(t *testing.T) {
+		found, err := storage.DeleteAscent(repeated, 5)
+		require.NoError(t, err)
+		require.True(t, found)
+		n, err := storage.CountClimbPhotos(repeated)
+		require.NoError(t, err)
+		assert.Zero(t, n)
+	})
+}
+
+func TestRenderLegacyClimbPhotos(t *testing.T) {
+	ctx := context.Background()
+	storage := NewStorage(MockDatabase(t))
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+	ascents, err := storage.FetchUserAscents(5, "kirel")
+	require.NoError(t, err)
+
+	// photos stored as a single image before renditions
+	im := NewMockImageManager(t.TempDir())
+	require.NoError(t, im.Upload(ctx, testJPEG(t, 2000, 1000), "photos/5/1_ab.jpg"))
+	legacy, err := storage.AddClimbPhoto(ascents[0].Id, 5, "photos/5/1_ab.jpg", 2000, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, "photos/5/1_ab.jpg", legacy.Url)
+	assert.Equal(t, "photos/5/1_ab.jpg", legacy.PreviewUrl)
+	_, err = storage.AddClimbPhoto(ascents[0].Id, 5, "photos/5/1_missing.jpg", 40, 30)
+	require.NoError(t, err)
+	keys, err := storage.FetchAscentPhotoKeys(ascents[0].Id, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"photos/5/1_ab.jpg", "photos/5/1_missing.jpg"}, keys)
+
+	n, err := RenderLegacyClimbPhotos(ctx, storage, im)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "photo missing in storage is skipped")
+	for _, key := range renditionKeys("photos/5/1_ab") {
+		assert.FileExists(t, path.Join(im.tempDir, key))
+	}
+	photos, err := storage.FetchUserPhotos(5)
+	require.NoError(t, err)
+	require.Len(t, photos["kirel"], 2)
+	assert.Equal(t, ClimbPhoto{Id: legacy.Id, ClimbId: ascents[0].Id, Url: "photos/5/1_ab_L.jpg",
+		PreviewUrl: "photos/5/1_ab_M.jpg", Width: 1600, Height: 800}, photos["kirel"][0])
+	stale, err := storage.FetchStaleImages()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"photos/5/1_ab.jpg"}, stale, "original is deleted later")
+
+	n, err = RenderLegacyClimbPhotos(ctx, storage, im)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+const (
+	// DefaultDailyDistance is a daily walking budget in kilometers
+	DefaultDailyDistance = 20.0
+	maxPlanSummits       = 200
+	// 2-opt converges long before this on plans of maxPlanSummits,
+	// the cap only guards against floating point ping-pong
+	maxPlanPasses = 100
+)
+
+type PlanStop struct {
+	Id        string  `json:"id"`
+	Name      *string `json:"name"`
+	Height    int     `json:"height"`
+	RidgeId   string  `json:"ridge_id"`
+	RidgeName string  `json:"ridge"`
+	Lat       float32 `json:"lat"`
+	Lng       float32 `json:"lng"`
+	// Distance from the previous stop in kilometers
+	Distance float64 `json:"distance"`
+}
+
+type PlanDay struct {
+	Stops []PlanStop `json:"summits"`
+	// Distance walked during the day in kilometers
+	Distance float64 `json:"distance"`
+}
+
+// Plan is a multi-day trip visiting summits one after another.
+// Every day continues from the last summit of the previous one.
+type Plan struct {
+	Start         [2]float64 `json:"start"`
+	DailyDistance float64    `json:"daily_distance"`
+	TotalDistance float64    `json:"total_distance"`
+	Days          []PlanDay  `json:"days"`
+}
+
+func roundKm(meters float64) float64 {
+	return math.Round(meters/100) / 10
+}
+
+// orderStops finds a short open path through points starting at the first one.
+// Nearest neighbour tour is improved by 2-opt moves, distances are great-circle.
+func orderStops(points [][2]float64) []int {
+	n := len(points)
+	dist := make([][]float64, n)
+	for i := range points {
+		dist[i] = make([]float64, n)
+		for j := range points {
+			dist[i][j] = gpx.HaversineDistance(points[i][0], points[i][1], points[j][0], points[j][1])
+		}
+	}
+
+	order := make([]int, 1, n)
+	visited := make([]bool, n)
+	visited[0] = true
+	for len(order) < n {
+		last, next := order[len(order)-1], -1
+		for j := 1; j < n; j++ {
+			if !visited[j] && (next < 0 || dist[last][j] < dist[last][next]) {
+				next = j
+			}
+		}
+		visited[next] = true
+		order = append(order, next)
+	}
+
+	// the path is open, so reversing its tail only changes one edge
+	for pass, improved := 0, true; improved && pass < maxPlanPasses; pass++ {
+		improved = false
+		for i := 1; i < n-1; i++ {
+			for j := i + 1; j < n; j++ {
+				delta := dist[order[i-1]][order[j]] - dist[order[i-1]][order[i]]
+				if j+1 < n {
+					delta += dist[order[i]][order[j+1]] - dist[order[j]][order[j+1]]
+				}
+				if delta < -1e-6 {
+					for a, b := i, j; a < b; a, b = a+1, b-1 {
+						order[a], order[b] = order[b], order[a]
+					}
+					improved = true
+				}
+			}
+		}
+	}
+	return order
+}
+
+// PlanTrip orders summits into a trip from start point and splits it into days
+// of at most dailyDistance kilometers. A single leg longer than the budget
+// makes a day of its own.
+func PlanTrip(start [2]float64, summits []SummitsTableItem, dailyDistance float64) *Plan {
+	plan := &Plan{Start: start, DailyDistance: dailyDistance, Days: make([]PlanDay, 0)}
+	points := make([][2]float64, 0, len(summits)+1)
+	points = append(points, start)
+	for _, s := range summits {
+		points = append(points, [2]float64{exactFloat64(s.Lat), exactFloat64(s.Lng)})
+	}
+
+	var total, dayTotal float64
+	order := orderStops(points)
+	for k := 1; k < len(order); k++ {
+		prev, cur := points[order[k-1]], points[order[k]]
+		leg := gpx.HaversineDistance(prev[0], prev[1], cur[0], cur[1])
+		if len(plan.Days) == 0 || (dayTotal > 0 && dayTotal+leg > dailyDistance*1000) {
+			plan.Days = append(plan.Days, PlanDay{})
+			dayTotal = 0
+		}
+		s := summits[order[k]-1]
+		day := &plan.Days[len(plan.Days)-1]
+		day.Stops = append(day.Stops, PlanStop{
+			Id: s.Id, Name: s.Name, Height: s.Height,
+			RidgeId: s.RidgeId, RidgeName: s.RidgeName,
+			Lat: s.Lat, Lng: s.Lng,
+			Distance: roundKm(leg),
+		})
+		dayTotal += leg
+		total += leg
+		day.Distance = roundKm(dayTotal)
+	}
+	plan.TotalDistance = roundKm(total)
+	return plan
+}
+
+// PlanGPX renders the plan as GPX with a route per day and summits as waypoints
+func PlanGPX(plan *Plan) ([]byte, error) {
+	gpxData := gpx.GPX{
+		Version: "1.1",
+		Creator: geoExportTitle,
+	}
+	from := gpx.GPXPoint{
+		Point: gpx.Point{Latitude: plan.Start[0], Longitude: plan.Start[1]},
+		Name:  "Старт",
+	}
+	for i, day := range plan.Days {
+		route := gpx.GPXRoute{
+			Name:        fmt.Sprintf("День %d", i+1),
+			Description: fmt.Sprintf("%.1f км", day.Distance),
+			Points:      []gpx.GPXPoint{from},
+		}
+		for _, stop := range day.Stops {
+			name := stop.Id
+			if stop.Name != nil && *stop.Name != "" {
+				name = *stop.Name
+			}
+			point := gpx.GPXPoint{
+				Point: gpx.Point{
+					Latitude:  exactFloat64(stop.Lat),
+					Longitude: exactFloat64(stop.Lng),
+					Elevation: *gpx.NewNullableFloat64(float64(stop.Height)),
+				},
+				Name:        name,
+				Description: fmt.Sprintf("хр. %s, день %d", stop.RidgeName, i+1),
+			}
+			route.Points = append(route.Points, point)
+			gpxData.AppendWaypoint(&point)
+			from = point
+		}
+		gpxData.AppendRoute(&route)
+	}
+	return gpxData.ToXml(gpx.ToXmlParams{Version: "1.1", Indent: true})
+}
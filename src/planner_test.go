@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+func TestPlanTrip(t *testing.T) {
+	summit := func(id string, lat float32) SummitsTableItem {
+		return SummitsTableItem{Id: id, Lat: lat, Lng: 58.0, RidgeId: "ridge", RidgeName: "Хребет"}
+	}
+	// summits on a meridian, 0.01 degree (about 1.1 km) apart, listed out of order
+	line := []SummitsTableItem{
+		summit("s3", 54.03), summit("s1", 54.01), summit("s5", 54.05),
+		summit("s2", 54.02), summit("s4", 54.04),
+	}
+	start := [2]float64{54.0, 58.0}
+
+	cases := []struct {
+		name          string
+		summits       []SummitsTableItem
+		dailyDistance float64
+		expectedDays  [][]string
+	}{
+		{"single day", line, 20, [][]string{{"s1", "s2", "s3", "s4", "s5"}}},
+		{"split into days", line, 2.5, [][]string{{"s1", "s2"}, {"s3", "s4"}, {"s5"}}},
+		// a leg longer than daily budget still makes a day
+		{"long legs", line, 0.5, [][]string{{"s1"}, {"s2"}, {"s3"}, {"s4"}, {"s5"}}},
+		{"no summits", nil, 20, [][]string{}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := PlanTrip(start, tt.summits, tt.dailyDistance)
+			days := make([][]string, len(plan.Days))
+			var total float64
+			for i, day := range plan.Days {
+				var dayTotal float64
+				for _, stop := range day.Stops {
+					days[i] = append(days[i], stop.Id)
+					dayTotal += stop.Distance
+				}
+				assert.InDelta(t, day.Distance, dayTotal, 0.1)
+				total += day.Distance
+			}
+			assert.Equal(t, tt.expectedDays, days)
+			assert.InDelta(t, plan.TotalDistance, total, 0.1)
+		})
+	}
+}
+
+func TestOrderStops(t *testing.T) {
+	// nearest neighbour goes east to 1, then west to 2 and 3 and then
+	// all the way back east to 4, 2-opt should go west first
+	points := [][2]float64{
+		{54.0, 58.0},
+		{54.0, 58.01},
+		{54.0, 57.98},
+		{54.0, 57.96},
+		{54.0, 58.05},
+	}
+	order := orderStops(points)
+	var length float64
+	for i := 1; i < len(order); i++ {
+		a, b := points[order[i-1]], points[order[i]]
+		length += gpx.HaversineDistance(a[0], a[1], b[0], b[1])
+	}
+	require.Equal(t, 0, order[0])
+	assert.Equal(t, []int{0, 2, 3, 1, 4}, order)
+	// the best path goes west first: 0.04 + 0.09 degrees of longitude
+	best := gpx.HaversineDistance(54.0, 58.0, 54.0, 57.96) + gpx.HaversineDistance(54.0, 57.96, 54.0, 58.05)
+	assert.InDelta(t, best, length, 1)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// summitPreviewKey is where generated preview of summit image is stored.
+// Images without preview_url in catalog get this one on loading.
+func summitPreviewKey(url string) string {
+	return RenditionKey(summitImageBase(url), ImageMedium, FormatJPEG)
+}
+
+func summitImageBase(url string) string {
+	return strings.TrimSuffix(url, path.Ext(url))
+}
+
+// FetchGeneratedPreviews returns summit images which previews
+// are to be generated rather than set in catalog
+func (s *Storage) FetchGeneratedPreviews() ([]SummitImage, error) {
+	rows, err := s.db.Query(`SELECT url, preview_url, comment FROM summit_images ORDER BY url`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	images := make([]SummitImage, 0)
+	for rows.Next() {
+		var img SummitImage
+		if err := rows.Scan(&img.Url, &img.PreviewUrl, &img.Comment); err != nil {
+			return nil, err
+		}
+		if img.PreviewUrl == summitPreviewKey(img.Url) {
+			images = append(images, img)
+		}
+	}
+	return images, rows.Err()
+}
+
+// GenerateSummitPreviews makes renditions of summit images missing
+// previews. Originals are read from the image storage, images which
+// previews are stored already are skipped. Failures are logged and
+// the rest of images are processed anyway. Returns number of images processed.
+func GenerateSummitPreviews(ctx context.Context, storage *Storage, im ImageManager) (int, error) {
+	images, err := storage.FetchGeneratedPreviews()
+	if err != nil {
+		return 0, err
+	}
+	generated := 0
+	for _, img := range images {
+		exists, err := im.Exists(ctx, img.PreviewUrl)
+		if err != nil {
+			slog.Error("Failed to check summit image preview", "url", img.PreviewUrl, "error", err)
+			continue
+		}
+		if exists {
+			continue
+		}
+
+		data, err := im.Get(ctx, img.Url)
+		if err != nil {
+			slog.Error("Failed to load summit image", "url", img.Url, "error", err)
+			continue
+		}
+		renditions, err := ProcessImage(data)
+		if err != nil {
+			slog.Error("Failed to process summit image", "url", img.Url, "error", err)
+			continue
+		}
+		if err := UploadRenditions(ctx, im, summitImageBase(img.Url), renditions); err != nil {
+			slog.Error("Failed to upload summit image preview", "url", img.Url, "error", err)
+			continue
+		}
+		generated++
+	}
+	return generated, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateSummitPreviews(t *testing.T) {
+	dataDir := t.TempDir()
+	require.NoError(t, os.CopyFS(dataDir, os.DirFS("testdata/summits")))
+	// kirel images come without previews
+	kirelFile := path.Join(dataDir, "malidak", "kirel.yaml")
+	kirel, err := os.ReadFile(kirelFile)
+	require.NoError(t, err)
+	lines := make([]string, 0)
+	for _, line := range strings.Split(string(kirel), "\n") {
+		if !strings.Contains(line, "preview_url") {
+			lines = append(lines, line)
+		}
+	}
+	require.NoError(t, os.WriteFile(kirelFile, []byte(strings.Join(lines, "\n")), 0644))
+
+	storage := NewStorage(MockDatabase(t))
+	_, err = storage.LoadSummits(dataDir)
+	require.NoError(t, err)
+	images, err := storage.FetchSummitImages("kirel")
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	assert.Equal(t, "summits/kirel_s_otnurka_0_M.jpg", images[0].PreviewUrl)
+	images, err = storage.FetchSummitImages("malinovaja")
+	require.NoError(t, err)
+	assert.Equal(t, "summits/malinovaja_s_otnurka_0_preview.jpg", images[0].PreviewUrl, "preview from catalog is kept")
+
+	generated, err := storage.FetchGeneratedPreviews()
+	require.NoError(t, err)
+	require.Len(t, generated, 2)
+
+	// originals are read from the image storage
+	imagesDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(path.Join(imagesDir, "summits"), 0755))
+	require.NoError(t, os.WriteFile(path.Join(imagesDir, "summits", "kirel_s_otnurka_0.jpg"), testJPEG(t, 800, 600), 0644))
+	im := NewMockImageManager(imagesDir)
+
+	n, err := GenerateSummitPreviews(context.Background(), storage, im)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n, "missing original is skipped")
+	for _, key := range renditionKeys("summits/kirel_s_otnurka_0") {
+		assert.FileExists(t, path.Join(imagesDir, key))
+	}
+
+	n, err = GenerateSummitPreviews(context.Background(), storage, im)
+	require.NoError(t, err)
+	assert.Zero(t, n, "existing previews are not generated again")
+
+	t.Run("local storage", func(t *testing.T) {
+		imagesDir := t.TempDir()
+		require.NoError(t, os.MkdirAll(path.Join(imagesDir, "summits"), 0755))
+		require.NoError(t, os.WriteFile(path.Join(imagesDir, "summits", "kirel_vershina_1.jpg"), testJPEG(t, 800, 600), 0644))
+		im, err := NewLocalImageManager(imagesDir)
+		require.NoError(t, err)
+
+		n, err := GenerateSummitPreviews(context.Background(), storage, im)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.FileExists(t, path.Join(imagesDir, "summits", "kirel_vershina_1_M.jpg"))
+	})
+}
@@ -9,7 +9,6 @@ import (
 	"net/http"
 	"os"
 	"strconv"
-	"sync"
 
 	"golang.org/x/oauth2"
 )
@@ -32,6 +31,7 @@ type AuthProviders map[string]Provider
 
 type VKUser struct {
 	Id           int    `json:"id"`
+	PhotoMaxOrig string `json:"photo_max_orig"`
 	Photo200Orig string `json:"photo_200_orig"`
 	Photo50      string `json:"photo_50"`
 	FirstName    string `json:"first_name"`
@@ -80,7 +80,7 @@ func (provider *VKProvider) Register(token *oauth2.Token, storage *Storage, ctx
 	query := req.URL.Query()
 	query.Add("v", "5.131")
 	query.Add("lang", "ru")
-	query.Add("fields", "photo_50, photo_200_orig, has_photo")
+	query.Add("fields", "photo_50, photo_200_orig, photo_max_orig, has_photo")
 	req.URL.RawQuery = query.Encode()
 	resp, err := oauthClient.Do(req)
 	if err != nil {
@@ -112,42 +112,42 @@ func (provider *VKProvider) Register(token *oauth2.Token, storage *Storage, ctx
 	slog.Info("User created", "userId", userId, "provider", "vk", "name", userName)
 	// load images. If download failed, just log it and proceed
 	if userData.HasPhoto > 0 {
-		wg := sync.WaitGroup{}
-		for _, img := range []struct {
-			url  string
-			size string
-		}{
-			{userData.Photo50, ImageSmall},
-			{userData.Photo200Orig, ImageMedium},
-		} {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				imageData, err := downloadImage(*oauthClient, img.url)
-				if err != nil {
-					slog.Error("Failed to load image for user", "userId", userId, "error", err)
-					return
-				}
-				imageKey := fmt.Sprintf("users/%d_%s.jpg", userId, img.size)
-
-				// using background context to allow images to be uploaded after request is finished
-				err = provider.imageManager.Upload(ctx, imageData, imageKey)
-				if err != nil {
-					slog.Error("Failed to upload image to S3 for user", "userId", userId, "error", err)
-					return
-				}
-
-				err = storage.UpdateUserImage(userId, img.size, imageKey)
-				if err != nil {
-					slog.Error("Failed to store image for user", "userId", userId, "error", err)
-				}
-			}()
+		// renditions are made of the largest photo available
+		photoUrl := userData.PhotoMaxOrig
+		if photoUrl == "" {
+			photoUrl = userData.Photo200Orig
 		}
-		wg.Wait()
+		storeUserImages(ctx, oauthClient, provider.imageManager, storage, userId, photoUrl)
 	}
 	return userId, nil
 }
 
+// storeUserImages downloads user's photo, uploads its renditions
+// and records them as user's images. Failures are only logged.
+func storeUserImages(ctx context.Context, client *http.Client, im ImageManager, storage *Storage, userId int64, url string) {
+	imageData, err := downloadImage(*client, url)
+	if err != nil {
+		slog.Error("Failed to load image for user", "userId", userId, "error", err)
+		return
+	}
+	renditions, err := ProcessImage(imageData)
+	if err != nil {
+		slog.Error("Failed to process image for user", "userId", userId, "error", err)
+		return
+	}
+	base := fmt.Sprintf("users/%d", userId)
+	if err := UploadRenditions(ctx, im, base, renditions); err != nil {
+		slog.Error("Failed to upload image for user", "userId", userId, "error", err)
+		return
+	}
+	for _, size := range []string{ImageSmall, ImageMedium, ImageLarge} {
+		err = storage.UpdateUserImage(userId, size, RenditionKey(base, size, FormatJPEG))
+		if err != nil {
+			slog.Error("Failed to store image for user", "userId", userId, "error", err)
+		}
+	}
+}
+
 func downloadImage(client http.Client, url string) ([]byte, error) {
 	resp, err := client.Get(url)
 	if err != nil {
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image/jpeg"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -12,8 +14,10 @@ import (
 	"path"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
 )
 
@@ -45,6 +49,38 @@ func (im *MockImageManager) Upload(ctx context.Context, imageData []byte, key st
 	return nil
 }
 
+func (im *MockImageManager) Delete(ctx context.Context, key string) error {
+	err := os.Remove(path.Join(im.tempDir, key))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete image from temp directory: %v", err)
+	}
+	return nil
+}
+
+func (im *MockImageManager) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := os.Stat(path.Join(im.tempDir, key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (im *MockImageManager) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(path.Join(im.tempDir, key))
+	if os.IsNotExist(err) {
+		return nil, errImageNotFound
+	}
+	return data, err
+}
+
+func (im *MockImageManager) URL(key string) string {
+	return "https://images.example.com/" + key
+}
+
+func (im *MockImageManager) SignedURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return fmt.Sprintf("%s?expires=%d", im.URL(key), int(expires.Seconds())), nil
+}
+
 func TestVkGetUserId(t *testing.T) {
 	vk := &VKProvider{}
 
@@ -100,6 +136,7 @@ func HandleUserGet(w http.ResponseWriter, r *http.Request) {
 			{
 				"id": %d,
 				"photo_200_orig": "http://%s/img/ava_m.jpg",
+				"photo_max_orig": "http://%s/img/ava_m.jpg",
 				"has_photo": 1,
 				"photo_50": "http://%s/img/ava_s.jpg",
 				"first_name": "Climbing",
@@ -136,7 +173,7 @@ func HandleUserGet(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, errorResponse)
 	} else {
 		userId, _ := strconv.Atoi(MockOauthUserId)
-		fmt.Fprintf(w, successfulResponse, userId, r.Host, r.Host)
+		fmt.Fprintf(w, successfulResponse, userId, r.Host, r.Host, r.Host)
 	}
 
 }
@@ -204,17 +241,20 @@ func TestVKRegister(t *testing.T) {
 		Name:       MockUserName,
 		ImageS:     "users/13_S.jpg",
 		ImageM:     "users/13_M.jpg",
+		ImageL:     "users/13_L.jpg",
 		SocialLink: "https://vk.com/id2343",
 	}
 	if *user != expectedUser {
 		t.Fatalf("Unexpected user created: %v, expected %v", *user, expectedUser)
 	}
+	// renditions are made of the largest photo, 200x250
 	cases := []struct {
-		size     string
-		expected string
+		size          string
+		width, height int
 	}{
-		{ImageSmall, "testdata/ava_s.jpg"},
-		{ImageMedium, "testdata/ava_m.jpg"},
+		{ImageSmall, 96, 120},
+		{ImageMedium, 200, 250},
+		{ImageLarge, 200, 250},
 	}
 	for _, tt := range cases {
 		img, err := storage.GetUserImage(userId, tt.size)
@@ -228,11 +268,10 @@ func TestVKRegister(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Failed to read image for user %d: %v", userId, err)
 		}
-		expectedImg, err := os.ReadFile(tt.expected)
-		if err != nil {
-			t.Fatalf("Failed to read image for user %d: %v", userId, err)
-		}
-		assert.Equal(t, expectedImg, uploadedImg)
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(uploadedImg))
+		require.NoError(t, err)
+		assert.Equal(t, []int{tt.width, tt.height}, []int{cfg.Width, cfg.Height})
+		assert.FileExists(t, path.Join(imageDir, fmt.Sprintf("users/%d_%s.webp", userId, tt.size)))
 	}
 }
 
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"database/sql"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Full-text search over summits catalog.
+//
+// Summits are looked up in summits_search FTS5 table which is rebuilt
+// by LoadSummits. Names are additionally indexed in latin transliteration,
+// so "Iremel" finds "Иремель" and vice versa. If full-text search finds
+// less summits than requested, the rest are matched by names with
+// a small edit distance to tolerate typos.
+//
+// FTS5 is enabled in go-sqlite3 with sqlite_fts5 build tag.
+
+// Weights of summits_search columns used for ranking,
+// in the order of columns in the table
+var searchColumnWeights = []float64{
+	0,  // summit_id
+	10, // name
+	5,  // name_alt
+	2,  // ridge
+	1,  // description
+	8,  // translit
+}
+
+// Fuzzy matches are always ranked lower than full-text ones
+const searchFuzzyWeight = 0.5
+
+// Matched words are marked by FTS5 highlight and snippet functions
+// with these, so that text is html-escaped before they become tags
+const (
+	searchMatchStart = "\x01"
+	searchMatchEnd   = "\x02"
+)
+
+var translitTable = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	// bashkir letters
+	'ә': "a", 'ө': "o", 'ү': "u", 'ғ': "g", 'ҡ': "k", 'ң': "n", 'ҙ': "z", 'ҫ': "s", 'һ': "h",
+}
+
+var htmlTagRe = regexp.MustCompile(`<[^>]*>`)
+
+// transliterate converts text to lowercase latin
+func transliterate(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(text) {
+		if t, ok := translitTable[r]; ok {
+			b.WriteString(t)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// searchTokens splits text into lowercase words
+func searchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// plainText strips html markup used in summit descriptions
+func plainText(text string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(htmlTagRe.ReplaceAllString(text, " "))), " ")
+}
+
+func nullString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// buildSearchIndex refills summits_search table from summits loaded in transaction
+func buildSearchIndex(tx *sql.Tx) error {
+	if _, err := tx.Exec("DELETE FROM summits_search"); err != nil {
+		return err
+	}
+	rows, err := tx.Query(`SELECT s.id, s.name, s.name_alt, r.name, s.description
+		FROM summits s INNER JOIN ridges r ON s.ridge_id = r.id`)
+	if err != nil {
+		return err
+	}
+	type indexItem struct {
+		id, name, nameAlt, ridge, description string
+	}
+	items := make([]indexItem, 0)
+	for rows.Next() {
+		var item indexItem
+		var name, nameAlt, description sql.NullString
+		if err := rows.Scan(&item.id, &name, &nameAlt, &item.ridge, &description); err != nil {
+			rows.Close()
+			return err
+		}
+		item.name, item.nameAlt, item.description = name.String, nameAlt.String, plainText(description.String)
+		items = append(items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO summits_search
+		(summit_id, name, name_alt, ridge, description, translit) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, item := range items {
+		translit := transliterate(strings.Join([]string{item.name, item.nameAlt, item.ridge}, " "))
+		_, err := stmt.Exec(item.id, item.name, item.nameAlt, item.ridge, item.description, translit)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// levenshtein returns edit distance between two words
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// maxTypos returns number of typos tolerated in a word of given length
+func maxTypos(word string) int {
+	switch n := len([]rune(word)); {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// wordMatches tells if text word matches query token (both transliterated),
+// returning the edit distance. Prefix match is an exact one.
+func wordMatches(word, token string) (int, bool) {
+	if strings.HasPrefix(word, token) {
+		return 0, true
+	}
+	limit := maxTypos(token)
+	if limit == 0 {
+		return 0, false
+	}
+	// compare with the word prefix of the same length as well
+	// to tolerate typos in partially typed words
+	d := levenshtein(word, token)
+	if wr := []rune(word); len(wr) > len([]rune(token)) {
+		d = min(d, levenshtein(string(wr[:len([]rune(token))]), token))
+	}
+	return d, d <= limit
+}
+
+// highlight wraps words of text matching any of query tokens in <b> tags.
+// Typos are tolerated only if fuzzy is set. Text is html-escaped.
+// Returns false if nothing matched.
+func highlight(text string, tokens []string, fuzzy bool) (string, bool) {
+	var b strings.Builder
+	matched := false
+	start := -1
+	flush := func(end int) {
+		word := text[start:end]
+		isMatch := false
+		for _, token := range tokens {
+			if d, ok := wordMatches(transliterate(word), token); ok && (fuzzy || d == 0) {
+				isMatch = true
+				break
+			}
+		}
+		if isMatch {
+			matched = true
+			b.WriteString("<b>" + html.EscapeString(word) + "</b>")
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+		start = -1
+	}
+	for i, r := range text {
+		isWordRune := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWordRune && start < 0 {
+			start = i
+		}
+		if !isWordRune {
+			if start >= 0 {
+				flush(i)
+			}
+			b.WriteString(html.EscapeString(string(r)))
+		}
+	}
+	if start >= 0 {
+		flush(len(text))
+	}
+	return b.String(), matched
+}
+
+type SearchResult struct {
+	Id        string  `json:"id"`
+	Name      *string `json:"name"`
+	NameAlt   *string `json:"name_alt"`
+	Height    int     `json:"height"`
+	RidgeId   string  `json:"ridge_id"`
+	RidgeName string  `json:"ridge"`
+	Snippet   string  `json:"snippet"`
+	Score     float64 `json:"score"`
+}
+
+// ftsQuery builds FTS5 MATCH expression: every token should match
+// either as typed or in transliteration
+func ftsQuery(tokens []string) string {
+	groups := make([]string, len(tokens))
+	for i, t := range tokens {
+		if tt := transliterate(t); tt != t {
+			groups[i] = `("` + t + `"* OR "` + tt + `"*)`
+		} else {
+			groups[i] = `"` + t + `"*`
+		}
+	}
+	return strings.Join(groups, " AND ")
+}
+
+// bm25Rank is FTS5 ranking function call with searchColumnWeights
+func bm25Rank() string {
+	weights := make([]string, len(searchColumnWeights))
+	for i, w := range searchColumnWeights {
+		weights[i] = strconv.FormatFloat(w, 'f', -1, 64)
+	}
+	return "bm25(summits_search, " + strings.Join(weights, ", ") + ")"
+}
+
+// markedHTML html-escapes text marked by FTS5 and turns marks into <b> tags.
+// Returns false if nothing is marked.
+func markedHTML(text string) (string, bool) {
+	if !strings.Contains(text, searchMatchStart) {
+		return "", false
+	}
+	escaped := html.EscapeString(text)
+	return strings.NewReplacer(searchMatchStart, "<b>", searchMatchEnd, "</b>").Replace(escaped), true
+}
+
+// searchFullText returns up to limit summits matching all tokens, best first.
+// Snippet is taken from the first column of names and description
+// where FTS5 marks a match.
+func (s *Storage) searchFullText(tokens []string, limit int) ([]SearchResult, error) {
+	rank := bm25Rank()
+	rows, err := s.db.Query(`SELECT s.id, s.name, s.name_alt, s.height, r.id, r.name, -`+rank+`,
+			highlight(summits_search, 1, ?1, ?2),
+			highlight(summits_search, 2, ?1, ?2),
+			highlight(summits_search, 3, ?1, ?2),
+			snippet(summits_search, 4, ?1, ?2, '…', 16)
+		FROM summits_search
+			INNER JOIN summits s ON s.id = summits_search.summit_id
+			INNER JOIN ridges r ON s.ridge_id = r.id
+		WHERE summits_search MATCH ?3
+		ORDER BY `+rank+`, s.height DESC
+		LIMIT ?4`, searchMatchStart, searchMatchEnd, ftsQuery(tokens), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]SearchResult, 0)
+	for rows.Next() {
+		var r SearchResult
+		var marked [4]string
+		if err := rows.Scan(&r.Id, &r.Name, &r.NameAlt, &r.Height, &r.RidgeId, &r.RidgeName, &r.Score,
+			&marked[0], &marked[1], &marked[2], &marked[3]); err != nil {
+			return nil, err
+		}
+		for _, text := range marked {
+			if snippet, ok := markedHTML(text); ok {
+				r.Snippet = snippet
+				break
+			}
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// searchFuzzy matches summits by names tolerating typos: every query token
+// should be close enough to some word of summit or ridge names.
+// Summits in skip are not matched.
+func (s *Storage) searchFuzzy(translitTokens []string, skip map[string]bool) ([]SearchResult, error) {
+	rows, err := s.db.Query(`SELECT s.id, s.name, s.name_alt, s.height, r.id, r.name
+		FROM summits s INNER JOIN ridges r ON s.ridge_id = r.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := make([]SearchResult, 0)
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.Id, &r.Name, &r.NameAlt, &r.Height, &r.RidgeId, &r.RidgeName); err != nil {
+			return nil, err
+		}
+		if skip[r.Id] {
+			continue
+		}
+		words := searchTokens(transliterate(
+			strings.Join([]string{nullString(r.Name), nullString(r.NameAlt), r.RidgeName}, " ")))
+		closeness := 0.0
+		for _, token := range translitTokens {
+			best := -1
+			for _, w := range words {
+				if d, ok := wordMatches(w, token); ok && (best < 0 || d < best) {
+					best = d
+				}
+			}
+			if best < 0 {
+				closeness = 0
+				break
+			}
+			closeness += 1 / float64(1+best)
+		}
+		if closeness > 0 {
+			r.Score = closeness / float64(len(translitTokens))
+			results = append(results, r)
+		}
+	}
+	return results, rows.Err()
+}
+
+// SearchSummits returns up to limit summits matching the query, best matches first
+func (s *Storage) SearchSummits(query string, limit int) ([]SearchResult, error) {
+	tokens := searchTokens(query)
+	if len(tokens) == 0 || limit <= 0 {
+		return make([]SearchResult, 0), nil
+	}
+	translitTokens := make([]string, len(tokens))
+	for i, t := range tokens {
+		translitTokens[i] = transliterate(t)
+	}
+
+	results, err := s.searchFullText(tokens, limit)
+	if err != nil {
+		return nil, err
+	}
+	// matches in transliteration only are not marked by FTS5
+	for i := range results {
+		if results[i].Snippet != "" {
+			continue
+		}
+		for _, text := range []string{nullString(results[i].Name), nullString(results[i].NameAlt), results[i].RidgeName} {
+			if snippet, ok := highlight(text, translitTokens, false); ok {
+				results[i].Snippet = snippet
+				break
+			}
+		}
+	}
+	if len(results) >= limit {
+		return results, nil
+	}
+
+	found := make(map[string]bool, len(results))
+	// fuzzy scores are scaled below the lowest full-text one
+	base := 1.0
+	for _, r := range results {
+		found[r.Id] = true
+		if r.Score > 0 {
+			base = min(base, r.Score)
+		}
+	}
+	fuzzy, err := s.searchFuzzy(translitTokens, found)
+	if err != nil {
+		return nil, err
+	}
+	for i := range fuzzy {
+		fuzzy[i].Score *= base * searchFuzzyWeight
+		for _, text := range []string{nullString(fuzzy[i].Name), nullString(fuzzy[i].NameAlt), fuzzy[i].RidgeName} {
+			if snippet, ok := highlight(text, translitTokens, true); ok {
+				fuzzy[i].Snippet = snippet
+				break
+			}
+		}
+	}
+	sort.Slice(fuzzy, func(i, j int) bool {
+		if fuzzy[i].Score != fuzzy[j].Score {
+			return fuzzy[i].Score > fuzzy[j].Score
+		}
+		return fuzzy[i].Height > fuzzy[j].Height
+	})
+	results = append(results, fuzzy...)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransliterate(t *testing.T) {
+	assert.Equal(t, "iremel", transliterate("Иремель"))
+	assert.Equal(t, "yamantau", transliterate("Ямантау"))
+	assert.Equal(t, "bolshoy shelom", transliterate("Большой Шелом"))
+	assert.Equal(t, "iremel", transliterate("Iremel"))
+}
+
+func TestSearchSummits(t *testing.T) {
+	db := MockDatabase(t)
+	storage := NewStorage(db)
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name            string
+		query           string
+		expectedIds     []string
+		expectedSnippet string
+	}{
+		{"exact name", "Кирель", []string{"kirel"}, "<b>Кирель</b>"},
+		{"name prefix", "кир", []string{"kirel"}, "<b>Кирель</b>"},
+		{"transliterated name", "Kirel", []string{"kirel"}, "<b>Кирель</b>"},
+		{"typo in name", "Кирнль", []string{"kirel"}, "<b>Кирель</b>"},
+		{"alternative name", "Malinovka", []string{"malinovaja"}, "<b>Малиновка</b>, Елэкташ"},
+		{"ridge name", "Столбы", []string{"stolby", "1021"}, "<b>Столбы</b>"},
+		{"description", "курумов", []string{"kirel"}, "Лесистая с очень крутыми склонами, <b>курумов</b> мало."},
+		{"several words", "скал дугой", []string{"malinovaja"}, "Группа <b>скал</b>, расположенных <b>дугой</b>"},
+		{"no match", "Эверест", []string{}, ""},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := storage.SearchSummits(tt.query, 10)
+			require.NoError(t, err)
+			ids := make([]string, len(results))
+			for i, r := range results {
+				ids[i] = r.Id
+			}
+			assert.Equal(t, tt.expectedIds, ids)
+			if len(results) > 0 {
+				assert.Equal(t, tt.expectedSnippet, results[0].Snippet)
+			}
+		})
+	}
+}
+
+func TestSearchSummitsRanking(t *testing.T) {
+	storage := NewStorage(MockDatabase(t))
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+
+	results, err := storage.SearchSummits("Столбы", 1)
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	// typo in the name is tolerated, but ranked lower than the exact match
+	results, err = storage.SearchSummits("Малиновая", 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, results)
+	assert.Equal(t, "malinovaja", results[0].Id)
+	for i := 1; i < len(results); i++ {
+		assert.GreaterOrEqual(t, results[i-1].Score, results[i].Score)
+	}
+	fuzzy, err := storage.SearchSummits("Малинвая", 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, fuzzy)
+	assert.Equal(t, "malinovaja", fuzzy[0].Id)
+	assert.Less(t, fuzzy[0].Score, results[0].Score, "fuzzy matches rank lower")
+}
@@ -46,22 +46,22 @@ INSERT INTO "users" VALUES(12,'6489',2,'Carolyn Pierce');
 INSERT INTO user_images VALUES (12, 'M', 'users/12_M.jpg');
 INSERT INTO user_images VALUES (12, 'S', 'users/12_S.jpg');
 
-INSERT INTO "climbs" VALUES(1,'kurkak',1990,9,6,'Cloned heuristic middleware');
-INSERT INTO "climbs" VALUES(2,'kurkak',1992,4,18,'Down-sized coherent service-desk');
-INSERT INTO "climbs" VALUES(3,'kurkak',2012,3,7,'Expanded secondary alliance');
-INSERT INTO "climbs" VALUES(4,'kurkak',1994,9,26,'Customizable impactful info-mediaries');
-INSERT INTO "climbs" VALUES(5,'kurkak',1990,3,7,'Future-proofed optimizing methodology');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(1,'kurkak',1990,9,6,'Cloned heuristic middleware');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(2,'kurkak',1992,4,18,'Down-sized coherent service-desk');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(3,'kurkak',2012,3,7,'Expanded secondary alliance');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(4,'kurkak',1994,9,26,'Customizable impactful info-mediaries');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(5,'kurkak',1990,3,7,'Future-proofed optimizing methodology');
 -- climb for legacy id
-INSERT INTO "climbs" VALUES(6,'1026',2016,5,12,'Implemented 24hour ability');
-INSERT INTO "climbs" VALUES(7,'kurkak',2010,12,15,'Digitized global intranet');
-INSERT INTO "climbs" VALUES(9,'kurkak',1998,3,24,'Fundamental full-range paradigm');
-INSERT INTO "climbs" VALUES(10,'kurkak',1995,12,NULL,'Organized mobile data-warehouse');
-INSERT INTO "climbs" VALUES(11,'kurkak',2015,6,NULL,'Exclusive heuristic matrix');
-INSERT INTO "climbs" VALUES(5,'kirel',NULL,NULL,NULL,'Profit-focused demand-driven core');
-INSERT INTO "climbs" VALUES(7,'kirel',2002,11,5,'Decentralized didactic customer loyalty');
-INSERT INTO "climbs" VALUES(9,'kirel',2001,NULL,NULL,'Re-contextualized fresh-thinking complexity');
-INSERT INTO "climbs" VALUES(10,'kirel',2001,11,1,'Implemented attitude-oriented framework');
-INSERT INTO "climbs" VALUES(11,'kirel',2001,11,NULL,'Intuitive responsive website');
-INSERT INTO "climbs" VALUES(8,'kirel',2015,8,15,'Progressive holistic firmware');
-INSERT INTO "climbs" VALUES(5,'malinovaja',NULL,NULL,NULL,'Secured national open architecture');
-INSERT INTO "climbs" VALUES(9,'malinovaja',2002,NULL,NULL,'Down-sized intermediate framework');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(6,'1026',2016,5,12,'Implemented 24hour ability');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(7,'kurkak',2010,12,15,'Digitized global intranet');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(9,'kurkak',1998,3,24,'Fundamental full-range paradigm');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(10,'kurkak',1995,12,NULL,'Organized mobile data-warehouse');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(11,'kurkak',2015,6,NULL,'Exclusive heuristic matrix');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(5,'kirel',NULL,NULL,NULL,'Profit-focused demand-driven core');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(7,'kirel',2002,11,5,'Decentralized didactic customer loyalty');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(9,'kirel',2001,NULL,NULL,'Re-contextualized fresh-thinking complexity');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(10,'kirel',2001,11,1,'Implemented attitude-oriented framework');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(11,'kirel',2001,11,NULL,'Intuitive responsive website');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(8,'kirel',2015,8,15,'Progressive holistic firmware');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(5,'malinovaja',NULL,NULL,NULL,'Secured national open architecture');
+INSERT INTO "climbs" (user_id, summit_id, year, month, day, comment) VALUES(9,'malinovaja',2002,NULL,NULL,'Down-sized intermediate framework');
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+const (
+	// DefaultTrackRadius is how close to the summit, in meters,
+	// a track has to pass to verify the climb
+	DefaultTrackRadius = 100.0
+	maxTrackSize       = 10 << 20
+)
+
+// South Urals live in Yekaterinburg time which has no DST,
+// so track timestamps are converted to local dates with a fixed offset
+var summitsTimeZone = time.FixedZone("YEKT", 5*60*60)
+
+var errEmptyTrack = errors.New("track has no points")
+
+type ClimbTrack struct {
+	Gpx      []byte
+	Verified bool
+	// Distance is the closest approach to the summit in meters
+	Distance float64
+	// Date is a local date when the track came closest to the summit,
+	// empty if the track has no timestamps
+	Date InexactDate
+}
+
+// AnalyzeTrack finds the closest approach of GPX track to the point
+// and checks whether it is within radius (in meters)
+func AnalyzeTrack(data []byte, lat, lng, radius float64) (*ClimbTrack, error) {
+	g, err := gpx.ParseBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse gpx: %v", err)
+	}
+	track := &ClimbTrack{Gpx: data, Distance: math.Inf(1)}
+	var closestTime, firstTime time.Time
+	for _, t := range g.Tracks {
+		for _, seg := range t.Segments {
+			for _, p := range seg.Points {
+				if firstTime.IsZero() {
+					firstTime = p.Timestamp
+				}
+				d := gpx.HaversineDistance(lat, lng, p.Latitude, p.Longitude)
+				if d < track.Distance {
+					track.Distance = d
+					closestTime = p.Timestamp
+				}
+			}
+		}
+	}
+	if math.IsInf(track.Distance, 1) {
+		return nil, errEmptyTrack
+	}
+	track.Verified = track.Distance <= radius
+
+	ts := closestTime
+	if ts.IsZero() {
+		ts = firstTime
+	}
+	if !ts.IsZero() {
+		local := ts.In(summitsTimeZone)
+		track.Date = InexactDate{int64(local.Year()), int64(local.Month()), int64(local.Day())}
+	}
+	return track, nil
+}
+
+// saveClimbTrack attaches track to the ascent replacing the previous one,
+// it is saved within the transaction changing the ascent
+func saveClimbTrack(tx *sql.Tx, ascentId int64, track *ClimbTrack) error {
+	_, err := tx.Exec(`INSERT INTO climb_tracks (climb_id, gpx, verified, distance, uploaded_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (climb_id) DO UPDATE SET
+			gpx=excluded.gpx, verified=excluded.verified,
+			distance=excluded.distance, uploaded_at=excluded.uploaded_at`,
+		ascentId, track.Gpx, track.Verified, track.Distance, time.Now().UTC())
+	return err
+}
+
+// FetchClimbTrack returns GPX track of user's ascent, or nil if there is none
+func (s *Storage) FetchClimbTrack(ascentId, userId int64) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT t.gpx FROM climb_tracks t
+		INNER JOIN climbs c ON c.id = t.climb_id
+		WHERE t.climb_id = ? AND c.user_id = ?`, ascentId, userId).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return data, err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeTrack(t *testing.T) {
+	kirel, err := os.ReadFile("testdata/tracks/kirel.gpx")
+	require.NoError(t, err)
+
+	noTimestamps := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1"><trk><trkseg>
+	<trkpt lat="54.0286" lon="58.2311"></trkpt>
+</trkseg></trk></gpx>`)
+	waypointsOnly := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1">
+	<wpt lat="54.0286" lon="58.2311"></wpt>
+</gpx>`)
+
+	cases := []struct {
+		name             string
+		data             []byte
+		lat, lng         float64
+		expectedVerified bool
+		expectedDate     InexactDate
+		expectedErr      bool
+	}{
+		// closest point is reached at 20:30 UTC, which is the next day in local time
+		{"summit reached", kirel, 54.0286, 58.2311, true, InexactDate{2023, 7, 15}, false},
+		{"summit missed", kirel, 54.0500, 58.2500, false, InexactDate{2023, 7, 15}, false},
+		{"no timestamps", noTimestamps, 54.0286, 58.2311, true, InexactDate{}, false},
+		{"no track points", waypointsOnly, 54.0286, 58.2311, false, InexactDate{}, true},
+		{"not a gpx", []byte("hello"), 54.0286, 58.2311, false, InexactDate{}, true},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			track, err := AnalyzeTrack(tt.data, tt.lat, tt.lng, DefaultTrackRadius)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedVerified, track.Verified)
+			assert.Equal(t, tt.expectedDate, track.Date)
+			assert.Equal(t, tt.data, track.Gpx)
+		})
+	}
+}
+
+func TestClimbSavedWithTrack(t *testing.T) {
+	db := MockDatabase(t)
+	storage := NewStorage(db)
+	_, err := storage.LoadSummits("testdata/summits")
+	require.NoError(t, err)
+	track := &ClimbTrack{Gpx: []byte("<gpx/>"), Verified: true}
+
+	ascentId, err := storage.AddAscent("kirel", 2, InexactDate{2023, 7, 15}, "", track)
+	require.NoError(t, err)
+	ascent, err := storage.FetchAscent(ascentId, 2)
+	require.NoError(t, err)
+	assert.True(t, ascent.HasTrack)
+	assert.True(t, ascent.TrackVerified)
+
+	// climb is not changed when its track fails to save
+	_, err = db.Exec(`DROP TABLE climb_tracks`)
+	require.NoError(t, err)
+	_, err = storage.AddAscent("kirel", 2, InexactDate{2024, 7, 15}, "", track)
+	assert.Error(t, err)
+	_, err = storage.UpdateClimb("stolby", 2, InexactDate{2024, 7, 15}, "", track)
+	assert.Error(t, err)
+	_, err = storage.UpdateAscent(ascentId, 2, InexactDate{2024, 7, 15}, "edited", track)
+	assert.Error(t, err)
+
+	var climbs int
+	var comment string
+	err = db.QueryRow(`SELECT COUNT(*), MAX(comment) FROM climbs
+		WHERE user_id = 2 AND summit_id IN ('kirel', 'stolby')`).Scan(&climbs, &comment)
+	require.NoError(t, err)
+	assert.Equal(t, 1, climbs)
+	assert.Empty(t, comment)
+}
@@ -1,3 +1,4 @@
 export const config = {
-    imageUrl: "https://s3.timeweb.cloud/302f9aa7-62c4d4d3-ccfd-4077-86c8-cca52e0da376/",
+    // the server serves images at /images/ whatever storage is used
+    imageUrl: import.meta.env.VITE_IMAGE_URL || "/images/",
 };
\ No newline at end of file
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Rough bounding box of the South Urals. Summits outside of it
+// almost always mean swapped or mistyped coordinates.
+const (
+	SouthUralsMinLat = 51.0
+	SouthUralsMaxLat = 56.5
+	SouthUralsMinLng = 55.0
+	SouthUralsMaxLng = 62.0
+)
+
+var yamlErrorLineRe = regexp.MustCompile(`line (\d+)`)
+
+type CatalogIssue struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (ci CatalogIssue) String() string {
+	if ci.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", ci.File, ci.Line, ci.Message)
+	}
+	return fmt.Sprintf("%s: %s", ci.File, ci.Message)
+}
+
+type catalogLocation struct {
+	File string
+	Line int
+}
+
+type parsedSummit struct {
+	Summit
+	File string
+	Doc  *yaml3.Node
+}
+
+type catalogValidator struct {
+	issues  []CatalogIssue
+	summits []*parsedSummit
+}
+
+func (v *catalogValidator) report(file string, line int, format string, args ...any) {
+	v.issues = append(v.issues, CatalogIssue{file, line, fmt.Sprintf(format, args...)})
+}
+
+// reportYAMLError extracts line number from yaml parser error message if possible
+func (v *catalogValidator) reportYAMLError(file string, err error) {
+	line := 0
+	if m := yamlErrorLineRe.FindStringSubmatch(err.Error()); m != nil {
+		line, _ = strconv.Atoi(m[1])
+	}
+	v.report(file, line, "malformed yaml: %v", err)
+}
+
+// yamlMappingValue returns the value node for key in mapping node, or nil
+func yamlMappingValue(mapping *yaml3.Node, key string) *yaml3.Node {
+	if mapping == nil || mapping.Kind != yaml3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// yamlNode returns the value node of top-level mapping key, or nil
+func yamlNode(doc *yaml3.Node, key string) *yaml3.Node {
+	if doc == nil || doc.Kind != yaml3.DocumentNode || len(doc.Content) == 0 {
+		return nil
+	}
+	return yamlMappingValue(doc.Content[0], key)
+}
+
+// yamlLine returns line of the top-level key value, or of its idx-th
+// item if the value is a sequence. Zero is returned when position is unknown.
+func yamlLine(doc *yaml3.Node, key string, idx int) int {
+	node := yamlNode(doc, key)
+	if node == nil {
+		return 0
+	}
+	if idx >= 0 && node.Kind == yaml3.SequenceNode && idx < len(node.Content) {
+		return node.Content[idx].Line
+	}
+	return node.Line
+}
+
+// readYAML decodes file with the same parser as LoadSummits does
+// and additionally parses it into node tree to know line numbers
+func (v *catalogValidator) readYAML(file string, out any) (*yaml3.Node, bool) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		v.report(file, 0, "%v", err)
+		return nil, false
+	}
+	if err := yaml.Unmarshal(data, out); err != nil {
+		v.reportYAMLError(file, err)
+		return nil, false
+	}
+	var doc yaml3.Node
+	if err := yaml3.Unmarshal(data, &doc); err != nil {
+		// line numbers are optional, proceed without them
+		return nil, true
+	}
+	return &doc, true
+}
+
+func (v *catalogValidator) checkRidge(dir string) {
+	metaFile := path.Join(dir, "_meta.yaml")
+	var ridge Ridge
+	if doc, ok := v.readYAML(metaFile, &ridge); ok {
+		if ridge.Name == "" {
+			v.report(metaFile, yamlLine(doc, "name", -1), "ridge name is required")
+		}
+		if ridge.Color == "" {
+			v.report(metaFile, yamlLine(doc, "color", -1), "ridge color is required")
+		}
+	}
+
+	summitFiles, err := os.ReadDir(dir)
+	if err != nil {
+		v.report(dir, 0, "%v", err)
+		return
+	}
+	summitsNum := 0
+	for _, sf := range summitFiles {
+		if (sf.Name() == "_meta.yaml") || sf.IsDir() {
+			continue
+		}
+		summitsNum += 1
+		file := path.Join(dir, sf.Name())
+		summit := &parsedSummit{File: file}
+		doc, ok := v.readYAML(file, &summit.Summit)
+		if !ok {
+			continue
+		}
+		summit.Id = strings.TrimSuffix(sf.Name(), ".yaml")
+		summit.Doc = doc
+		v.checkSummit(summit)
+		v.summits = append(v.summits, summit)
+	}
+	if summitsNum <= 0 {
+		v.report(dir, 0, "empty ridges are not allowed")
+	}
+}
+
+func (v *catalogValidator) checkSummit(s *parsedSummit) {
+	if s.Height == 0 {
+		v.report(s.File, yamlLine(s.Doc, "height", -1), "height is required")
+	}
+	lat, lng := s.Coordinates[0], s.Coordinates[1]
+	if s.Coordinates == [2]float32{0.0, 0.0} {
+		v.report(s.File, yamlLine(s.Doc, "coordinates", -1), "coordinates are required")
+	} else if lat < SouthUralsMinLat || lat > SouthUralsMaxLat || lng < SouthUralsMinLng || lng > SouthUralsMaxLng {
+		v.report(s.File, yamlLine(s.Doc, "coordinates", 0),
+			"coordinates %v, %v are outside of the South Urals", lat, lng)
+	}
+}
+
+// checkCrossReferences looks for conflicts between summits:
+// duplicate ids, legacy ids and images
+func (v *catalogValidator) checkCrossReferences() {
+	mainIds := make(map[string]catalogLocation)
+	for _, s := range v.summits {
+		if prev, ok := mainIds[s.Id]; ok {
+			v.report(s.File, 0, "summit id %s is already used by %s", s.Id, prev.File)
+			continue
+		}
+		mainIds[s.Id] = catalogLocation{s.File, 0}
+	}
+
+	legacyIds := make(map[string]catalogLocation)
+	images := make(map[string]catalogLocation)
+	for _, s := range v.summits {
+		for i, legacyId := range s.LegacyIds {
+			if legacyId == "" || legacyId == s.Id {
+				continue
+			}
+			line := yamlLine(s.Doc, "legacy_ids", i)
+			if main, ok := mainIds[legacyId]; ok {
+				v.report(s.File, line, "legacy id %s collides with summit id from %s", legacyId, main.File)
+			}
+			if prev, ok := legacyIds[legacyId]; ok {
+				v.report(s.File, line, "legacy id %s is already defined at %s:%d", legacyId, prev.File, prev.Line)
+				continue
+			}
+			legacyIds[legacyId] = catalogLocation{s.File, line}
+		}
+		imagesNode := yamlNode(s.Doc, "images")
+		for i, img := range s.Images {
+			line := 0
+			if imagesNode != nil && i < len(imagesNode.Content) {
+				if urlNode := yamlMappingValue(imagesNode.Content[i], "url"); urlNode != nil {
+					line = urlNode.Line
+				}
+			}
+			if img.Url == "" {
+				v.report(s.File, line, "image url is required")
+				continue
+			}
+			if prev, ok := images[img.Url]; ok {
+				v.report(s.File, line, "duplicate image %s, already defined at %s:%d", img.Url, prev.File, prev.Line)
+				continue
+			}
+			images[img.Url] = catalogLocation{s.File, line}
+		}
+	}
+}
+
+// ValidateCatalog checks summits data directory and reports all the problems found,
+// unlike LoadSummits which stops on the first one. Returned error means that
+// validation itself could not be performed.
+func ValidateCatalog(dataDir string) ([]CatalogIssue, error) {
+	v := &catalogValidator{}
+	ridgeDirs, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, ridgeDir := range ridgeDirs {
+		if !ridgeDir.IsDir() || strings.HasPrefix(ridgeDir.Name(), ".") {
+			continue
+		}
+		v.checkRidge(path.Join(dataDir, ridgeDir.Name()))
+	}
+	v.checkCrossReferences()
+
+	// Finally load the catalog into a throwaway database to catch
+	// everything not covered by the checks above
+	db, err := NewMemoryDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if err = Migrate(db); err != nil {
+		return nil, err
+	}
+	_, err = NewStorage(db).LoadSummits(dataDir)
+	if err != nil && len(v.issues) == 0 {
+		v.report(dataDir, 0, "failed to load summits: %v", err)
+	}
+
+	sort.SliceStable(v.issues, func(i, j int) bool {
+		if v.issues[i].File != v.issues[j].File {
+			return v.issues[i].File < v.issues[j].File
+		}
+		return v.issues[i].Line < v.issues[j].Line
+	})
+	return v.issues, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateCatalogValid(t *testing.T) {
+	issues, err := ValidateCatalog("testdata/summits")
+	require.NoError(t, err)
+	assert.Empty(t, issues)
+}
+
+func TestValidateCatalogReportsAllIssues(t *testing.T) {
+	issues, err := ValidateCatalog("testdata/summits_invalid")
+	require.NoError(t, err)
+
+	expected := []string{
+		"testdata/summits_invalid/ridge1/_meta.yaml: ridge color is required",
+		"testdata/summits_invalid/ridge1/broken.yaml:2: malformed yaml: yaml: line 2: did not find expected node content",
+		"testdata/summits_invalid/ridge1/elbrus.yaml:3: coordinates 43.3499, 42.4453 are outside of the South Urals",
+		"testdata/summits_invalid/ridge1/no-height.yaml: height is required",
+		"testdata/summits_invalid/ridge2/peak.yaml:7: legacy id no-height collides with summit id from testdata/summits_invalid/ridge1/no-height.yaml",
+		"testdata/summits_invalid/ridge2/peak.yaml:11: duplicate image summits/peak_0.jpg, already defined at testdata/summits_invalid/ridge1/no-height.yaml:7",
+		"testdata/summits_invalid/ridge3: empty ridges are not allowed",
+	}
+	actual := make([]string, len(issues))
+	for i, issue := range issues {
+		actual[i] = issue.String()
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func TestValidateCatalogBroken(t *testing.T) {
+	// every catalog rejected by LoadSummits should be reported by validator
+	cases := []string{
+		"testdata/summits_broken0",
+		"testdata/summits_broken1",
+		"testdata/summits_broken2",
+		"testdata/summits_broken3",
+		"testdata/summits_broken4",
+	}
+	for _, datadir := range cases {
+		t.Run(datadir, func(t *testing.T) {
+			issues, err := ValidateCatalog(datadir)
+			require.NoError(t, err)
+			assert.NotEmpty(t, issues)
+		})
+	}
+}